@@ -1,3 +1,10 @@
 module github.com/Lithnotep/EncodeChallange
 
 go 1.21
+
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/oschwald/maxminddb-golang v1.13.1
+)
+
+require golang.org/x/sys v0.21.0 // indirect
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Embedded zone database so -tz works without system tzdata
 
 	"github.com/Lithnotep/EncodeChallange/pkg"
 )
@@ -11,14 +21,51 @@ import (
 func main() {
 	// Parse command line flags
 	var year = flag.Int("year", 2021, "Filter clicks by year (default: 2021)")
+	var from = flag.String("from", "", "Only count clicks at or after this time (RFC3339, YYYY-MM-DD or \"last 30d\")")
+	var to = flag.String("to", "", "Only count clicks before this time (RFC3339, YYYY-MM-DD or \"now\")")
+	var granularity = flag.String("granularity", "day", "Time bucket for clicks over time: hour, day, week, month, quarter or year")
+	var tz = flag.String("tz", "UTC", "IANA time zone for year filtering and bucketing (e.g. America/New_York)")
 	var sortDesc = flag.Bool("sort-desc", true, "Sort results in descending order (default: true)")
+	var decodesPath = flag.String("decodes", "data/decodes.json", "Decodes click log paths or globs, comma-separated (- for stdin)")
+	var encodesPath = flag.String("encodes", "data/encodes.csv", "Path to the encodes CSV mapping (- for stdin)")
+	var decodesFormat = flag.String("decodes-format", "auto", "Decodes input format: auto, array or ndjson")
+	var workers = flag.Int("workers", 1, "Aggregation workers (1 = serial, 0 = one per CPU)")
+	var showProgress = flag.Bool("progress", true, "Show a progress line on stderr while streaming")
+	var onError = flag.String("on-error", "fail", "What to do with bad records: fail, skip or deadletter")
+	var maxErrors = flag.String("max-errors", "", "Error budget for skip/deadletter: a count (100) or a percentage (0.5%); empty = unlimited")
+	var deadLetterPath = flag.String("dead-letter", "dead-letter.ndjson", "NDJSON file receiving rejected records with -on-error=deadletter")
+	var botsMode = flag.String("bots", "off", "Bot detection: off, flag (count bot clicks) or exclude (also drop them from totals)")
+	var botRules = flag.String("bot-rules", "", "JSON bot rule file replacing the built-in rules (user-agent, cidr and rate rules)")
+	var geoIPPath = flag.String("geoip-db", "", "Local MaxMind-format (MMDB) city database for clicks by country, region and city")
+	var countries = flag.String("country", "", "Only count clicks from these ISO country codes, comma-separated (needs -geoip-db)")
+	var regions = flag.String("region", "", "Only count clicks from these ISO 3166-2 regions, e.g. US-CA (needs -geoip-db)")
+	var cities = flag.String("city", "", "Only count clicks from these cities, comma-separated (needs -geoip-db)")
+	var where = flag.String("where", "", "Only count records matching this filter expression, e.g. 'referrer == \"t.co\" && year >= 2021'")
+	var groupBy = flag.String("group-by", "", "Pivot clicks by these dimensions, comma-separated, e.g. url,referrer,date (see README for the list)")
+	var pivotSort = flag.String("pivot-sort", "clicks", "Pivot row order: clicks (direction follows -sort-desc) or values")
+	var pivotLimit = flag.Int("pivot-limit", 0, "Maximum pivot rows written to -pivot-out, or children per level with -pivot-nested (0 = all)")
+	var pivotOut = flag.String("pivot-out", "", "Write the -group-by pivot table to this JSON file")
+	var pivotNested = flag.Bool("pivot-nested", false, "Write the pivot table nested, one level per dimension, instead of flat rows")
+	var output = flag.String("format", "text", "Output format: "+strings.Join(pkg.ReporterNames(), ", ")+" (csv and tsv write one file per table in -out-dir)")
+	var outDir = flag.String("out-dir", "results", "Directory receiving the -format=csv|tsv table files")
+	var metricsOut = flag.String("metrics-out", "", "Also write Prometheus metrics to this file, e.g. for node_exporter's textfile collector (replaced atomically)")
+	var metricsAddr = flag.String("metrics-addr", "", "After processing, serve Prometheus metrics at /metrics on this address (e.g. :9101) until interrupted")
+	var unknownOut = flag.String("unknown-out", "", "Write the unknown-bitlink report to this CSV file")
+	var unknownBackfillOut = flag.String("unknown-backfill-out", "", "Write unknown bitlinks as encodes.csv rows (long_url left empty) to this CSV file")
+	var referrerRules = flag.String("referrer-rules", "", "JSON file of referrer aliases and channels extending the built-in tables")
+	var visitorsMode = flag.String("visitors", "off", "Unique visitors per URL, referrer and date: off, hll (estimated) or exact")
+	var visitorPrecision = flag.Uint("visitor-precision", pkg.DefaultHLLPrecision, "HyperLogLog precision for -visitors=hll, 4 to 18 (higher is more accurate and uses 2^p bytes per counter)")
+	var visitorKey = flag.String("visitor-key", "ip", "What identifies a visitor: ip or ip+ua (IP and user agent)")
+	var timestampLayouts layoutList
+	flag.Var(&timestampLayouts, "timestamp-layout", "Extra Go time layout for decode timestamps (repeatable, e.g. \"2006-01-02 15:04:05\")")
+	var timeout = flag.Duration("timeout", 0, "Stop streaming after this long and report partial results (0 = no limit)")
 	var help = flag.Bool("help", false, "Show usage information")
 	flag.Parse()
 
 	if *help {
 		fmt.Println("Encode Challenge Data Processing Tool")
 		fmt.Println("\nUsage:")
-		fmt.Println("  go run main.go [flags]")
+		fmt.Println("  go run main.go [flags] [decodes files or globs...]")
 		fmt.Println("\nFlags:")
 		flag.PrintDefaults()
 		fmt.Println("\nExamples:")
@@ -27,38 +74,527 @@ func main() {
 		fmt.Println("  go run main.go -year=0                   # No year filter (all data)")
 		fmt.Println("  go run main.go -sort-desc=false          # Sort in ascending order")
 		fmt.Println("  go run main.go -year=2020 -sort-desc=false # Year 2020, ascending sort")
+		fmt.Println("  go run main.go -from=2021-03-01 -to=2021-04-01 # March 2021 only")
+		fmt.Println("  go run main.go -from='last 30d'          # Clicks in the last 30 days")
+		fmt.Println("  go run main.go -granularity=month        # Monthly clicks over time")
+		fmt.Println("  go run main.go -tz=Europe/Berlin         # Filter and bucket in Berlin local time")
+		fmt.Println("  go run main.go -decodes-format=ndjson    # Read decodes as one JSON record per line")
+		fmt.Println("  zcat clicks.json.gz | go run main.go -decodes=- # Read decodes from stdin")
+		fmt.Println("  go run main.go 'data/decodes-2021-*.json' # Read every daily shard in order")
+		fmt.Println("  go run main.go -workers=0                # Aggregate in parallel, one worker per CPU")
+		fmt.Println("  go run main.go -timestamp-layout='02/01/2006 15:04' # Also accept day-first timestamps")
+		fmt.Println("  go run main.go -geoip-db=GeoLite2-City.mmdb -country=US,CA # Clicks by place, US and Canada only")
+		fmt.Println("  go run main.go -where='referrer in (\"t.co\", \"twitter.com\") && bitlink ~ \"bit.ly/2k\"' # Filter records")
+		fmt.Println("  go run main.go -group-by=url,referrer,date -granularity=month # Clicks per URL per referrer per month")
+		fmt.Println("  go run main.go -referrer-rules=referrers.json # Add referrer aliases and channels")
+		fmt.Println("  go run main.go -visitors=hll -visitor-key=ip+ua # Estimate unique visitors per URL and day")
+		fmt.Println("  go run main.go -bots=exclude             # Leave crawler and bot clicks out of the totals")
+		fmt.Println("  go run main.go -on-error=skip -max-errors=1% # Skip bad records, fail if more than 1% are bad")
+		fmt.Println("  go run main.go -on-error=deadletter      # Write bad records to dead-letter.ndjson and carry on")
+		fmt.Println("  go run main.go -format=json > results.json # Write the full results as JSON")
+		fmt.Println("  go run main.go -format=csv -out-dir=out  # One CSV file per table, e.g. out/clicks_by_url.csv")
+		fmt.Println("  go run main.go -format=html > report.html # Offline HTML report with charts and sortable tables")
+		fmt.Println("  go run main.go -format=markdown > report.md # Markdown tables, e.g. for a pull request or wiki")
+		fmt.Println("  go run main.go -metrics-out=/var/lib/node_exporter/encode.prom # Metrics for the textfile collector")
+		fmt.Println("  go run main.go -metrics-addr=:9101       # Serve metrics at http://localhost:9101/metrics")
+		fmt.Println("  go run main.go -timeout=30s # Stop after 30s and print partial results")
 		return
 	}
 
-	fmt.Printf("Starting Encode Challenge Data Processing (Year: %d)...\n", *year)
+	format, err := pkg.ParseDecodeFormat(*decodesFormat)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+	reporter, err := pkg.LookupReporter(*output)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+	if tables, ok := reporter.(pkg.TableReporter); ok {
+		tables.Dir = *outDir
+		reporter = tables
+	}
+
+	errorMode, err := pkg.ParseErrorMode(*onError)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+	errorBudget, err := pkg.ParseErrorBudget(*maxErrors)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	bots, excludeBots, err := botDetector(*botsMode, *botRules)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	visitors, err := visitorConfig(*visitorsMode, *visitorPrecision, *visitorKey)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	var filter *pkg.Filter
+	if *where != "" {
+		if filter, err = pkg.CompileFilter(*where); err != nil {
+			log.Printf("Error: -where: %v", err)
+			return
+		}
+		if filter.NeedsGeoIP() && *geoIPPath == "" {
+			log.Printf("Error: -where uses country, region or city, which need -geoip-db")
+			return
+		}
+	}
+
+	dimensions, err := pkg.ParseDimensions(*groupBy)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+	for _, dimension := range dimensions {
+		if dimension.IsGeo() && *geoIPPath == "" {
+			log.Printf("Error: -group-by=%s needs -geoip-db", dimension)
+			return
+		}
+	}
+	if len(dimensions) == 0 && *pivotOut != "" {
+		log.Printf("Error: -pivot-out needs -group-by")
+		return
+	}
+	pivotOrder, err := pkg.ParsePivotSort(*pivotSort)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	var referrers *pkg.ReferrerNormalizer
+	if *referrerRules != "" {
+		if referrers, err = pkg.LoadReferrerRules(*referrerRules); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+	}
+
+	geoFilter := pkg.GeoFilter{Countries: splitList(*countries), Regions: splitList(*regions), Cities: splitList(*cities)}
+	if !geoFilter.IsZero() && *geoIPPath == "" {
+		log.Printf("Error: -country, -region and -city need -geoip-db")
+		return
+	}
+
+	bucketSize, err := pkg.ParseGranularity(*granularity)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	location, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Printf("Error: invalid -tz %q: %v", *tz, err)
+		return
+	}
+
+	timeRange, err := pkg.ParseTimeRange(*from, *to, time.Now().In(location), location)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+	if !timeRange.IsZero() {
+		// -from/-to replace the default year filter; combining them with -year is ambiguous
+		if flagWasSet("year") {
+			log.Printf("Error: use either -year or -from/-to, not both")
+			return
+		}
+		*year = 0
+	}
+
+	decodesInputs := decodeInputs(*decodesPath, flag.Args(), flagWasSet("decodes"))
+	if containsStdin(decodesInputs) && *encodesPath == stdinPath {
+		log.Printf("Error: only one of -decodes and -encodes can read from stdin")
+		return
+	}
+
+	// With any other format, stdout carries only the report
+	status := io.Writer(os.Stdout)
+	if name := strings.ToLower(strings.TrimSpace(*output)); name != "" && name != "text" {
+		status = os.Stderr
+	}
+
+	if timeRange.IsZero() {
+		fmt.Fprintf(status, "Starting Encode Challenge Data Processing (Year: %d)...\n", *year)
+	} else {
+		fmt.Fprintf(status, "Starting Encode Challenge Data Processing (Range: %s)...\n", timeRange)
+	}
 
 	// Step 1: Build URL mapping index (one-time setup)
-	fmt.Println("Loading URL mappings from encodes.csv...")
-	mapping, err := pkg.ReadEncodesMappings("data/encodes.csv")
+	fmt.Fprintf(status, "Loading URL mappings from %s...\n", inputName(*encodesPath))
+	mapping, err := loadMapping(*encodesPath, os.Stdin)
 	if err != nil {
 		log.Printf("Error reading encodes mapping: %v", err)
 		return
 	}
-	fmt.Printf("Loaded %d URL mappings\n", len(mapping))
+	fmt.Fprintf(status, "Loaded %d URL mappings\n", len(mapping))
+
+	var geoIP *pkg.GeoIPDB
+	if *geoIPPath != "" {
+		geoIP, err = pkg.OpenGeoIPDB(*geoIPPath)
+		if err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		defer geoIP.Close()
+	}
 
 	// Step 2: Create aggregator with the mapping and configuration
 	config := pkg.AggregationConfig{
-		FilterYear: *year,
-		SortDesc:   *sortDesc,
+		FilterYear:  *year,
+		TimeRange:   timeRange,
+		Granularity: bucketSize,
+		Location:    location,
+		SortDesc:    *sortDesc,
+
+		TimestampLayouts: timestampLayouts,
+		Bots:             bots,
+		ExcludeBots:      excludeBots,
+		GeoIP:            geoIP,
+		GeoFilter:        geoFilter,
+		Visitors:         visitors,
+		Referrers:        referrers,
+		GroupBy:          dimensions,
+		Where:            filter,
 	}
 	aggregator := pkg.NewAggregator(mapping, config)
 
 	// Step 3: Stream process decode records (single pass)
-	fmt.Println("Streaming decode records...")
+	// Ctrl-C or -timeout stops streaming cleanly; partial results are still reported
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// Bad records either stop the run (the default) or are counted, and optionally kept, by the policy
+	var policy *pkg.ErrorPolicy
+	if errorMode != pkg.ErrorModeFail {
+		var deadLetter io.Writer
+		if errorMode == pkg.ErrorModeDeadLetter {
+			file, err := os.Create(*deadLetterPath)
+			if err != nil {
+				log.Printf("Error creating dead-letter file: %v", err)
+				return
+			}
+			defer file.Close()
+			deadLetter = file
+		}
+		policy = pkg.NewErrorPolicy(errorMode, errorBudget, deadLetter)
+	}
+
+	options := pkg.StreamOptions{Format: format, Errors: policy}
+	if *showProgress {
+		options.Progress = printProgress
+	}
+
+	fmt.Fprintln(status, "Streaming decode records...")
 	aggregator.StartTiming()
-	err = pkg.StreamDecodes("data/decodes.json", aggregator.ProcessRecord)
+	var sources []pkg.SourceFile
+	if *workers == 1 {
+		sources, err = streamDecodes(ctx, decodesInputs, os.Stdin, options, aggregator.ProcessRecord)
+	} else {
+		// One goroutine decodes while the worker pool aggregates into mergeable shards
+		err = aggregator.ProcessParallel(func(callback func(pkg.DecodeRecord) error) error {
+			var streamErr error
+			sources, streamErr = streamDecodes(ctx, decodesInputs, os.Stdin, options, callback)
+			return streamErr
+		}, pkg.ParallelConfig{Workers: *workers, Errors: policy})
+	}
 	aggregator.StopTiming()
+	aggregator.AddSourceFiles(sources...)
+	aggregator.AddRecordErrors(policy.Counts())
+	stop() // A second Ctrl-C now terminates immediately
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		log.Printf("Streaming stopped early (%v); printing partial results", ctx.Err())
+		if err := writeResults(aggregator, reporter, status); err != nil {
+			log.Printf("Error: %v", err)
+		}
+		return
+	}
 	if err != nil {
 		log.Printf("Error streaming decodes: %v", err)
 		return
 	}
+	if err := policy.CheckBudget(aggregator.GetResults().ProcessedRecords); err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+	if errorMode == pkg.ErrorModeDeadLetter && len(aggregator.GetResults().RecordErrors) > 0 {
+		fmt.Fprintf(status, "Rejected records written to %s\n", *deadLetterPath)
+	}
 
 	// Step 4: Display results
-	fmt.Println("Processing complete!")
-	aggregator.PrintSummary()
+	fmt.Fprintln(status, "Processing complete!")
+	if err := writeResults(aggregator, reporter, status); err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	if *pivotOut != "" {
+		options := pkg.PivotOptions{Sort: pivotOrder, Descending: *sortDesc, Limit: *pivotLimit}
+		if err := writePivot(*pivotOut, aggregator.GetResults().Pivot, options, *pivotNested); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		fmt.Fprintf(status, "Pivot table written to %s\n", *pivotOut)
+	}
+
+	if *unknownOut != "" {
+		if err := writeUnknownBitlinks(*unknownOut, aggregator.UnknownBitlinkReport(), pkg.WriteUnknownBitlinksCSV); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		fmt.Fprintf(status, "Unknown bitlinks written to %s\n", *unknownOut)
+	}
+	if *unknownBackfillOut != "" {
+		if err := writeUnknownBitlinks(*unknownBackfillOut, aggregator.UnknownBitlinkReport(), pkg.WriteUnknownBitlinksBackfill); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		fmt.Fprintf(status, "Unknown bitlink backfill written to %s\n", *unknownBackfillOut)
+	}
+
+	if *metricsOut != "" {
+		if err := pkg.WriteMetricsFile(*metricsOut, aggregator.GetResults()); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		fmt.Fprintf(status, "Metrics written to %s\n", *metricsOut)
+	}
+
+	if *metricsAddr != "" {
+		// Serve until Ctrl-C or SIGTERM; the streaming context has already been stopped
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		fmt.Fprintf(status, "Serving metrics at http://%s/metrics (Ctrl-C to stop)\n", *metricsAddr)
+		if err := serveMetrics(ctx, *metricsAddr, pkg.MetricsHandler(aggregator.GetResults())); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+	}
+}
+
+// writeResults reports the results with reporter on stdout; tables written to a
+// directory are noted on status
+func writeResults(aggregator *pkg.Aggregator, reporter pkg.Reporter, status io.Writer) error {
+	if err := reporter.Report(os.Stdout, aggregator.GetResults()); err != nil {
+		return err
+	}
+	if tables, ok := reporter.(pkg.TableReporter); ok && tables.Dir != "" {
+		fmt.Fprintf(status, "Tables written to %s\n", tables.Dir)
+	}
+	return nil
+}
+
+// serveMetrics serves handler at /metrics on addr until ctx is done
+func serveMetrics(ctx context.Context, addr string, handler http.Handler) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.ListenAndServe() }()
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("error serving metrics: %w", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// writePivot exports a pivot table as JSON to path
+func writePivot(path string, table *pkg.PivotTable, options pkg.PivotOptions, nested bool) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating pivot output: %w", err)
+	}
+	if err := pkg.WritePivotJSON(file, table, options, nested); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeUnknownBitlinks exports the unknown-bitlink report to path with write
+func writeUnknownBitlinks(path string, report *pkg.UnknownBitlinkReport, write func(io.Writer, *pkg.UnknownBitlinkReport) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating unknown bitlinks output: %w", err)
+	}
+	if err := write(file, report); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// stdinPath is the -decodes / -encodes value that selects standard input
+const stdinPath = "-"
+
+// inputName returns a display name for an input path
+func inputName(path string) string {
+	if path == stdinPath {
+		return "stdin"
+	}
+	return path
+}
+
+// loadMapping reads the encodes mapping from a file, or from stdin when path is "-"
+func loadMapping(path string, stdin io.Reader) (pkg.URLMapping, error) {
+	if path == stdinPath {
+		return pkg.ReadEncodesMappingsFrom(stdin)
+	}
+	return pkg.ReadEncodesMappings(path)
+}
+
+// streamDecodes streams decode records from files and globs in order, or from stdin
+// when the only input is "-". It returns the per-file record counts.
+func streamDecodes(ctx context.Context, paths []string, stdin io.Reader, options pkg.StreamOptions, callback func(pkg.DecodeRecord) error) ([]pkg.SourceFile, error) {
+	if !containsStdin(paths) {
+		return pkg.StreamDecodesFilesContext(ctx, paths, options, callback)
+	}
+	if len(paths) > 1 {
+		return nil, fmt.Errorf("stdin (-) cannot be combined with other decodes inputs")
+	}
+
+	source := pkg.SourceFile{Path: inputName(stdinPath)}
+	err := pkg.StreamDecodesContext(ctx, stdin, options, func(record pkg.DecodeRecord) error {
+		source.Records++
+		return callback(record)
+	})
+	return []pkg.SourceFile{source}, err
+}
+
+// printProgress renders a single, continuously rewritten progress line on stderr
+func printProgress(progress pkg.Progress) {
+	fmt.Fprintf(os.Stderr, "\r%.1f MB read, %d records (%.0f records/sec)   ",
+		float64(progress.BytesRead)/(1<<20), progress.Records, progress.RecordsPerSec)
+	if progress.Done {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// decodeInputs combines the comma-separated -decodes value with positional arguments
+// Positional arguments replace the default -decodes path unless the flag was set explicitly
+func decodeInputs(flagValue string, args []string, flagSet bool) []string {
+	var inputs []string
+	if flagSet || len(args) == 0 {
+		inputs = splitList(flagValue)
+	}
+	return append(inputs, args...)
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// containsStdin reports whether any input selects standard input
+func containsStdin(paths []string) bool {
+	for _, path := range paths {
+		if path == stdinPath {
+			return true
+		}
+	}
+	return false
+}
+
+// botDetector builds the bot detector for a -bots mode, using the rules in rulesPath
+// instead of the built-in ones when given. It returns nil when detection is off.
+func botDetector(mode, rulesPath string) (*pkg.BotDetector, bool, error) {
+	exclude := false
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "off":
+		if rulesPath != "" {
+			return nil, false, fmt.Errorf("-bot-rules needs -bots=flag or -bots=exclude")
+		}
+		return nil, false, nil
+	case "flag":
+	case "exclude":
+		exclude = true
+	default:
+		return nil, false, fmt.Errorf("unknown -bots mode %q (expected off, flag or exclude)", mode)
+	}
+
+	rules := pkg.DefaultBotRules()
+	if rulesPath != "" {
+		var err error
+		if rules, err = pkg.LoadBotRules(rulesPath); err != nil {
+			return nil, false, err
+		}
+	}
+	return pkg.NewBotDetector(rules...), exclude, nil
+}
+
+// visitorConfig builds the unique visitor configuration for a -visitors mode
+// It returns nil when visitor counting is off.
+func visitorConfig(mode string, precision uint, key string) (*pkg.VisitorConfig, error) {
+	config := &pkg.VisitorConfig{}
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "off":
+		return nil, nil
+	case "hll":
+	case "exact":
+		config.Exact = true
+	default:
+		return nil, fmt.Errorf("unknown -visitors mode %q (expected off, hll or exact)", mode)
+	}
+
+	if precision < pkg.MinHLLPrecision || precision > pkg.MaxHLLPrecision {
+		return nil, fmt.Errorf("invalid -visitor-precision %d (expected %d to %d)", precision, pkg.MinHLLPrecision, pkg.MaxHLLPrecision)
+	}
+	config.Precision = uint8(precision)
+
+	var err error
+	if config.Key, err = pkg.ParseVisitorKey(key); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// layoutList collects repeated -timestamp-layout flags
+type layoutList []string
+
+func (l *layoutList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *layoutList) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.New("empty timestamp layout")
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+// flagWasSet reports whether the named flag was given on the command line
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/Lithnotep/EncodeChallange/pkg"
@@ -190,12 +193,140 @@ https://google.com/,bit.ly,31Tt55y`
 		t.Errorf("Expected 1 click for google.com, got %d", results.ClicksByURL["https://google.com/"])
 	}
 
-	// Unknown bitlinks should have 1 click each as fallback
-	if results.ClicksByURL["http://bit.ly/unknown1"] != 1 {
-		t.Errorf("Expected 1 click for unknown1, got %d", results.ClicksByURL["http://bit.ly/unknown1"])
+	// Unknown bitlinks should have 1 click each, tracked apart from long URLs
+	if results.UnknownBitlinks["http://bit.ly/unknown1"] != 1 {
+		t.Errorf("Expected 1 click for unknown1, got %d", results.UnknownBitlinks["http://bit.ly/unknown1"])
 	}
 
-	if results.ClicksByURL["http://bit.ly/unknown2"] != 1 {
-		t.Errorf("Expected 1 click for unknown2, got %d", results.ClicksByURL["http://bit.ly/unknown2"])
+	if results.UnknownBitlinks["http://bit.ly/unknown2"] != 1 {
+		t.Errorf("Expected 1 click for unknown2, got %d", results.UnknownBitlinks["http://bit.ly/unknown2"])
+	}
+
+	if len(results.ClicksByURL) != 1 {
+		t.Errorf("Expected only google.com in ClicksByURL, got %v", results.ClicksByURL)
+	}
+}
+
+// Test that "-" reads both inputs from the provided stdin reader
+func TestMainWorkflow_Stdin(t *testing.T) {
+	testCSV := `long_url,domain,hash
+https://google.com/,bit.ly,31Tt55y`
+
+	mapping, err := loadMapping(stdinPath, strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("loadMapping from stdin failed: %v", err)
+	}
+	if len(mapping) != 1 {
+		t.Errorf("Expected 1 mapping, got %d", len(mapping))
+	}
+
+	testNDJSON := `{"bitlink": "http://bit.ly/31Tt55y", "user_agent": "Mozilla/5.0", "timestamp": "2020-02-15T00:00:00Z", "referrer": "t.co", "remote_ip": "4.14.247.63"}
+{"bitlink": "http://bit.ly/31Tt55y", "user_agent": "Chrome", "timestamp": "2020-02-16T00:00:00Z", "referrer": "direct", "remote_ip": "192.168.1.1"}`
+
+	aggregator := pkg.NewAggregator(mapping, pkg.AggregationConfig{FilterYear: 0})
+	sources, err := streamDecodes(context.Background(), []string{stdinPath}, strings.NewReader(testNDJSON), pkg.StreamOptions{}, aggregator.ProcessRecord)
+	if err != nil {
+		t.Fatalf("streamDecodes from stdin failed: %v", err)
+	}
+	if len(sources) != 1 || sources[0].Records != 2 {
+		t.Errorf("Expected 1 stdin source with 2 records, got %+v", sources)
+	}
+
+	if clicks := aggregator.GetResults().ClicksByURL["https://google.com/"]; clicks != 2 {
+		t.Errorf("Expected 2 clicks for google.com, got %d", clicks)
+	}
+}
+
+// Test that -decodes and positional arguments combine into the input list
+func TestDecodeInputs(t *testing.T) {
+	cases := []struct {
+		flagValue string
+		args      []string
+		flagSet   bool
+		expected  []string
+	}{
+		{"data/decodes.json", nil, false, []string{"data/decodes.json"}},
+		{"data/decodes.json", []string{"a.json", "b.json"}, false, []string{"a.json", "b.json"}},
+		{"a.json, b-*.json", nil, true, []string{"a.json", "b-*.json"}},
+		{"a.json", []string{"c.json"}, true, []string{"a.json", "c.json"}},
+	}
+
+	for _, tc := range cases {
+		got := decodeInputs(tc.flagValue, tc.args, tc.flagSet)
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("decodeInputs(%q, %v, %v): expected %v, got %v", tc.flagValue, tc.args, tc.flagSet, tc.expected, got)
+		}
+	}
+
+	if _, err := streamDecodes(context.Background(), []string{stdinPath, "a.json"}, strings.NewReader(""), pkg.StreamOptions{}, nil); err == nil {
+		t.Error("Expected error when combining stdin with files, got nil")
+	}
+}
+
+func TestBotDetector(t *testing.T) {
+	cases := []struct {
+		mode      string
+		rulesPath string
+		enabled   bool
+		exclude   bool
+	}{
+		{"off", "", false, false},
+		{"flag", "", true, false},
+		{"EXCLUDE", "", true, true},
+	}
+	for _, tc := range cases {
+		bots, exclude, err := botDetector(tc.mode, tc.rulesPath)
+		if err != nil {
+			t.Errorf("botDetector(%q) failed: %v", tc.mode, err)
+			continue
+		}
+		if (bots != nil) != tc.enabled || exclude != tc.exclude {
+			t.Errorf("botDetector(%q): expected enabled=%v exclude=%v, got %v %v", tc.mode, tc.enabled, tc.exclude, bots != nil, exclude)
+		}
+	}
+
+	for _, tc := range [][2]string{{"sometimes", ""}, {"off", "rules.json"}, {"flag", "missing-rules.json"}} {
+		if _, _, err := botDetector(tc[0], tc[1]); err == nil {
+			t.Errorf("botDetector(%q, %q): expected error, got nil", tc[0], tc[1])
+		}
+	}
+}
+
+func TestVisitorConfig(t *testing.T) {
+	config, err := visitorConfig("off", 14, "ip")
+	if err != nil || config != nil {
+		t.Errorf("visitorConfig(off): expected nil config, got %+v (err %v)", config, err)
+	}
+
+	config, err = visitorConfig("EXACT", 10, "ip+ua")
+	if err != nil {
+		t.Fatalf("visitorConfig(exact) failed: %v", err)
+	}
+	expected := pkg.VisitorConfig{Key: pkg.VisitorKeyIPUserAgent, Precision: 10, Exact: true}
+	if *config != expected {
+		t.Errorf("visitorConfig(exact): expected %+v, got %+v", expected, *config)
+	}
+
+	for _, tc := range []struct {
+		mode      string
+		precision uint
+		key       string
+	}{{"sometimes", 14, "ip"}, {"hll", 3, "ip"}, {"hll", 260, "ip"}, {"hll", 14, "cookie"}} {
+		if _, err := visitorConfig(tc.mode, tc.precision, tc.key); err == nil {
+			t.Errorf("visitorConfig(%q, %d, %q): expected error, got nil", tc.mode, tc.precision, tc.key)
+		}
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	// Stops cleanly once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := serveMetrics(ctx, "127.0.0.1:0", pkg.MetricsHandler(pkg.AggregationResults{})); err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+
+	if err := serveMetrics(context.Background(), "not an address", pkg.MetricsHandler(pkg.AggregationResults{})); err == nil {
+		t.Error("Expected error for an invalid address, got nil")
 	}
 }
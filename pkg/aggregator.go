@@ -2,48 +2,188 @@ package pkg
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"time"
 )
 
 // AggregationConfig holds configuration options for aggregation
 type AggregationConfig struct {
-	FilterYear int  // Year to filter by (0 means no filter)
-	SortDesc   bool // true for descending sort, false for ascending
+	FilterYear  int            // Year to filter by (0 means no filter)
+	TimeRange   TimeRange      // Half-open [From, To) click time filter; overrides FilterYear when set
+	Granularity Granularity    // Time bucket size for ClicksByDate (zero value is daily)
+	Location    *time.Location // Time zone for year filtering and bucketing (nil means UTC)
+	// Extra Go time layouts tried after RFC3339 and Unix epoch timestamps
+	TimestampLayouts []string
+	// Bot detection; nil disables it. Matched clicks are counted as bots, and
+	// left out of every click aggregation when ExcludeBots is set.
+	Bots        *BotDetector
+	ExcludeBots bool
+	// Geolocation; nil disables it. GeoFilter keeps only clicks from the listed places.
+	GeoIP     *GeoIPDB
+	GeoFilter GeoFilter
+	// Unique visitor counting per URL, referrer and time bucket; nil disables it
+	Visitors *VisitorConfig
+	// Referrer host canonicalisation and channels (nil uses DefaultReferrerNormalizer)
+	Referrers *ReferrerNormalizer
+	// Record filter expression (-where); nil keeps every record
+	Where *Filter
+	// Dimensions for the Pivot group-by table, e.g. url, referrer, date; empty disables it
+	GroupBy  []Dimension
+	SortDesc bool // true for descending sort, false for ascending
+}
+
+// FilterBreakdown records why records were excluded by the time, geo and expression filters
+type FilterBreakdown struct {
+	BeforeRange int `json:"before_range"` // Timestamp earlier than the range start
+	AfterRange  int `json:"after_range"`  // Timestamp at or after the range end
+	Unparseable int `json:"unparseable"`  // Timestamp could not be parsed, so the record cannot be placed in the range
+	GeoMismatch int `json:"geo_mismatch"` // Location did not match the geo filter
+	WhereFalse  int `json:"where_false"`  // Filter expression was false
 }
 
 // AggregationResults holds all the computed analytics
 type AggregationResults struct {
 	TotalClicks      int
-	ClicksByURL      map[string]int
-	ClicksByReferrer map[string]int
-	ClicksByDate     map[string]int // YYYY-MM-DD format
-	UnknownBitlinks  []string       // Bitlinks not found in encodes mapping
-	ProcessedRecords int
-	FilteredOut      int           // Records filtered out by year
-	FilterYear       int           // Year that was filtered for
-	ProcessingTime   time.Duration // Total time taken for streaming and processing
+	ClicksByURL      map[string]int // Keyed by long URL; clicks on unknown bitlinks are in UnknownBitlinks
+	ClicksByReferrer map[string]int // Keyed by the raw referrer as logged
+	// Keyed by canonical referrer host, e.g. t.co and twitter.com both count as twitter.com
+	ClicksByReferrerHost map[string]int
+	ClicksByChannel      map[string]int       // Keyed by channel: social, search, direct, email or other
+	ClicksByDate         map[string]int       // Keyed by bucket label (YYYY-MM-DD for the default daily granularity)
+	ClicksByBrowser      map[string]int       // Keyed by browser family; in-app browsers by app (Facebook, ...)
+	ClicksByOS           map[string]int       // Keyed by operating system family
+	ClicksByDevice       map[string]int       // Keyed by device class: desktop, mobile, tablet, bot or unknown
+	ClicksByCountry      map[string]int       // Keyed by ISO country code (with a GeoIP database; "unknown" for unmatched IPs)
+	ClicksByRegion       map[string]int       // Keyed by ISO 3166-2 subdivision code, e.g. US-CA
+	ClicksByCity         map[string]int       // Keyed by city and region, e.g. "San Francisco, US-CA"
+	UnknownBitlinks      map[string]int       // Clicks per bitlink not found in the encodes mapping
+	UnknownBitlinkSeen   map[string]SeenRange // First and last click on each of UnknownBitlinks
+	ProcessedRecords     int                  // Decoded records: TotalClicks + FilteredOut, plus BotClicks when BotsExcluded
+	FilteredOut          int                  // Records excluded by the time, geo and expression filters
+	FilteredReasons      FilterBreakdown      // Why FilteredOut records were excluded
+	FilterYear           int                  // Year that was filtered for (0 when TimeRange took precedence)
+	FilterRange          TimeRange            // Effective time range that was filtered for
+	FilterGeo            GeoFilter            // Places that were filtered for
+	FilterWhere          string               // Filter expression that was applied
+	Granularity          Granularity          // Time bucket size used for ClicksByDate
+	TimeZone             string               // IANA name of the zone used for filtering and bucketing
+	TimestampFormats     map[string]int       // Records per detected timestamp format (rfc3339, epoch-seconds, ...)
+	ProcessingTime       time.Duration        // Total time taken for streaming and processing
+	SourceFiles          []SourceFile         // Input files read, in order, with their record counts
+	RecordErrors         map[string]int       // Records rejected by -on-error=skip|deadletter, by category
+	BotClicks            int                  // In-range clicks matched by a bot rule
+	BotClicksByRule      map[string]int       // BotClicks by the name of the first matching rule
+	BotsExcluded         bool                 // Whether BotClicks were left out of the click aggregations
+	// Distinct visitors among counted clicks (with AggregationConfig.Visitors); estimated
+	// with HyperLogLog unless exact counting was requested
+	UniqueVisitors           int
+	UniqueVisitorsByURL      map[string]int
+	UniqueVisitorsByReferrer map[string]int
+	UniqueVisitorsByDate     map[string]int // Keyed like ClicksByDate
+	Pivot                    *PivotTable    // Clicks per combination of AggregationConfig.GroupBy values (nil without GroupBy)
+	// How the results should be presented and which optional features ran, so
+	// reporters need nothing but the results
+	SortDesc    bool           // Order of count listings, as AggregationConfig.SortDesc
+	BotsEnabled bool           // Whether bot detection ran
+	GeoEnabled  bool           // Whether clicks were geolocated (ClicksByCountry, ClicksByRegion, ClicksByCity)
+	Visitors    *VisitorConfig // How unique visitors were counted; nil when they were not
+
+	location *time.Location // Zone of the ClicksByDate buckets
+	mapping  URLMapping     // Encodes mapping, for unknown-bitlink near matches
 }
 
 // Aggregator handles the streaming aggregation of decode records
 type Aggregator struct {
-	mapping   URLMapping
-	config    AggregationConfig
-	results   AggregationResults
-	startTime time.Time // Track when processing started
+	mapping    URLMapping
+	config     AggregationConfig
+	location   *time.Location // Effective zone; never nil
+	parser     *TimestampParser
+	agents     map[string]UserAgent          // Classification cache; click logs repeat few distinct user agents
+	locations  map[string]GeoLocation        // GeoIP lookup cache, keyed by IP
+	referrers  map[string]NormalizedReferrer // Normalization cache, keyed by raw referrer
+	normalizer *ReferrerNormalizer
+	timeRange  TimeRange       // Effective filter derived from TimeRange or FilterYear
+	visitors   *visitorTracker // nil unless unique visitors are counted
+	pivotRow   []string        // Reused buffer of the current click's GroupBy values
+	results    AggregationResults
+	startTime  time.Time // Track when processing started
 }
 
 // NewAggregator creates a new aggregator with the URL mapping and configuration
 func NewAggregator(mapping URLMapping, config AggregationConfig) *Aggregator {
+	location := config.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	// An explicit time range takes precedence; a year filter becomes that year's range
+	// in the configured zone, so New Year is measured in local time
+	timeRange, filterYear := config.TimeRange, 0
+	if timeRange.IsZero() && config.FilterYear > 0 {
+		timeRange, filterYear = YearRange(config.FilterYear, location), config.FilterYear
+	}
+
+	normalizer := config.Referrers
+	if normalizer == nil {
+		normalizer = DefaultReferrerNormalizer()
+	}
+
+	var pivot *PivotTable
+	if len(config.GroupBy) > 0 {
+		pivot = NewPivotTable(config.GroupBy)
+	}
+
+	var visitors *visitorTracker
+	var visitorConfig *VisitorConfig
+	if config.Visitors != nil {
+		visitors = newVisitorTracker(*config.Visitors)
+		visitorConfig = &visitors.config // With the precision clamped
+	}
+
 	return &Aggregator{
-		mapping: mapping,
-		config:  config,
+		mapping:    mapping,
+		config:     config,
+		location:   location,
+		parser:     NewTimestampParser(config.TimestampLayouts, location),
+		agents:     make(map[string]UserAgent),
+		locations:  make(map[string]GeoLocation),
+		referrers:  make(map[string]NormalizedReferrer),
+		normalizer: normalizer,
+		timeRange:  timeRange,
+		visitors:   visitors,
+		pivotRow:   make([]string, len(config.GroupBy)),
 		results: AggregationResults{
-			ClicksByURL:      make(map[string]int),
-			ClicksByReferrer: make(map[string]int),
-			ClicksByDate:     make(map[string]int),
-			UnknownBitlinks:  make([]string, 0),
-			FilterYear:       config.FilterYear,
+			ClicksByURL:          make(map[string]int),
+			ClicksByReferrer:     make(map[string]int),
+			ClicksByReferrerHost: make(map[string]int),
+			ClicksByChannel:      make(map[string]int),
+			ClicksByDate:         make(map[string]int),
+			ClicksByBrowser:      make(map[string]int),
+			ClicksByOS:           make(map[string]int),
+			ClicksByDevice:       make(map[string]int),
+			ClicksByCountry:      make(map[string]int),
+			ClicksByRegion:       make(map[string]int),
+			ClicksByCity:         make(map[string]int),
+			TimestampFormats:     make(map[string]int),
+			RecordErrors:         make(map[string]int),
+			BotClicksByRule:      make(map[string]int),
+			BotsExcluded:         config.Bots != nil && config.ExcludeBots,
+			UnknownBitlinks:      make(map[string]int),
+			UnknownBitlinkSeen:   make(map[string]SeenRange),
+			FilterYear:           filterYear,
+			FilterRange:          timeRange,
+			FilterGeo:            config.GeoFilter,
+			FilterWhere:          whereSource(config.Where),
+			Granularity:          config.Granularity,
+			TimeZone:             location.String(),
+			Pivot:                pivot,
+			SortDesc:             config.SortDesc,
+			BotsEnabled:          config.Bots != nil,
+			GeoEnabled:           config.GeoIP != nil,
+			Visitors:             visitorConfig,
+			location:             location,
+			mapping:              mapping,
 		},
 	}
 }
@@ -52,41 +192,180 @@ func NewAggregator(mapping URLMapping, config AggregationConfig) *Aggregator {
 func (a *Aggregator) ProcessRecord(record DecodeRecord) error {
 	a.results.ProcessedRecords++
 
-	// Parse the timestamp to check the year
-	recordTime, err := time.Parse("2006-01-02T15:04:05Z", record.Timestamp)
+	// Parse the timestamp to check the time range
+	recordTime, format, err := a.parser.Parse(record.Timestamp)
 	if err != nil {
-		return fmt.Errorf("error parsing timestamp %s: %w", record.Timestamp, err)
+		// The error policy decides whether this stops the run, with or without a time
+		// filter; the record is counted as filtered out in case the policy skips it
+		a.results.FilteredOut++
+		a.results.FilteredReasons.Unparseable++
+		return &RecordError{
+			Category: ErrorCategoryTimestamp,
+			Offset:   -1,
+			Err:      fmt.Errorf("error parsing timestamp %s: %w", record.Timestamp, err),
+		}
 	}
+	a.results.TimestampFormats[format]++
+
+	// Bucket (and report) in the configured zone; range checks compare instants, so they are zone-independent
+	recordTime = recordTime.In(a.location)
 
-	// Filter by year if specified
-	if a.config.FilterYear > 0 && recordTime.Year() != a.config.FilterYear {
+	// Filter by time range (or year) if specified
+	if a.timeRange.IsBefore(recordTime) {
 		a.results.FilteredOut++
+		a.results.FilteredReasons.BeforeRange++
+		return nil // Skip this record
+	}
+	if a.timeRange.IsAfter(recordTime) {
+		a.results.FilteredOut++
+		a.results.FilteredReasons.AfterRange++
 		return nil // Skip this record
 	}
 
+	// Locate the click once for both the geo filter and the geo aggregations
+	location := unknownLocation
+	if a.config.GeoIP != nil || !a.config.GeoFilter.IsZero() {
+		location = a.locateIP(record.RemoteIP)
+		if !a.config.GeoFilter.Matches(location) {
+			a.results.FilteredOut++
+			a.results.FilteredReasons.GeoMismatch++
+			return nil // Skip this record
+		}
+	}
+
+	if a.config.Where != nil && !a.config.Where.root.eval(&filterEnv{aggregator: a, record: &record, at: recordTime}) {
+		a.results.FilteredOut++
+		a.results.FilteredReasons.WhereFalse++
+		return nil // Skip this record
+	}
+
+	if a.config.Bots != nil {
+		if rule := a.config.Bots.Match(record, recordTime); rule != "" {
+			a.results.BotClicks++
+			a.results.BotClicksByRule[rule]++
+			if a.results.BotsExcluded {
+				return nil // Skip this record
+			}
+		}
+	}
+
 	a.results.TotalClicks++
 
 	// Look up the original URL
 	longURL, found := a.mapping.GetLongURL(record.Bitlink)
-	if !found {
-		// Track unknown bitlinks for debugging
-		a.results.UnknownBitlinks = append(a.results.UnknownBitlinks, record.Bitlink)
-		longURL = record.Bitlink // Use bitlink as fallback
+	if found {
+		// Aggregate clicks by original URL
+		a.results.ClicksByURL[longURL]++
+	} else {
+		// Track unknown bitlinks apart, for debugging
+		a.results.UnknownBitlinks[record.Bitlink]++
+		a.results.UnknownBitlinkSeen[record.Bitlink] = a.results.UnknownBitlinkSeen[record.Bitlink].add(recordTime)
+		longURL = record.Bitlink // Use bitlink as fallback for the other breakdowns
 	}
 
-	// Aggregate clicks by original URL
-	a.results.ClicksByURL[longURL]++
-
-	// Aggregate clicks by referrer
+	// Aggregate clicks by referrer, as logged and normalized
 	a.results.ClicksByReferrer[record.Referrer]++
+	referrer := a.normalizeReferrer(record.Referrer)
+	a.results.ClicksByReferrerHost[referrer.Host]++
+	a.results.ClicksByChannel[referrer.Channel.String()]++
+
+	// Aggregate clicks by time bucket (date by default)
+	bucket := a.config.Granularity.Label(recordTime)
+	a.results.ClicksByDate[bucket]++
+
+	// Aggregate clicks by browser, OS and device class
+	agent := a.classifyUserAgent(record.UserAgent)
+	a.results.ClicksByBrowser[agent.Browser]++
+	a.results.ClicksByOS[agent.OS]++
+	a.results.ClicksByDevice[agent.Device.String()]++
+
+	// Aggregate clicks by country, region and city
+	if a.config.GeoIP != nil {
+		a.results.ClicksByCountry[location.Country]++
+		a.results.ClicksByRegion[location.Region]++
+		a.results.ClicksByCity[location.City]++
+	}
 
-	// Aggregate clicks by date
-	date := recordTime.Format("2006-01-02")
-	a.results.ClicksByDate[date]++
+	// Count the click in the group-by table
+	if a.results.Pivot != nil {
+		click := pivotClick{record: &record, longURL: longURL, bucket: bucket, referrer: referrer, agent: agent, location: location}
+		for i, dimension := range a.config.GroupBy {
+			a.pivotRow[i] = click.value(dimension)
+		}
+		a.results.Pivot.Add(a.pivotRow, 1)
+	}
+
+	// Count the visitor against each dimension
+	if a.visitors != nil {
+		if hash, ok := a.visitors.visitorHash(record); ok {
+			a.visitors.add(hash, longURL, record.Referrer, bucket)
+		}
+	}
 
 	return nil
 }
 
+// whereSource returns a filter's expression, or "" without a filter
+func whereSource(where *Filter) string {
+	if where == nil {
+		return ""
+	}
+	return where.String()
+}
+
+// maxCachedLookups bounds the user-agent, referrer and GeoIP caches for logs with very diverse values
+const maxCachedLookups = 10000
+
+// classifyUserAgent parses a user agent, reusing earlier results for repeated headers
+func (a *Aggregator) classifyUserAgent(header string) UserAgent {
+	if agent, ok := a.agents[header]; ok {
+		return agent
+	}
+	agent := ParseUserAgent(header)
+	if len(a.agents) < maxCachedLookups {
+		a.agents[header] = agent
+	}
+	return agent
+}
+
+// normalizeReferrer normalizes a referrer, reusing earlier results for repeated values
+func (a *Aggregator) normalizeReferrer(referrer string) NormalizedReferrer {
+	if normalized, ok := a.referrers[referrer]; ok {
+		return normalized
+	}
+	normalized := a.normalizer.Normalize(referrer)
+	if len(a.referrers) < maxCachedLookups {
+		a.referrers[referrer] = normalized
+	}
+	return normalized
+}
+
+// locateIP looks up an IP in the GeoIP database, reusing earlier results for repeated IPs
+// Without a database every click is in the unknown location.
+func (a *Aggregator) locateIP(ip string) GeoLocation {
+	if a.config.GeoIP == nil {
+		return unknownLocation
+	}
+	if location, ok := a.locations[ip]; ok {
+		return location
+	}
+	location := a.config.GeoIP.Lookup(ip)
+	if len(a.locations) < maxCachedLookups {
+		a.locations[ip] = location
+	}
+	return location
+}
+
+// AddSourceFiles records the provenance of the input files that fed this aggregator
+func (a *Aggregator) AddSourceFiles(sources ...SourceFile) {
+	a.results.SourceFiles = append(a.results.SourceFiles, sources...)
+}
+
+// AddRecordErrors records how many bad records the error policy rejected, by category
+func (a *Aggregator) AddRecordErrors(counts map[string]int) {
+	mergeCountMap(a.results.RecordErrors, counts)
+}
+
 // StartTiming begins tracking processing time
 func (a *Aggregator) StartTiming() {
 	a.startTime = time.Now()
@@ -101,123 +380,101 @@ func (a *Aggregator) StopTiming() {
 
 // GetResults returns the final aggregation results
 func (a *Aggregator) GetResults() AggregationResults {
+	a.estimateVisitors()
 	return a.results
 }
 
-// GetSortedURLs returns URLs sorted by click count according to config
-func (a *Aggregator) GetSortedURLs(excludeShortlinks bool) []KeyValue {
-	var filter func(string) bool
-	if excludeShortlinks {
-		filter = func(url string) bool {
-			return a.isShortlink(url) // Return true to EXCLUDE shortlinks
-		}
+// estimateVisitors refreshes the unique visitor counts from the tracker's counters
+func (a *Aggregator) estimateVisitors() {
+	if a.visitors != nil {
+		a.visitors.fill(&a.results)
 	}
+}
 
-	return a.getSortedKeyValues(a.results.ClicksByURL, filter)
+// GetSortedURLs returns URLs sorted by click count according to config; unless
+// shortlinks are excluded, unknown bitlinks are listed alongside the long URLs
+func (a *Aggregator) GetSortedURLs(excludeShortlinks bool) []KeyValue {
+	return a.results.SortedURLs(excludeShortlinks)
 }
 
-// KeyValue represents a generic key-value pair for sorting
-type KeyValue struct {
-	Key   string
-	Value int
-}
-
-// getSortedKeyValues converts a map to sorted slice based on config
-// Optional filter function can be provided to exclude certain keys
-func (a *Aggregator) getSortedKeyValues(data map[string]int, filter func(string) bool) []KeyValue {
-	var items []KeyValue
-	for key, value := range data {
-		// Apply filter if provided (return true to EXCLUDE the item)
-		if filter != nil && filter(key) {
-			continue
-		}
+// UnknownBitlinkReport groups the unknown bitlinks by domain and suggests near
+// matches from the encodes mapping
+func (a *Aggregator) UnknownBitlinkReport() *UnknownBitlinkReport {
+	return a.results.UnknownBitlinkReport()
+}
+
+// GetTimeSeries returns clicks per time bucket in chronological order
+// Buckets with no clicks between the first and last bucket are included with zero clicks
+func (a *Aggregator) GetTimeSeries() ([]TimeBucket, error) {
+	return a.results.TimeSeries()
+}
+
+// SortedCounts returns counts sorted by value following SortDesc, with ties
+// broken by key and empty keys left out
+func (r *AggregationResults) SortedCounts(counts map[string]int) []KeyValue {
+	items := make([]KeyValue, 0, len(counts))
+	for key, value := range counts {
 		if key != "" {
 			items = append(items, KeyValue{Key: key, Value: value})
 		}
 	}
-
-	// Sort based on configuration
-	sort.Slice(items, func(i, j int) bool {
-		if a.config.SortDesc {
-			return items[i].Value > items[j].Value // Descending
-		}
-		return items[i].Value < items[j].Value // Ascending
-	})
-
+	sortKeyValues(items, r.SortDesc)
 	return items
 }
 
-// PrintSummary prints a human-readable summary of the results
-func (a *Aggregator) PrintSummary() {
-	fmt.Printf("\n=== Aggregation Results ===\n")
-	if a.results.FilterYear > 0 {
-		fmt.Printf("Filter Year: %d\n", a.results.FilterYear)
-		fmt.Printf("Records Filtered Out: %d\n", a.results.FilteredOut)
-	}
-	fmt.Printf("Total Records Processed: %d\n", a.results.ProcessedRecords)
-	fmt.Printf("Total Clicks: %d\n", a.results.TotalClicks)
-	fmt.Printf("Unknown Bitlinks: %d\n", len(a.results.UnknownBitlinks))
-	if a.results.ProcessingTime > 0 {
-		fmt.Printf("Processing Time: %v\n", a.results.ProcessingTime)
+// SortedURLs returns URLs sorted like SortedCounts; unless shortlinks are
+// excluded, unknown bitlinks are listed alongside the long URLs
+func (r *AggregationResults) SortedURLs(excludeShortlinks bool) []KeyValue {
+	if excludeShortlinks {
+		return r.SortedCounts(r.ClicksByURL)
 	}
 
-	fmt.Printf("\n--- Top URLs by Clicks ---\n")
-	sortedURLs := a.GetSortedURLs(false) // Include all URLs
-	for _, urlClick := range sortedURLs {
-		fmt.Printf("%s: %d clicks\n", urlClick.Key, urlClick.Value)
-	}
+	all := make(map[string]int, len(r.ClicksByURL)+len(r.UnknownBitlinks))
+	mergeCountMap(all, r.ClicksByURL)
+	mergeCountMap(all, r.UnknownBitlinks)
+	return r.SortedCounts(all)
+}
 
-	fmt.Printf("\n--- Top Referrers ---\n")
-	sortedReferrers := a.getSortedKeyValues(a.results.ClicksByReferrer, nil)
-	for _, referrer := range sortedReferrers {
-		fmt.Printf("%s: %d clicks\n", referrer.Key, referrer.Value)
-	}
+// UnknownBitlinkReport groups the unknown bitlinks by domain and suggests near
+// matches from the encodes mapping the results were aggregated with
+func (r *AggregationResults) UnknownBitlinkReport() *UnknownBitlinkReport {
+	return NewUnknownBitlinkReport(r, r.mapping)
+}
 
-	fmt.Printf("\n--- Clicks by Date (first 10) ---\n")
-	sortedDates := a.getSortedKeyValues(a.results.ClicksByDate, nil)
-	for i, date := range sortedDates {
-		if i >= 10 {
-			break
-		}
-		fmt.Printf("%s: %d clicks\n", date.Key, date.Value)
-	}
+// TimeSeries returns clicks per time bucket in chronological order, zero-filled
+// between the first and last bucket
+func (r *AggregationResults) TimeSeries() ([]TimeBucket, error) {
+	return buildTimeSeries(r.ClicksByDate, r.Granularity, r.timeLocation())
+}
 
-	if len(a.results.UnknownBitlinks) > 0 {
-		fmt.Printf("\n--- Unknown Bitlink Clicks (first 5) ---\n")
-		for i, bitlink := range a.results.UnknownBitlinks {
-			if i >= 5 {
-				break
-			}
-			fmt.Printf("%s\n", bitlink)
-		}
+// timeLocation is the zone the results were bucketed in
+func (r *AggregationResults) timeLocation() *time.Location {
+	if r.location == nil {
+		return time.UTC
 	}
+	return r.location
+}
 
-	// Print final summary - only mapped long URLs (shortlinks without mapping are excluded)
-	fmt.Printf("\nNote: Shortlinks without mapping are excluded from the final summary.\n")
-	fmt.Printf("\nFinal Summary:\n")
-
-	// Get sorted URLs excluding shortlinks
-	sortedFinalURLs := a.GetSortedURLs(true) // Exclude shortlinks
+// KeyValue represents a generic key-value pair for sorting
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value int    `json:"count"`
+}
 
-	// Print sorted results
-	fmt.Printf("[")
-	for i, urlClick := range sortedFinalURLs {
-		if i > 0 {
-			fmt.Printf(", ")
+// sortKeyValues sorts items by value, breaking ties by key
+func sortKeyValues(items []KeyValue, descending bool) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Value == items[j].Value {
+			return items[i].Key < items[j].Key
 		}
-		fmt.Printf("{\"%s\": %d}", urlClick.Key, urlClick.Value)
-	}
-	fmt.Printf("]\n")
+		if descending {
+			return items[i].Value > items[j].Value // Descending
+		}
+		return items[i].Value < items[j].Value // Ascending
+	})
 }
 
-// isShortlink checks if a URL is a shortlink (not a mapped long URL)
-// It determines this by checking if the URL appears in our list of unknown bitlinks
-func (a *Aggregator) isShortlink(url string) bool {
-	// Check if this URL is in our list of unmapped bitlinks
-	for _, unmappedLink := range a.results.UnknownBitlinks {
-		if url == unmappedLink {
-			return true
-		}
-	}
-	return false
+// PrintSummary prints the text summary of the results to stdout
+func (a *Aggregator) PrintSummary() {
+	WriteSummary(os.Stdout, a.GetResults())
 }
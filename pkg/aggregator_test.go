@@ -1,6 +1,9 @@
 package pkg
 
 import (
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -98,13 +101,16 @@ func TestAggregator_ProcessRecord_UnknownBitlink(t *testing.T) {
 		t.Errorf("Expected 1 unknown bitlink, got %d", len(aggregator.results.UnknownBitlinks))
 	}
 
-	if aggregator.results.UnknownBitlinks[0] != "http://bit.ly/unknown" {
-		t.Errorf("Expected unknown bitlink to be 'http://bit.ly/unknown', got %s", aggregator.results.UnknownBitlinks[0])
+	if aggregator.results.UnknownBitlinks["http://bit.ly/unknown"] != 1 {
+		t.Errorf("Expected 1 click for 'http://bit.ly/unknown', got %v", aggregator.results.UnknownBitlinks)
 	}
 
-	// Check that it still counts as a click using the bitlink as fallback
-	if aggregator.results.ClicksByURL["http://bit.ly/unknown"] != 1 {
-		t.Errorf("Expected clicks for unknown bitlink to be 1, got %d", aggregator.results.ClicksByURL["http://bit.ly/unknown"])
+	// Check that it still counts as a click, but not as a long URL
+	if aggregator.results.TotalClicks != 1 {
+		t.Errorf("Expected total clicks to be 1, got %d", aggregator.results.TotalClicks)
+	}
+	if _, ok := aggregator.results.ClicksByURL["http://bit.ly/unknown"]; ok {
+		t.Error("Expected unknown bitlink to be kept out of ClicksByURL")
 	}
 }
 
@@ -256,22 +262,22 @@ func TestAggregator_YearFiltering(t *testing.T) {
 	}
 }
 
-// Test the isShortlink method - now based on actual unmapped bitlinks
-func TestAggregator_IsShortlink(t *testing.T) {
+// Test that unknown bitlinks are tracked as a set with click counts
+func TestAggregator_UnknownBitlinks(t *testing.T) {
 	mapping := URLMapping{
 		"http://bit.ly/mapped": "https://mapped.com/",
 	}
 	config := AggregationConfig{FilterYear: 0}
 	aggregator := NewAggregator(mapping, config)
 
-	// Process some records to populate the UnknownBitlinks list
 	records := []DecodeRecord{
 		// This will be mapped
 		{Bitlink: "http://bit.ly/mapped", UserAgent: "Chrome", Timestamp: "2020-01-01T00:00:00Z", Referrer: "direct", RemoteIP: "1.1.1.1"},
-		// These will be unmapped and added to UnknownBitlinks
+		// These will be unmapped and counted in UnknownBitlinks
 		{Bitlink: "http://bit.ly/unknown1", UserAgent: "Firefox", Timestamp: "2020-01-02T00:00:00Z", Referrer: "t.co", RemoteIP: "2.2.2.2"},
 		{Bitlink: "http://es.pn/unknown2", UserAgent: "Safari", Timestamp: "2020-01-03T00:00:00Z", Referrer: "direct", RemoteIP: "3.3.3.3"},
 		{Bitlink: "http://amzn.to/unknown3", UserAgent: "Edge", Timestamp: "2020-01-04T00:00:00Z", Referrer: "facebook.com", RemoteIP: "4.4.4.4"},
+		{Bitlink: "http://bit.ly/unknown1", UserAgent: "Firefox", Timestamp: "2020-01-05T00:00:00Z", Referrer: "t.co", RemoteIP: "2.2.2.2"},
 	}
 
 	for _, record := range records {
@@ -281,36 +287,25 @@ func TestAggregator_IsShortlink(t *testing.T) {
 		}
 	}
 
-	// Test that unmapped bitlinks are identified as shortlinks
-	if !aggregator.isShortlink("http://bit.ly/unknown1") {
-		t.Error("Expected unknown1 to be identified as shortlink")
-	}
-
-	if !aggregator.isShortlink("http://es.pn/unknown2") {
-		t.Error("Expected unknown2 to be identified as shortlink")
-	}
-
-	if !aggregator.isShortlink("http://amzn.to/unknown3") {
-		t.Error("Expected unknown3 to be identified as shortlink")
+	// Repeated clicks on an unknown bitlink add to its count, not to the set
+	expected := map[string]int{
+		"http://bit.ly/unknown1":  2,
+		"http://es.pn/unknown2":   1,
+		"http://amzn.to/unknown3": 1,
 	}
-
-	// Test that mapped long URLs are NOT identified as shortlinks
-	if aggregator.isShortlink("https://mapped.com/") {
-		t.Error("Expected mapped.com to NOT be identified as shortlink")
+	if !reflect.DeepEqual(aggregator.results.UnknownBitlinks, expected) {
+		t.Errorf("Expected unknown bitlinks %v, got %v", expected, aggregator.results.UnknownBitlinks)
 	}
 
-	// Test that URLs not in our data are NOT identified as shortlinks
-	if aggregator.isShortlink("http://bit.ly/notprocessed") {
-		t.Error("Expected unprocessed bitlink to NOT be identified as shortlink")
+	// Mapped long URLs are the only keys of ClicksByURL
+	if !reflect.DeepEqual(aggregator.results.ClicksByURL, map[string]int{"https://mapped.com/": 1}) {
+		t.Errorf("Expected only mapped.com in ClicksByURL, got %v", aggregator.results.ClicksByURL)
 	}
 
-	if aggregator.isShortlink("https://google.com/") {
-		t.Error("Expected google.com to NOT be identified as shortlink")
-	}
-
-	// Test edge cases
-	if aggregator.isShortlink("") {
-		t.Error("Expected empty string to NOT be identified as shortlink")
+	// The combined view lists both, sorted by clicks
+	all := aggregator.GetSortedURLs(false)
+	if len(all) != 4 || all[len(all)-1] != (KeyValue{Key: "http://bit.ly/unknown1", Value: 2}) {
+		t.Errorf("Expected 4 URLs with unknown1 last (ascending), got %v", all)
 	}
 }
 
@@ -358,30 +353,20 @@ func TestAggregator_FinalSummaryFiltering(t *testing.T) {
 		t.Errorf("Expected 1 click for github.com, got %d", results.ClicksByURL["https://github.com/"])
 	}
 
-	// Verify that unmapped URLs are also tracked (as fallback)
-	if results.ClicksByURL["http://bit.ly/unknown"] != 1 {
-		t.Errorf("Expected 1 click for unknown bitlink, got %d", results.ClicksByURL["http://bit.ly/unknown"])
-	}
-
-	if results.ClicksByURL["http://es.pn/unknown"] != 1 {
-		t.Errorf("Expected 1 click for es.pn unknown, got %d", results.ClicksByURL["http://es.pn/unknown"])
-	}
-
-	// Test that isShortlink correctly identifies the URLs
-	if !aggregator.isShortlink("http://bit.ly/unknown") {
-		t.Error("Expected bit.ly URL to be identified as shortlink")
-	}
-
-	if !aggregator.isShortlink("http://es.pn/unknown") {
-		t.Error("Expected es.pn URL to be identified as shortlink")
+	// Verify that unmapped bitlinks are tracked apart from long URLs
+	if results.UnknownBitlinks["http://bit.ly/unknown"] != 1 {
+		t.Errorf("Expected 1 click for unknown bitlink, got %d", results.UnknownBitlinks["http://bit.ly/unknown"])
 	}
 
-	if aggregator.isShortlink("https://google.com/") {
-		t.Error("Expected google.com to NOT be identified as shortlink")
+	if results.UnknownBitlinks["http://es.pn/unknown"] != 1 {
+		t.Errorf("Expected 1 click for es.pn unknown, got %d", results.UnknownBitlinks["http://es.pn/unknown"])
 	}
 
-	if aggregator.isShortlink("https://github.com/") {
-		t.Error("Expected github.com to NOT be identified as shortlink")
+	// Test that the final summary lists only the mapped long URLs
+	final := aggregator.GetSortedURLs(true)
+	expected := []KeyValue{{Key: "https://github.com/", Value: 1}, {Key: "https://google.com/", Value: 2}}
+	if !reflect.DeepEqual(final, expected) {
+		t.Errorf("Expected final summary %v, got %v", expected, final)
 	}
 }
 
@@ -582,12 +567,9 @@ func TestAggregator_GetSortedURLs_ExcludeShortlinks(t *testing.T) {
 	config := AggregationConfig{FilterYear: 0, SortDesc: true}
 	aggregator := NewAggregator(mapping, config)
 
-	// Add a known shortlink to unknown bitlinks list
-	aggregator.results.UnknownBitlinks = append(aggregator.results.UnknownBitlinks, "http://bit.ly/unknown")
-
 	// Add test data including a shortlink
 	aggregator.results.ClicksByURL["https://google.com/"] = 100
-	aggregator.results.ClicksByURL["http://bit.ly/unknown"] = 200
+	aggregator.results.UnknownBitlinks["http://bit.ly/unknown"] = 200
 
 	// Test excluding shortlinks
 	sortedURLs := aggregator.GetSortedURLs(true)
@@ -609,3 +591,316 @@ func TestAggregator_GetSortedURLs_ExcludeShortlinks(t *testing.T) {
 		t.Fatalf("Expected 2 URLs (shortlinks included), got %d", len(sortedURLsAll))
 	}
 }
+
+// Test that source file provenance is recorded in the results
+func TestAggregator_AddSourceFiles(t *testing.T) {
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{FilterYear: 0})
+
+	aggregator.AddSourceFiles(SourceFile{Path: "a.json", Records: 3})
+	aggregator.AddSourceFiles(SourceFile{Path: "b.json", Records: 5})
+
+	sources := aggregator.GetResults().SourceFiles
+	if len(sources) != 2 || sources[0].Path != "a.json" || sources[1].Records != 5 {
+		t.Errorf("Expected a.json and b.json sources in order, got %+v", sources)
+	}
+}
+
+// Test half-open time range filtering and the filtered-out breakdown
+func TestAggregator_TimeRangeFiltering(t *testing.T) {
+	mapping := URLMapping{
+		"http://bit.ly/test": "https://example.com/",
+	}
+
+	config := AggregationConfig{TimeRange: TimeRange{
+		From: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+	}}
+	aggregator := NewAggregator(mapping, config)
+
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/test", Timestamp: "2021-02-28T23:59:59Z", Referrer: "direct"}, // Before
+		{Bitlink: "http://bit.ly/test", Timestamp: "2021-03-01T00:00:00Z", Referrer: "direct"}, // Inclusive start
+		{Bitlink: "http://bit.ly/test", Timestamp: "2021-03-31T23:59:59Z", Referrer: "direct"}, // Inside
+		{Bitlink: "http://bit.ly/test", Timestamp: "2021-04-01T00:00:00Z", Referrer: "direct"}, // Exclusive end
+		{Bitlink: "http://bit.ly/test", Timestamp: "2021-07-04T00:00:00Z", Referrer: "direct"}, // After
+		{Bitlink: "http://bit.ly/test", Timestamp: "not-a-time", Referrer: "direct"},           // Unparseable
+	}
+
+	for _, record := range records {
+		err := aggregator.ProcessRecord(record)
+		// A bad timestamp is left to the error policy even with a filter active
+		var rejected *RecordError
+		if record.Timestamp == "not-a-time" {
+			if !errors.As(err, &rejected) || rejected.Category != ErrorCategoryTimestamp {
+				t.Errorf("Expected a timestamp RecordError, got %v", err)
+			}
+		} else if err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	results := aggregator.GetResults()
+	if results.TotalClicks != 2 {
+		t.Errorf("Expected 2 clicks in range, got %d", results.TotalClicks)
+	}
+	if results.FilteredOut != 4 {
+		t.Errorf("Expected 4 filtered out records, got %d", results.FilteredOut)
+	}
+
+	expected := FilterBreakdown{BeforeRange: 1, AfterRange: 2, Unparseable: 1}
+	if results.FilteredReasons != expected {
+		t.Errorf("Expected breakdown %+v, got %+v", expected, results.FilteredReasons)
+	}
+}
+
+// Test that a year filter is reported as its equivalent time range
+func TestAggregator_YearFilterRange(t *testing.T) {
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{FilterYear: 2020})
+
+	results := aggregator.GetResults()
+	if results.FilterRange != YearRange(2020, nil) {
+		t.Errorf("Expected filter range %s, got %s", YearRange(2020, nil), results.FilterRange)
+	}
+
+	aggregator.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/x", Timestamp: "2019-12-31T23:59:59Z"})
+	aggregator.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/x", Timestamp: "2021-01-01T00:00:00Z"})
+
+	reasons := aggregator.GetResults().FilteredReasons
+	if reasons.BeforeRange != 1 || reasons.AfterRange != 1 {
+		t.Errorf("Expected one record before and one after 2020, got %+v", reasons)
+	}
+}
+
+// Test that the configured granularity drives ClicksByDate keys and the time series
+func TestAggregator_HourlyGranularity(t *testing.T) {
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{Granularity: GranularityHour})
+
+	for _, ts := range []string{"2021-03-01T10:05:00Z", "2021-03-01T10:55:00Z", "2021-03-01T13:00:00Z"} {
+		if err := aggregator.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/x", Timestamp: ts}); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	if clicks := aggregator.GetResults().ClicksByDate["2021-03-01T10:00Z"]; clicks != 2 {
+		t.Errorf("Expected 2 clicks in the 10:00 bucket, got %d", clicks)
+	}
+
+	series, err := aggregator.GetTimeSeries()
+	if err != nil {
+		t.Fatalf("GetTimeSeries failed: %v", err)
+	}
+	if len(series) != 4 || series[1].Clicks != 0 || series[3].Label != "2021-03-01T13:00Z" {
+		t.Errorf("Expected 4 zero-filled hourly buckets ending at 13:00, got %+v", series)
+	}
+}
+
+// aggregateRecords feeds records through a new aggregator, failing the test on any error
+func aggregateRecords(t *testing.T, mapping URLMapping, config AggregationConfig, records ...DecodeRecord) *Aggregator {
+	t.Helper()
+
+	aggregator := NewAggregator(mapping, config)
+	for _, record := range records {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+	return aggregator
+}
+
+// mustLoadLocation loads an IANA zone or fails the test
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("Time zone %s unavailable: %v", name, err)
+	}
+	return loc
+}
+
+// Test that dates are bucketed in the configured zone near midnight
+func TestAggregator_TimeZoneMidnight(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{Location: newYork})
+
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-02T04:59:59Z"}, // 23:59:59 EST on 03-01
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-02T05:00:00Z"}, // 00:00:00 EST on 03-02
+	}
+	for _, record := range records {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	results := aggregator.GetResults()
+	if results.ClicksByDate["2021-03-01"] != 1 || results.ClicksByDate["2021-03-02"] != 1 {
+		t.Errorf("Expected one click on each New York date, got %v", results.ClicksByDate)
+	}
+	if results.TimeZone != "America/New_York" {
+		t.Errorf("Expected TimeZone America/New_York, got %s", results.TimeZone)
+	}
+}
+
+// Test that the year filter follows New Year in the configured zone
+func TestAggregator_TimeZoneNewYear(t *testing.T) {
+	tokyo := mustLoadLocation(t, "Asia/Tokyo")
+	newYork := mustLoadLocation(t, "America/New_York")
+
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/x", Timestamp: "2020-12-31T15:30:00Z"}, // 2021-01-01 00:30 in Tokyo, 2020 in New York
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-01-01T03:00:00Z"}, // 2021 in Tokyo, 2020-12-31 22:00 in New York
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-01-01T05:00:00Z"}, // 2021 everywhere
+	}
+
+	cases := []struct {
+		loc      *time.Location
+		expected int
+	}{
+		{time.UTC, 2},
+		{tokyo, 3},
+		{newYork, 1},
+	}
+
+	for _, tc := range cases {
+		aggregator := NewAggregator(URLMapping{}, AggregationConfig{FilterYear: 2021, Location: tc.loc})
+		for _, record := range records {
+			if err := aggregator.ProcessRecord(record); err != nil {
+				t.Fatalf("ProcessRecord failed: %v", err)
+			}
+		}
+		if clicks := aggregator.GetResults().TotalClicks; clicks != tc.expected {
+			t.Errorf("%s: expected %d clicks in 2021, got %d", tc.loc, tc.expected, clicks)
+		}
+	}
+}
+
+// Test hourly bucketing across the autumn DST transition, when 01:00 occurs twice
+func TestAggregator_TimeZoneDSTHours(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{Location: newYork, Granularity: GranularityHour})
+
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-11-07T05:30:00Z"}, // 01:30 EDT
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-11-07T06:30:00Z"}, // 01:30 EST
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-11-07T07:30:00Z"}, // 02:30 EST
+	}
+	for _, record := range records {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	series, err := aggregator.GetTimeSeries()
+	if err != nil {
+		t.Fatalf("GetTimeSeries failed: %v", err)
+	}
+
+	var labels []string
+	for _, bucket := range series {
+		labels = append(labels, bucket.Label)
+		if bucket.Clicks != 1 {
+			t.Errorf("Expected 1 click in %s, got %d", bucket.Label, bucket.Clicks)
+		}
+	}
+	expected := []string{"2021-11-07T01:00-04:00", "2021-11-07T01:00-05:00", "2021-11-07T02:00-05:00"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected DST-aware hourly labels %v, got %v", expected, labels)
+	}
+}
+
+// Test daily series across the spring DST transition (a 23-hour day)
+func TestAggregator_TimeZoneDSTDays(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{Location: newYork})
+
+	aggregator.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-13T12:00:00Z"})
+	aggregator.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-15T12:00:00Z"})
+
+	series, err := aggregator.GetTimeSeries()
+	if err != nil {
+		t.Fatalf("GetTimeSeries failed: %v", err)
+	}
+	if len(series) != 3 || series[1].Label != "2021-03-14" || series[1].Clicks != 0 {
+		t.Errorf("Expected 3 daily buckets with an empty 2021-03-14, got %+v", series)
+	}
+}
+
+func TestAggregator_MixedTimestampFormats(t *testing.T) {
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{
+		FilterYear:       2021,
+		TimestampLayouts: []string{"2006-01-02 15:04:05"},
+	})
+
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T12:00:00Z"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T23:30:00-05:00"}, // 2021-03-02 in UTC
+		{Bitlink: "http://bit.ly/x", Timestamp: "1614600000"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "1614600000250"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01 12:00:00"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "1577836800"}, // 2020-01-01, filtered out
+	}
+	for _, record := range records {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord(%q) failed: %v", record.Timestamp, err)
+		}
+	}
+
+	results := aggregator.GetResults()
+	if results.TotalClicks != 5 || results.FilteredOut != 1 {
+		t.Errorf("Expected 5 clicks and 1 filtered out, got %d and %d", results.TotalClicks, results.FilteredOut)
+	}
+	if results.ClicksByDate["2021-03-01"] != 4 || results.ClicksByDate["2021-03-02"] != 1 {
+		t.Errorf("Unexpected ClicksByDate: %v", results.ClicksByDate)
+	}
+
+	expected := map[string]int{
+		TimestampRFC3339:             2,
+		TimestampEpochSeconds:        2,
+		TimestampEpochMillis:         1,
+		"layout:2006-01-02 15:04:05": 1,
+	}
+	if !reflect.DeepEqual(results.TimestampFormats, expected) {
+		t.Errorf("Expected TimestampFormats %v, got %v", expected, results.TimestampFormats)
+	}
+}
+
+func TestAggregator_UnsupportedTimestampError(t *testing.T) {
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{})
+
+	err := aggregator.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/x", Timestamp: "01/03/2021"})
+	if err == nil || !strings.Contains(err.Error(), "no matching timestamp format") {
+		t.Errorf("Expected a no matching format error, got %v", err)
+	}
+}
+
+func TestAggregator_UserAgentDimensions(t *testing.T) {
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{FilterYear: 2021})
+
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.142 Safari/537.36"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.142 Safari/537.36"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", UserAgent: "Mozilla/5.0 (Linux; U; Android 4.3; en-us; HUAWEI Y530-U00 Build/HuaweiY530-U00) AppleWebKit/534.30 (KHTML, like Gecko) Version/4.0 Mobile Safari/534.30 [FBAN/FB4A;FBAV/23.0.0.22.14;]"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2020-03-01T00:00:00Z", UserAgent: "Mozilla/5.0 (Android; Tablet; rv:30.0) Gecko/30.0 Firefox/30.0"}, // Filtered out
+	}
+	for _, record := range records {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	results := aggregator.GetResults()
+	expectedBrowsers := map[string]int{"Chrome": 2, "Facebook": 1, UserAgentUnknown: 1}
+	if !reflect.DeepEqual(results.ClicksByBrowser, expectedBrowsers) {
+		t.Errorf("Expected ClicksByBrowser %v, got %v", expectedBrowsers, results.ClicksByBrowser)
+	}
+	expectedOS := map[string]int{"Windows": 2, "Android": 1, UserAgentUnknown: 1}
+	if !reflect.DeepEqual(results.ClicksByOS, expectedOS) {
+		t.Errorf("Expected ClicksByOS %v, got %v", expectedOS, results.ClicksByOS)
+	}
+	expectedDevices := map[string]int{"desktop": 2, "mobile": 1, "unknown": 1}
+	if !reflect.DeepEqual(results.ClicksByDevice, expectedDevices) {
+		t.Errorf("Expected ClicksByDevice %v, got %v", expectedDevices, results.ClicksByDevice)
+	}
+}
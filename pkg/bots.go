@@ -0,0 +1,302 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BotRule decides whether a click came from an automated client
+// Rules may keep state (see RateRule) and must be safe for concurrent use.
+type BotRule interface {
+	Name() string // Reported in AggregationResults.BotClicksByRule
+	Match(record DecodeRecord, at time.Time) bool
+}
+
+// SequentialRule is implemented by bot rules whose verdict depends on the clicks
+// matched before from the same remote IP, such as RateRule. While one is active,
+// ProcessParallel shards records by IP so each IP's clicks reach the rule in
+// stream order, as in a serial run. State must therefore be kept per IP.
+type SequentialRule interface {
+	BotRule
+	Sequential() bool
+}
+
+// BotDetector runs clicks through an ordered set of bot rules
+type BotDetector struct {
+	rules []BotRule
+}
+
+// NewBotDetector creates a detector; rules are tried in order and the first match wins
+func NewBotDetector(rules ...BotRule) *BotDetector {
+	return &BotDetector{rules: rules}
+}
+
+// Match returns the name of the first rule matching the click, or "" for a human click
+// at is the click's parsed timestamp.
+func (d *BotDetector) Match(record DecodeRecord, at time.Time) string {
+	for _, rule := range d.rules {
+		if rule.Match(record, at) {
+			return rule.Name()
+		}
+	}
+	return ""
+}
+
+// Rules returns the detector's rules in evaluation order
+func (d *BotDetector) Rules() []BotRule {
+	return d.rules
+}
+
+// Sequential reports whether any rule needs to see clicks in stream order
+func (d *BotDetector) Sequential() bool {
+	for _, rule := range d.rules {
+		if sequential, ok := rule.(SequentialRule); ok && sequential.Sequential() {
+			return true
+		}
+	}
+	return false
+}
+
+// UserAgentRule matches user agents containing any substring (case-insensitive)
+// or matching any regular expression
+type UserAgentRule struct {
+	RuleName   string
+	Substrings []string // Compared against the lower-cased user agent
+	Patterns   []*regexp.Regexp
+}
+
+func (r *UserAgentRule) Name() string { return r.RuleName }
+
+func (r *UserAgentRule) Match(record DecodeRecord, at time.Time) bool {
+	lower := strings.ToLower(record.UserAgent)
+	for _, substring := range r.Substrings {
+		if strings.Contains(lower, substring) {
+			return true
+		}
+	}
+	for _, pattern := range r.Patterns {
+		if pattern.MatchString(record.UserAgent) {
+			return true
+		}
+	}
+	return false
+}
+
+// CIDRRule matches clicks whose remote IP is in any of the networks,
+// such as published crawler or data-centre ranges
+type CIDRRule struct {
+	RuleName string
+	Networks []netip.Prefix
+}
+
+func (r *CIDRRule) Name() string { return r.RuleName }
+
+func (r *CIDRRule) Match(record DecodeRecord, at time.Time) bool {
+	addr, err := netip.ParseAddr(record.RemoteIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap() // IPv4-mapped IPv6 addresses match IPv4 networks
+	for _, network := range r.Networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateRule flags clicks once an IP exceeds MaxClicks within a window
+// Windows are fixed intervals of click time (not arrival time), so the heuristic
+// expects roughly chronological input: only each IP's latest window is kept, and a
+// click from an earlier window is neither counted nor flagged. The verdict depends
+// on each IP's click order, which ProcessParallel preserves by sharding on IP.
+// Pruning closed windows only changes a verdict for input more than a window out
+// of order, so parallel runs match serial ones for roughly chronological input.
+type RateRule struct {
+	RuleName  string
+	MaxClicks int
+	Window    time.Duration
+
+	mu      sync.Mutex
+	perIP   map[string]ipWindow
+	latest  time.Time // Start of the latest window seen for any IP
+	pruneAt int       // Size of perIP that triggers dropping closed windows
+}
+
+// rateRulePruneSize is the smallest perIP map that RateRule prunes
+const rateRulePruneSize = 10000
+
+// ipWindow is the click count for one IP in its current window
+type ipWindow struct {
+	start  time.Time
+	clicks int
+}
+
+// NewRateRule creates a rule flagging more than maxClicks per IP within window
+func NewRateRule(name string, maxClicks int, window time.Duration) *RateRule {
+	return &RateRule{RuleName: name, MaxClicks: maxClicks, Window: window}
+}
+
+func (r *RateRule) Name() string { return r.RuleName }
+
+// Sequential is always true; see SequentialRule
+func (r *RateRule) Sequential() bool { return true }
+
+func (r *RateRule) Match(record DecodeRecord, at time.Time) bool {
+	if record.RemoteIP == "" {
+		return false
+	}
+	start := at.Truncate(r.Window)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.perIP == nil {
+		r.perIP = make(map[string]ipWindow)
+	}
+	current, seen := r.perIP[record.RemoteIP]
+	switch {
+	case seen && start.Before(current.start):
+		return false // Late click; its window is gone
+	case !seen || start.After(current.start):
+		current = ipWindow{start: start}
+	}
+	current.clicks++
+	r.perIP[record.RemoteIP] = current
+	if start.After(r.latest) {
+		r.latest = start
+	}
+	if len(r.perIP) >= max(r.pruneAt, rateRulePruneSize) {
+		r.prune()
+	}
+	return current.clicks > r.MaxClicks
+}
+
+// prune drops the IPs whose window closed before the latest one started, keeping
+// the map proportional to the IPs active in the latest windows
+func (r *RateRule) prune() {
+	for ip, window := range r.perIP {
+		if window.start.Add(r.Window).Before(r.latest) {
+			delete(r.perIP, ip)
+		}
+	}
+	r.pruneAt = 2 * len(r.perIP) // Amortizes pruning when most IPs are still active
+}
+
+// DefaultBotRules returns the built-in rules: known crawler and scripted-client
+// user agents, then more than 30 clicks per IP per minute
+func DefaultBotRules() []BotRule {
+	return []BotRule{
+		&UserAgentRule{RuleName: "user-agent", Substrings: botMarkers},
+		NewRateRule("rate", 30, time.Minute),
+	}
+}
+
+// botRuleConfig is one entry of a JSON bot rule file
+type botRuleConfig struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"` // user-agent, cidr or rate
+	Substrings []string `json:"substrings"`
+	Patterns   []string `json:"patterns"`
+	CIDRs      []string `json:"cidrs"`
+	MaxClicks  int      `json:"max_clicks"`
+	Window     string   `json:"window"` // Go duration, e.g. "1m"
+}
+
+// LoadBotRules reads a JSON bot rule file (see ParseBotRules)
+func LoadBotRules(filename string) ([]BotRule, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening bot rules: %w", err)
+	}
+	defer file.Close()
+
+	rules, err := ParseBotRules(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bot rules %s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// ParseBotRules parses a JSON array of rules, evaluated in file order:
+//
+//	[
+//	  {"name": "crawlers", "type": "user-agent", "substrings": ["bot"], "patterns": ["^python-"]},
+//	  {"name": "datacentre", "type": "cidr", "cidrs": ["66.249.64.0/19", "2001:4860::/32"]},
+//	  {"name": "burst", "type": "rate", "max_clicks": 30, "window": "1m"}
+//	]
+func ParseBotRules(r io.Reader) ([]BotRule, error) {
+	var configs []botRuleConfig
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("error decoding bot rules: %w", err)
+	}
+
+	rules := make([]BotRule, 0, len(configs))
+	for i, config := range configs {
+		rule, err := config.build()
+		if err != nil {
+			return nil, fmt.Errorf("bot rule %d (%s): %w", i, config.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// build validates a rule entry and constructs the rule
+func (c botRuleConfig) build() (BotRule, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+
+	switch c.Type {
+	case "user-agent":
+		rule := &UserAgentRule{RuleName: c.Name}
+		for _, substring := range c.Substrings {
+			rule.Substrings = append(rule.Substrings, strings.ToLower(substring))
+		}
+		for _, pattern := range c.Patterns {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern: %w", err)
+			}
+			rule.Patterns = append(rule.Patterns, compiled)
+		}
+		if len(rule.Substrings) == 0 && len(rule.Patterns) == 0 {
+			return nil, fmt.Errorf("user-agent rule needs substrings or patterns")
+		}
+		return rule, nil
+
+	case "cidr":
+		rule := &CIDRRule{RuleName: c.Name}
+		for _, cidr := range c.CIDRs {
+			network, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR: %w", err)
+			}
+			rule.Networks = append(rule.Networks, network.Masked())
+		}
+		if len(rule.Networks) == 0 {
+			return nil, fmt.Errorf("cidr rule needs cidrs")
+		}
+		return rule, nil
+
+	case "rate":
+		window, err := time.ParseDuration(c.Window)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("invalid window %q: expected a positive duration such as 1m", c.Window)
+		}
+		if c.MaxClicks <= 0 {
+			return nil, fmt.Errorf("rate rule needs a positive max_clicks")
+		}
+		return NewRateRule(c.Name, c.MaxClicks, window), nil
+	}
+	return nil, fmt.Errorf("unknown rule type %q (expected user-agent, cidr or rate)", c.Type)
+}
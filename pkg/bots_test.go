@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserAgentRule(t *testing.T) {
+	rule := &UserAgentRule{
+		RuleName:   "crawlers",
+		Substrings: []string{"bot"},
+		Patterns:   []*regexp.Regexp{regexp.MustCompile(`^python-`)},
+	}
+
+	cases := map[string]bool{
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)": true,
+		"python-requests/2.31.0": true,
+		"Mozilla/5.0 (X11; Linux i686; rv:10.0) Gecko/20100101 Firefox/10.0": false,
+		"": false,
+	}
+	for userAgent, expected := range cases {
+		if got := rule.Match(DecodeRecord{UserAgent: userAgent}, time.Time{}); got != expected {
+			t.Errorf("Match(%q): expected %v, got %v", userAgent, expected, got)
+		}
+	}
+}
+
+func TestCIDRRule(t *testing.T) {
+	rule := &CIDRRule{
+		RuleName: "datacentre",
+		Networks: []netip.Prefix{netip.MustParsePrefix("66.249.64.0/19"), netip.MustParsePrefix("2001:4860::/32")},
+	}
+
+	cases := map[string]bool{
+		"66.249.66.1":          true,
+		"::ffff:66.249.66.1":   true, // IPv4-mapped
+		"2001:4860:4801:10::1": true,
+		"66.249.96.1":          false,
+		"4.14.247.63":          false,
+		"not-an-ip":            false,
+		"":                     false,
+	}
+	for ip, expected := range cases {
+		if got := rule.Match(DecodeRecord{RemoteIP: ip}, time.Time{}); got != expected {
+			t.Errorf("Match(%q): expected %v, got %v", ip, expected, got)
+		}
+	}
+}
+
+func TestRateRule(t *testing.T) {
+	rule := NewRateRule("burst", 2, time.Minute)
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	clicks := []struct {
+		ip       string
+		offset   time.Duration
+		expected bool
+	}{
+		{"10.0.0.1", 0, false},
+		{"10.0.0.1", 10 * time.Second, false},
+		{"10.0.0.2", 15 * time.Second, false}, // Other IPs have their own count
+		{"10.0.0.1", 20 * time.Second, true},  // Third click in the minute
+		{"10.0.0.1", 50 * time.Second, true},
+		{"10.0.0.1", 65 * time.Second, false}, // Next window
+		{"10.0.0.1", 55 * time.Second, false}, // Late click from the closed window
+		{"10.0.0.1", 70 * time.Second, false}, // ... which neither counted nor reset the window
+		{"10.0.0.1", 75 * time.Second, true},
+		{"", 66 * time.Second, false},
+		{"", 67 * time.Second, false},
+		{"", 68 * time.Second, false}, // Clicks without an IP are never rate limited
+	}
+	for i, click := range clicks {
+		record := DecodeRecord{RemoteIP: click.ip}
+		if got := rule.Match(record, start.Add(click.offset)); got != click.expected {
+			t.Errorf("Click %d (%s at +%s): expected %v, got %v", i, click.ip, click.offset, click.expected, got)
+		}
+	}
+}
+
+func TestRateRule_PrunesClosedWindows(t *testing.T) {
+	rule := NewRateRule("burst", 2, time.Minute)
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// One click per IP per second, so each IP's window closes soon after
+	for i := 0; i < 5*rateRulePruneSize; i++ {
+		rule.Match(DecodeRecord{RemoteIP: fmt.Sprintf("10.%d.%d.%d", i>>16, i>>8&255, i&255)}, start.Add(time.Duration(i)*time.Second))
+	}
+	if size := len(rule.perIP); size > 2*rateRulePruneSize {
+		t.Errorf("Expected closed windows to be pruned, got %d IPs", size)
+	}
+}
+
+func TestParseBotRules(t *testing.T) {
+	testJSON := `[
+  {"name": "crawlers", "type": "user-agent", "substrings": ["Bot"], "patterns": ["^python-"]},
+  {"name": "datacentre", "type": "cidr", "cidrs": ["66.249.64.1/19"]},
+  {"name": "burst", "type": "rate", "max_clicks": 30, "window": "1m"}
+]`
+
+	rules, err := ParseBotRules(strings.NewReader(testJSON))
+	if err != nil {
+		t.Fatalf("ParseBotRules failed: %v", err)
+	}
+
+	var names []string
+	for _, rule := range rules {
+		names = append(names, rule.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"crawlers", "datacentre", "burst"}) {
+		t.Errorf("Unexpected rules: %v", names)
+	}
+	if substrings := rules[0].(*UserAgentRule).Substrings; substrings[0] != "bot" {
+		t.Errorf("Expected substrings to be lower-cased, got %v", substrings)
+	}
+	if network := rules[1].(*CIDRRule).Networks[0]; network.String() != "66.249.64.0/19" {
+		t.Errorf("Expected the network to be masked, got %s", network)
+	}
+	if rate := rules[2].(*RateRule); rate.MaxClicks != 30 || rate.Window != time.Minute {
+		t.Errorf("Unexpected rate rule: %+v", rate)
+	}
+}
+
+func TestParseBotRules_Errors(t *testing.T) {
+	cases := []string{
+		`{"name": "not-an-array"}`,
+		`[{"type": "user-agent", "substrings": ["bot"]}]`,
+		`[{"name": "x", "type": "user-agent"}]`,
+		`[{"name": "x", "type": "user-agent", "patterns": ["("]}]`,
+		`[{"name": "x", "type": "cidr", "cidrs": ["10.0.0.0/33"]}]`,
+		`[{"name": "x", "type": "rate", "max_clicks": 5, "window": "soon"}]`,
+		`[{"name": "x", "type": "rate", "max_clicks": 0, "window": "1m"}]`,
+		`[{"name": "x", "type": "geo"}]`,
+		`[{"name": "x", "type": "cidr", "cidr": ["10.0.0.0/8"]}]`, // Misspelt field
+	}
+
+	for _, testJSON := range cases {
+		if _, err := ParseBotRules(strings.NewReader(testJSON)); err == nil {
+			t.Errorf("ParseBotRules(%s): expected error, got nil", testJSON)
+		}
+	}
+}
+
+// botTestRecords has one crawler click, one data-centre click and two human clicks
+func botTestRecords() []DecodeRecord {
+	return []DecodeRecord{
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", UserAgent: "Googlebot/2.1", RemoteIP: "4.14.247.63"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", UserAgent: "Mozilla/5.0", RemoteIP: "66.249.66.1"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", UserAgent: "Mozilla/5.0", RemoteIP: "4.14.247.63"},
+		{Bitlink: "http://bit.ly/y", Timestamp: "2021-03-02T00:00:00Z", UserAgent: "Mozilla/5.0", RemoteIP: "4.14.247.63"},
+		{Bitlink: "http://bit.ly/y", Timestamp: "2020-03-02T00:00:00Z", UserAgent: "Googlebot/2.1"}, // Filtered by year first
+	}
+}
+
+func botTestDetector() *BotDetector {
+	return NewBotDetector(
+		&UserAgentRule{RuleName: "crawlers", Substrings: []string{"bot"}},
+		&CIDRRule{RuleName: "datacentre", Networks: []netip.Prefix{netip.MustParsePrefix("66.249.64.0/19")}},
+	)
+}
+
+func TestAggregator_Bots(t *testing.T) {
+	cases := []struct {
+		exclude     bool
+		totalClicks int
+		clicksOnX   int
+	}{
+		{false, 4, 3}, // Flag only: bots stay in the totals
+		{true, 2, 1},
+	}
+
+	for _, tc := range cases {
+		config := AggregationConfig{FilterYear: 2021, Bots: botTestDetector(), ExcludeBots: tc.exclude}
+		aggregator := NewAggregator(URLMapping{}, config)
+		for _, record := range botTestRecords() {
+			if err := aggregator.ProcessRecord(record); err != nil {
+				t.Fatalf("ProcessRecord failed: %v", err)
+			}
+		}
+
+		results := aggregator.GetResults()
+		if results.BotClicks != 2 || results.BotsExcluded != tc.exclude {
+			t.Errorf("exclude=%v: expected 2 bot clicks, got %d (excluded: %v)", tc.exclude, results.BotClicks, results.BotsExcluded)
+		}
+		expectedRules := map[string]int{"crawlers": 1, "datacentre": 1}
+		if !reflect.DeepEqual(results.BotClicksByRule, expectedRules) {
+			t.Errorf("exclude=%v: expected BotClicksByRule %v, got %v", tc.exclude, expectedRules, results.BotClicksByRule)
+		}
+		if results.TotalClicks != tc.totalClicks || results.UnknownBitlinks["http://bit.ly/x"] != tc.clicksOnX {
+			t.Errorf("exclude=%v: expected %d clicks (%d on x), got %d (%v)",
+				tc.exclude, tc.totalClicks, tc.clicksOnX, results.TotalClicks, results.UnknownBitlinks)
+		}
+	}
+}
+
+func TestAggregator_ProcessParallel_Bots(t *testing.T) {
+	records := syntheticRecords(5000)
+	for i := range records {
+		if i%10 == 0 {
+			records[i].UserAgent = "curl/8.4.0"
+		}
+	}
+	config := AggregationConfig{Bots: NewBotDetector(DefaultBotRules()...), ExcludeBots: true}
+
+	serial := NewAggregator(syntheticMapping(), config)
+	for _, record := range records {
+		if err := serial.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	config.Bots = NewBotDetector(DefaultBotRules()...) // Fresh rate state
+	parallel := NewAggregator(syntheticMapping(), config)
+	if err := parallel.ProcessParallel(sliceSource(records), ParallelConfig{Workers: 4, BatchSize: 64}); err != nil {
+		t.Fatalf("ProcessParallel failed: %v", err)
+	}
+
+	if serial.GetResults().BotClicks != 500 {
+		t.Errorf("Expected 500 bot clicks, got %d", serial.GetResults().BotClicks)
+	}
+	if !reflect.DeepEqual(serial.GetResults().BotClicksByRule, parallel.GetResults().BotClicksByRule) {
+		t.Errorf("Serial %v and parallel %v bot counts differ", serial.GetResults().BotClicksByRule, parallel.GetResults().BotClicksByRule)
+	}
+	if serial.GetResults().TotalClicks != parallel.GetResults().TotalClicks {
+		t.Errorf("Serial %d and parallel %d totals differ", serial.GetResults().TotalClicks, parallel.GetResults().TotalClicks)
+	}
+}
+
+// Rate rules depend on click order, so they must flag the same clicks as a serial run
+func TestAggregator_ProcessParallel_RateRuleMatchesSerial(t *testing.T) {
+	records := syntheticRecords(6000)
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := range records {
+		records[i].RemoteIP = fmt.Sprintf("10.0.0.%d", i%5)
+		records[i].Timestamp = start.Add(time.Duration(i) * 50 * time.Millisecond).Format(time.RFC3339Nano)
+	}
+	newConfig := func() AggregationConfig {
+		return AggregationConfig{Bots: NewBotDetector(DefaultBotRules()...), ExcludeBots: true, SortDesc: true}
+	}
+
+	serial := NewAggregator(syntheticMapping(), newConfig())
+	for _, record := range records {
+		if err := serial.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+	if serial.GetResults().BotClicksByRule["rate"] == 0 {
+		t.Fatal("Expected the rate rule to flag clicks")
+	}
+
+	for run := 0; run < 3; run++ {
+		parallel := NewAggregator(syntheticMapping(), newConfig())
+		if err := parallel.ProcessParallel(sliceSource(records), ParallelConfig{Workers: 8, BatchSize: 16}); err != nil {
+			t.Fatalf("ProcessParallel failed: %v", err)
+		}
+		if !reflect.DeepEqual(serial.GetResults(), parallel.GetResults()) {
+			t.Fatalf("Run %d: parallel results with a rate rule differ from serial results", run)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression identifies the compression applied to an input file
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionBzip2
+	CompressionZstd
+)
+
+// String returns the conventional name of the compression scheme
+func (c Compression) String() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionBzip2:
+		return "bzip2"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// Magic byte prefixes for the supported compression formats
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh") // Followed by the block size digit and a block or end-of-stream magic
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// bzip2 block and end-of-stream magics (the BCD digits of pi and sqrt(pi)), which
+// follow "BZh" and the block size digit '1'..'9'
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// bzip2HeaderLen is the number of bytes isBzip2 needs to see
+const bzip2HeaderLen = 10
+
+// compressionFromExtension maps a filename extension to a compression scheme
+func compressionFromExtension(filename string) Compression {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz", ".gzip":
+		return CompressionGzip
+	case ".bz2", ".bzip2":
+		return CompressionBzip2
+	case ".zst", ".zstd":
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// compressionFromMagic inspects the leading bytes of a stream
+func compressionFromMagic(header []byte) Compression {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return CompressionZstd
+	case isBzip2(header):
+		return CompressionBzip2
+	}
+	return CompressionNone
+}
+
+// isBzip2 checks the full stream header, so text that merely starts with "BZh"
+// is not taken for bzip2
+func isBzip2(header []byte) bool {
+	if len(header) < bzip2HeaderLen || !bytes.HasPrefix(header, bzip2Magic) || header[3] < '1' || header[3] > '9' {
+		return false
+	}
+	magic := header[4:bzip2HeaderLen]
+	return bytes.Equal(magic, bzip2BlockMagic) || bytes.Equal(magic, bzip2EndMagic)
+}
+
+// DetectCompression peeks at the stream without consuming it
+// Magic bytes take precedence; the filename extension is used as a fallback
+func DetectCompression(reader *bufio.Reader, filename string) Compression {
+	// Peek returns what it can along with an error for short inputs, which is fine here
+	header, _ := reader.Peek(bzip2HeaderLen) // The longest header checked
+	if compression := compressionFromMagic(header); compression != CompressionNone {
+		return compression
+	}
+	return compressionFromExtension(filename)
+}
+
+// decompressReader wraps r with a streaming decompressor when the input is compressed
+// Data is decompressed on the fly, so no temporary files are created and memory stays constant
+// The returned closer releases decompressor resources; it does not close r
+func decompressReader(r io.Reader, filename string) (io.Reader, func(), error) {
+	reader := bufio.NewReader(r)
+
+	switch compression := DetectCompression(reader, filename); compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening gzip stream: %w", err)
+		}
+		return gz, func() { gz.Close() }, nil
+	case CompressionBzip2:
+		return bzip2.NewReader(reader), func() {}, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening zstd stream: %w", err)
+		}
+		return zr, zr.Close, nil
+	}
+
+	return reader, func() {}, nil
+}
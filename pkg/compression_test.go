@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressedTestNDJSON = `{"bitlink": "http://bit.ly/31Tt55y", "timestamp": "2020-02-15T00:00:00Z", "referrer": "t.co"}
+`
+
+// bzip2 of compressedTestNDJSON; the standard library has no bzip2 writer
+const compressedTestNDJSONBzip2 = "QlpoOTFBWSZTWQLRuKEAAC5bgAAQUAf6EAQQO2/cKiAAVEUeU8oeo0AaBo9NQag1MmhoPUZMgHqJEWLXl87uviEB7gMzQRvMpkDa9TJMEHwlMlaODSE0SOYeNI62kEytn9Symq3iApFyJH1xdPxdyRThQkALRuKE"
+
+func gzipBytes(t *testing.T, data string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(data)); err != nil {
+		t.Fatalf("Failed to gzip data: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to close gzip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data string) []byte {
+	t.Helper()
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("Failed to create zstd encoder: %v", err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll([]byte(data), nil)
+}
+
+// writeTempBytes writes binary content to a file with the given name in a temp directory
+func writeTempBytes(t *testing.T, name string, content []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	return path
+}
+
+func TestStreamDecodesFormat_Compressed(t *testing.T) {
+	bzip2Data, err := base64.StdEncoding.DecodeString(compressedTestNDJSONBzip2)
+	if err != nil {
+		t.Fatalf("Failed to decode bzip2 fixture: %v", err)
+	}
+
+	// Names deliberately omit the extension so detection relies on magic bytes
+	cases := map[string][]byte{
+		"decodes_gzip":  gzipBytes(t, compressedTestNDJSON),
+		"decodes_bzip2": bzip2Data,
+		"decodes_zstd":  zstdBytes(t, compressedTestNDJSON),
+	}
+
+	for name, content := range cases {
+		filename := writeTempBytes(t, name, content)
+
+		records, err := collectDecodes(t, filename, FormatAuto)
+		if err != nil {
+			t.Fatalf("%s: StreamDecodesFormat failed: %v", name, err)
+		}
+		if len(records) != 1 || records[0].Bitlink != "http://bit.ly/31Tt55y" {
+			t.Errorf("%s: expected 1 decoded record, got %+v", name, records)
+		}
+	}
+}
+
+func TestReadEncodesMappings_Gzip(t *testing.T) {
+	testCSV := `long_url,domain,hash
+https://google.com/,bit.ly,31Tt55y`
+	filename := writeTempBytes(t, "encodes.csv.gz", gzipBytes(t, testCSV))
+
+	mapping, err := ReadEncodesMappings(filename)
+	if err != nil {
+		t.Fatalf("ReadEncodesMappings failed: %v", err)
+	}
+	if mapping["http://bit.ly/31Tt55y"] != "https://google.com/" {
+		t.Errorf("Expected google.com mapping, got %+v", mapping)
+	}
+}
+
+func TestStreamDecodesFormat_CorruptGzipExtension(t *testing.T) {
+	// Extension claims gzip but the content is plain text
+	filename := writeTempBytes(t, "decodes.json.gz", []byte("[]"))
+
+	if _, err := collectDecodes(t, filename, FormatAuto); err == nil {
+		t.Error("Expected error for invalid gzip content, got nil")
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	cases := []struct {
+		content  []byte
+		filename string
+		expected Compression
+	}{
+		{[]byte("[{}]"), "decodes.json", CompressionNone},
+		{[]byte{0x1f, 0x8b, 0x08}, "decodes.json", CompressionGzip},
+		{[]byte("BZh91AY&SY\x00"), "decodes", CompressionBzip2},
+		{[]byte("BZh9\x17\x72\x45\x38\x50\x90"), "decodes", CompressionBzip2}, // Empty stream
+		{[]byte("BZh91AY"), "decodes", CompressionNone},                       // Too short for a block
+		{[]byte("BZh is not bzip2\n"), "decodes.ndjson", CompressionNone},
+		{[]byte("BZh0AY&SY\x00"), "decodes", CompressionNone}, // No such block size
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, "decodes", CompressionZstd},
+		{[]byte("x"), "decodes.json.ZST", CompressionZstd},
+		{[]byte{}, "decodes.bz2", CompressionBzip2},
+	}
+
+	for _, tc := range cases {
+		reader := bufio.NewReader(bytes.NewReader(tc.content))
+		if got := DetectCompression(reader, tc.filename); got != tc.expected {
+			t.Errorf("DetectCompression(%q, %q): expected %s, got %s", tc.content, tc.filename, tc.expected, got)
+		}
+	}
+}
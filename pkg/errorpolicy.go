@@ -0,0 +1,238 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Categories of rejected records, as counted in AggregationResults.RecordErrors
+const (
+	ErrorCategoryDecode    = "decode"    // Malformed JSON or fields of the wrong type
+	ErrorCategoryTimestamp = "timestamp" // Timestamp in no recognised format
+)
+
+// ErrErrorBudgetExceeded is returned once more records are rejected than the budget allows
+var ErrErrorBudgetExceeded = errors.New("error budget exceeded")
+
+// ErrorMode selects what happens to a record that cannot be decoded or aggregated
+// The zero value fails the run, matching the original behaviour.
+type ErrorMode int
+
+const (
+	ErrorModeFail       ErrorMode = iota // Stop at the first bad record
+	ErrorModeSkip                        // Count the bad record and carry on
+	ErrorModeDeadLetter                  // Count it, write it to the dead-letter output and carry on
+)
+
+// String returns the flag-friendly name of the mode
+func (m ErrorMode) String() string {
+	switch m {
+	case ErrorModeSkip:
+		return "skip"
+	case ErrorModeDeadLetter:
+		return "deadletter"
+	default:
+		return "fail"
+	}
+}
+
+// ParseErrorMode converts a flag value into an ErrorMode
+func ParseErrorMode(value string) (ErrorMode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "fail":
+		return ErrorModeFail, nil
+	case "skip":
+		return ErrorModeSkip, nil
+	case "deadletter", "dead-letter":
+		return ErrorModeDeadLetter, nil
+	}
+	return ErrorModeFail, fmt.Errorf("unknown error mode %q (expected fail, skip or deadletter)", value)
+}
+
+// ErrorBudget bounds how many records a lenient run may reject
+// Zero fields are unlimited.
+type ErrorBudget struct {
+	MaxErrors  int     // Absolute number of rejected records, enforced as soon as it is exceeded
+	MaxPercent float64 // Rejected share of all records read, enforced when the input ends
+}
+
+// IsZero reports whether the budget is unlimited
+func (b ErrorBudget) IsZero() bool {
+	return b.MaxErrors == 0 && b.MaxPercent == 0
+}
+
+// String renders the budget as accepted by ParseErrorBudget
+func (b ErrorBudget) String() string {
+	switch {
+	case b.MaxPercent > 0:
+		return strconv.FormatFloat(b.MaxPercent, 'f', -1, 64) + "%"
+	case b.MaxErrors > 0:
+		return strconv.Itoa(b.MaxErrors)
+	default:
+		return "unlimited"
+	}
+}
+
+// ParseErrorBudget parses a budget given as a count ("100") or a percentage ("0.5%")
+// An empty value is unlimited.
+func ParseErrorBudget(value string) (ErrorBudget, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ErrorBudget{}, nil
+	}
+
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		max, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || max <= 0 || max > 100 {
+			return ErrorBudget{}, fmt.Errorf("invalid error budget %q: expected a percentage between 0 and 100", value)
+		}
+		return ErrorBudget{MaxPercent: max}, nil
+	}
+
+	max, err := strconv.Atoi(value)
+	if err != nil || max <= 0 {
+		return ErrorBudget{}, fmt.Errorf("invalid error budget %q: expected a positive count or a percentage such as 1%%", value)
+	}
+	return ErrorBudget{MaxErrors: max}, nil
+}
+
+// RecordError describes a rejected record and where it was found
+// The aggregator returns one for records it cannot use; the streaming readers fill in
+// the position and raw bytes before handing it to the ErrorPolicy.
+type RecordError struct {
+	Category string
+	File     string // Input file ("" for stdin and io.Reader sources)
+	Line     int    // 1-based line for NDJSON input (0 when not line-based)
+	Record   int    // 0-based index of the record in its input
+	Offset   int64  // Byte offset of the record in the decompressed input (-1 when unknown)
+	Raw      []byte // The record as read
+	Err      error
+}
+
+func (e *RecordError) Error() string {
+	return e.Err.Error()
+}
+
+// setPosition copies where the record was read from out of position
+func (e *RecordError) setPosition(position *RecordError) {
+	e.File, e.Line, e.Record = position.File, position.Line, position.Record
+	e.Offset, e.Raw = position.Offset, position.Raw
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// deadLetterEntry is one line of the dead-letter NDJSON output
+type deadLetterEntry struct {
+	Category string `json:"category"`
+	Reason   string `json:"reason"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Record   int    `json:"record"`
+	Offset   *int64 `json:"offset,omitempty"`
+	Raw      string `json:"raw"`
+}
+
+// ErrorPolicy decides whether a bad record stops the run, and keeps count of rejections
+// It is safe for concurrent use. A nil *ErrorPolicy behaves like ErrorModeFail.
+type ErrorPolicy struct {
+	mode       ErrorMode
+	budget     ErrorBudget
+	deadLetter *json.Encoder
+
+	mu     sync.Mutex
+	counts map[string]int
+	total  int
+}
+
+// NewErrorPolicy creates a policy; deadLetter receives rejected records as NDJSON
+// in ErrorModeDeadLetter and is ignored otherwise
+func NewErrorPolicy(mode ErrorMode, budget ErrorBudget, deadLetter io.Writer) *ErrorPolicy {
+	policy := &ErrorPolicy{mode: mode, budget: budget, counts: make(map[string]int)}
+	if mode == ErrorModeDeadLetter && deadLetter != nil {
+		policy.deadLetter = json.NewEncoder(deadLetter)
+	}
+	return policy
+}
+
+// lenient reports whether bad records are skipped rather than fatal
+func (p *ErrorPolicy) lenient() bool {
+	return p != nil && p.mode != ErrorModeFail
+}
+
+// Reject handles a bad record. It returns nil when the record is skipped, or the
+// error that should stop the run: the record error itself in ErrorModeFail, or
+// ErrErrorBudgetExceeded once the count budget is used up.
+func (p *ErrorPolicy) Reject(rejected *RecordError) error {
+	if !p.lenient() {
+		return rejected
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.counts[rejected.Category]++
+	p.total++
+	if p.deadLetter != nil {
+		entry := deadLetterEntry{
+			Category: rejected.Category,
+			Reason:   rejected.Err.Error(),
+			File:     rejected.File,
+			Line:     rejected.Line,
+			Record:   rejected.Record,
+			Raw:      string(rejected.Raw),
+		}
+		if rejected.Offset >= 0 {
+			entry.Offset = &rejected.Offset
+		}
+		if err := p.deadLetter.Encode(entry); err != nil {
+			return fmt.Errorf("error writing dead letter: %w", err)
+		}
+	}
+
+	if p.budget.MaxErrors > 0 && p.total > p.budget.MaxErrors {
+		return fmt.Errorf("%w: more than %d bad records (last: %v)", ErrErrorBudgetExceeded, p.budget.MaxErrors, rejected)
+	}
+	return nil
+}
+
+// Counts returns the number of rejected records per category
+func (p *ErrorPolicy) Counts() map[string]int {
+	counts := make(map[string]int)
+	if p == nil {
+		return counts
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for category, count := range p.counts {
+		counts[category] = count
+	}
+	return counts
+}
+
+// CheckBudget enforces the percentage budget once the input has ended
+// decoded is the number of records that decoded successfully; records rejected
+// as undecodable are added to it to get the number of records read.
+func (p *ErrorPolicy) CheckBudget(decoded int) error {
+	if p == nil || p.budget.MaxPercent == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	read := decoded + p.counts[ErrorCategoryDecode]
+	if read == 0 {
+		return nil
+	}
+	if percent := float64(p.total) * 100 / float64(read); percent > p.budget.MaxPercent {
+		return fmt.Errorf("%w: %d of %d records rejected (%.2f%%, max %s)", ErrErrorBudgetExceeded, p.total, read, percent, p.budget)
+	}
+	return nil
+}
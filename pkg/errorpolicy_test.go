@@ -0,0 +1,306 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lenientNDJSON has a malformed line, a wrongly typed field and an unparseable timestamp
+const lenientNDJSON = `{"bitlink": "http://bit.ly/a", "timestamp": "2021-03-01T00:00:00Z"}
+{"bitlink": "http://bit.ly/b", "timestamp":
+
+{"bitlink": 42, "timestamp": "2021-03-01T00:00:00Z"}
+{"bitlink": "http://bit.ly/c", "timestamp": "yesterday"}
+{"bitlink": "http://bit.ly/d", "timestamp": "2021-03-02T00:00:00Z"}
+`
+
+// readDeadLetters decodes every dead-letter entry written to buf
+func readDeadLetters(t *testing.T, buf *bytes.Buffer) []deadLetterEntry {
+	t.Helper()
+
+	var entries []deadLetterEntry
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		var entry deadLetterEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("Invalid dead-letter output: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestParseErrorMode(t *testing.T) {
+	cases := map[string]ErrorMode{
+		"":            ErrorModeFail,
+		"fail":        ErrorModeFail,
+		"SKIP":        ErrorModeSkip,
+		"deadletter":  ErrorModeDeadLetter,
+		"dead-letter": ErrorModeDeadLetter,
+	}
+	for value, expected := range cases {
+		mode, err := ParseErrorMode(value)
+		if err != nil || mode != expected {
+			t.Errorf("ParseErrorMode(%q): expected %s, got %s (err: %v)", value, expected, mode, err)
+		}
+	}
+
+	if _, err := ParseErrorMode("ignore"); err == nil {
+		t.Error("Expected error for unknown mode, got nil")
+	}
+}
+
+func TestParseErrorBudget(t *testing.T) {
+	cases := map[string]ErrorBudget{
+		"":     {},
+		"100":  {MaxErrors: 100},
+		"0.5%": {MaxPercent: 0.5},
+		"10 %": {MaxPercent: 10},
+	}
+	for value, expected := range cases {
+		budget, err := ParseErrorBudget(value)
+		if err != nil || budget != expected {
+			t.Errorf("ParseErrorBudget(%q): expected %+v, got %+v (err: %v)", value, expected, budget, err)
+		}
+	}
+
+	for _, value := range []string{"0", "-3", "ten", "0%", "150%"} {
+		if _, err := ParseErrorBudget(value); err == nil {
+			t.Errorf("ParseErrorBudget(%q): expected error, got nil", value)
+		}
+	}
+}
+
+func TestErrorPolicy_FailMode(t *testing.T) {
+	cause := errors.New("bad record")
+	for _, policy := range []*ErrorPolicy{nil, NewErrorPolicy(ErrorModeFail, ErrorBudget{}, nil)} {
+		err := policy.Reject(&RecordError{Category: ErrorCategoryDecode, Err: cause})
+		if !errors.Is(err, cause) {
+			t.Errorf("Expected the record error back, got %v", err)
+		}
+		if counts := policy.Counts(); len(counts) != 0 {
+			t.Errorf("Expected no counts in fail mode, got %v", counts)
+		}
+	}
+}
+
+func TestStreamDecodesContext_SkipsBadRecords(t *testing.T) {
+	var deadLetter bytes.Buffer
+	policy := NewErrorPolicy(ErrorModeDeadLetter, ErrorBudget{}, &deadLetter)
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{})
+
+	options := StreamOptions{Format: FormatNDJSON, Errors: policy}
+	err := StreamDecodesContext(context.Background(), strings.NewReader(lenientNDJSON), options, aggregator.ProcessRecord)
+	if err != nil {
+		t.Fatalf("StreamDecodesContext failed: %v", err)
+	}
+
+	results := aggregator.GetResults()
+	if results.TotalClicks != 2 {
+		t.Errorf("Expected 2 clicks from the good records, got %d", results.TotalClicks)
+	}
+	expectedCounts := map[string]int{ErrorCategoryDecode: 2, ErrorCategoryTimestamp: 1}
+	if counts := policy.Counts(); !reflect.DeepEqual(counts, expectedCounts) {
+		t.Errorf("Expected counts %v, got %v", expectedCounts, counts)
+	}
+
+	entries := readDeadLetters(t, &deadLetter)
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 dead letters, got %+v", entries)
+	}
+	expectedLines := []int{2, 4, 5}
+	for i, entry := range entries {
+		if entry.Line != expectedLines[i] {
+			t.Errorf("Dead letter %d: expected line %d, got %d", i, expectedLines[i], entry.Line)
+		}
+		line := strings.Split(lenientNDJSON, "\n")[entry.Line-1]
+		if entry.Raw != line || entry.Offset == nil || !strings.HasPrefix(lenientNDJSON[*entry.Offset:], line) {
+			t.Errorf("Dead letter %d does not point at line %q: %+v", i, line, entry)
+		}
+	}
+	if entries[2].Category != ErrorCategoryTimestamp || !strings.Contains(entries[2].Reason, "yesterday") {
+		t.Errorf("Unexpected timestamp dead letter: %+v", entries[2])
+	}
+}
+
+func TestStreamDecodesContext_BadTimestampWithTimeRange(t *testing.T) {
+	// The default -year filter is a time range; bad timestamps must still reach the policy
+	config := AggregationConfig{FilterYear: 2021}
+
+	withoutDecodeErrors := `{"bitlink": "http://bit.ly/a", "timestamp": "2021-03-01T00:00:00Z"}
+{"bitlink": "http://bit.ly/c", "timestamp": "yesterday"}
+`
+	aggregator := NewAggregator(URLMapping{}, config)
+	options := StreamOptions{Format: FormatNDJSON, Errors: NewErrorPolicy(ErrorModeFail, ErrorBudget{}, nil)}
+	err := StreamDecodesContext(context.Background(), strings.NewReader(withoutDecodeErrors), options, aggregator.ProcessRecord)
+	var rejected *RecordError
+	if !errors.As(err, &rejected) || rejected.Category != ErrorCategoryTimestamp || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Expected -on-error=fail to stop at the timestamp on line 2, got %v", err)
+	}
+
+	var deadLetter bytes.Buffer
+	policy := NewErrorPolicy(ErrorModeDeadLetter, ErrorBudget{}, &deadLetter)
+	aggregator = NewAggregator(URLMapping{}, config)
+	options.Errors = policy
+	if err := StreamDecodesContext(context.Background(), strings.NewReader(withoutDecodeErrors), options, aggregator.ProcessRecord); err != nil {
+		t.Fatalf("StreamDecodesContext failed: %v", err)
+	}
+	if counts := policy.Counts(); counts[ErrorCategoryTimestamp] != 1 {
+		t.Errorf("Expected 1 timestamp rejection, got %v", counts)
+	}
+	if entries := readDeadLetters(t, &deadLetter); len(entries) != 1 || entries[0].Category != ErrorCategoryTimestamp {
+		t.Errorf("Expected a timestamp dead letter, got %+v", entries)
+	}
+	results := aggregator.GetResults()
+	if results.FilteredReasons.Unparseable != 1 || results.TotalClicks+results.FilteredOut != results.ProcessedRecords {
+		t.Errorf("Expected the skipped record to count as filtered (unparseable), got %+v", results)
+	}
+}
+
+func TestStreamDecodesFrom_JSONArrayOffsets(t *testing.T) {
+	testJSON := ` [{"bitlink": "http://bit.ly/a", "timestamp": "2021-03-01T00:00:00Z"},
+ {"bitlink": ["not", "a", "string"]},
+ {"bitlink": "http://bit.ly/b", "timestamp": "2021-03-01T00:00:00Z"}]`
+
+	var deadLetter bytes.Buffer
+	policy := NewErrorPolicy(ErrorModeDeadLetter, ErrorBudget{}, &deadLetter)
+	records := 0
+	options := StreamOptions{Errors: policy}
+	err := StreamDecodesContext(context.Background(), strings.NewReader(testJSON), options, func(record DecodeRecord) error {
+		records++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamDecodesContext failed: %v", err)
+	}
+	if records != 2 {
+		t.Errorf("Expected 2 good records, got %d", records)
+	}
+
+	entries := readDeadLetters(t, &deadLetter)
+	if len(entries) != 1 || entries[0].Record != 1 || entries[0].Offset == nil {
+		t.Fatalf("Unexpected dead letters: %+v", entries)
+	}
+	if raw := testJSON[*entries[0].Offset:]; !strings.HasPrefix(raw, entries[0].Raw) || entries[0].Raw != `{"bitlink": ["not", "a", "string"]}` {
+		t.Errorf("Offset %d does not point at the raw record %q", *entries[0].Offset, entries[0].Raw)
+	}
+}
+
+func TestStreamDecodesFrom_JSONArraySyntaxErrorIsFatal(t *testing.T) {
+	policy := NewErrorPolicy(ErrorModeSkip, ErrorBudget{}, nil)
+	options := StreamOptions{Errors: policy}
+	err := StreamDecodesContext(context.Background(), strings.NewReader(`[{"bitlink": }, {}]`), options, func(record DecodeRecord) error {
+		return nil
+	})
+	if err == nil {
+		t.Error("Expected a syntax error to stop a JSON array, got nil")
+	}
+}
+
+func TestErrorPolicy_CountBudget(t *testing.T) {
+	policy := NewErrorPolicy(ErrorModeSkip, ErrorBudget{MaxErrors: 2}, nil)
+	options := StreamOptions{Format: FormatNDJSON, Errors: policy}
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{})
+
+	err := StreamDecodesContext(context.Background(), strings.NewReader(lenientNDJSON), options, aggregator.ProcessRecord)
+	if !errors.Is(err, ErrErrorBudgetExceeded) {
+		t.Fatalf("Expected ErrErrorBudgetExceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "line 5") {
+		t.Errorf("Expected the third bad record (line 5) to exhaust the budget, got %v", err)
+	}
+}
+
+func TestErrorPolicy_PercentBudget(t *testing.T) {
+	cases := []struct {
+		maxPercent float64
+		exceeded   bool
+	}{
+		{60, false}, // 3 of the 5 records read are bad
+		{59, true},
+	}
+
+	for _, tc := range cases {
+		policy := NewErrorPolicy(ErrorModeSkip, ErrorBudget{MaxPercent: tc.maxPercent}, nil)
+		aggregator := NewAggregator(URLMapping{}, AggregationConfig{})
+		options := StreamOptions{Format: FormatNDJSON, Errors: policy}
+		if err := StreamDecodesContext(context.Background(), strings.NewReader(lenientNDJSON), options, aggregator.ProcessRecord); err != nil {
+			t.Fatalf("StreamDecodesContext failed: %v", err)
+		}
+
+		// The percentage is only known once the input has ended
+		err := policy.CheckBudget(aggregator.GetResults().ProcessedRecords)
+		if exceeded := errors.Is(err, ErrErrorBudgetExceeded); exceeded != tc.exceeded {
+			t.Errorf("Budget %v%%: expected exceeded=%v, got %v", tc.maxPercent, tc.exceeded, err)
+		}
+	}
+}
+
+func TestAggregator_ProcessParallel_SkipsBadRecords(t *testing.T) {
+	records := syntheticRecords(200)
+	records[17].Timestamp = "not a time"
+	records[150].Timestamp = ""
+
+	var deadLetter bytes.Buffer
+	policy := NewErrorPolicy(ErrorModeDeadLetter, ErrorBudget{}, &deadLetter)
+	aggregator := NewAggregator(syntheticMapping(), AggregationConfig{})
+	err := aggregator.ProcessParallel(sliceSource(records), ParallelConfig{Workers: 4, BatchSize: 8, Errors: policy})
+	if err != nil {
+		t.Fatalf("ProcessParallel failed: %v", err)
+	}
+
+	if processed := aggregator.GetResults().ProcessedRecords; processed != 200 {
+		t.Errorf("Expected 200 processed records, got %d", processed)
+	}
+	if clicks := aggregator.GetResults().TotalClicks; clicks != 198 {
+		t.Errorf("Expected 198 clicks, got %d", clicks)
+	}
+
+	seen := make(map[int]bool)
+	for _, entry := range readDeadLetters(t, &deadLetter) {
+		var record DecodeRecord
+		if err := json.Unmarshal([]byte(entry.Raw), &record); err != nil || record != records[entry.Record] {
+			t.Errorf("Dead letter raw %q does not match record %d", entry.Raw, entry.Record)
+		}
+		seen[entry.Record] = true
+	}
+	if len(seen) != 2 || !seen[17] || !seen[150] {
+		t.Errorf("Expected records 17 and 150 in the dead letters, got %v", seen)
+	}
+}
+
+func TestAggregator_ProcessParallel_KeepsRecordPositions(t *testing.T) {
+	var deadLetter bytes.Buffer
+	policy := NewErrorPolicy(ErrorModeDeadLetter, ErrorBudget{}, &deadLetter)
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{})
+
+	options := StreamOptions{Format: FormatNDJSON, Errors: policy}
+	source := func(callback func(DecodeRecord) error) error {
+		return StreamDecodesContext(context.Background(), strings.NewReader(lenientNDJSON), options, callback)
+	}
+	if err := aggregator.ProcessParallel(source, ParallelConfig{Workers: 2, BatchSize: 1, Errors: policy}); err != nil {
+		t.Fatalf("ProcessParallel failed: %v", err)
+	}
+
+	// The timestamp rejection happens on a worker, but points at its line like the decode errors
+	var timestamp *deadLetterEntry
+	entries := readDeadLetters(t, &deadLetter)
+	for i := range entries {
+		if entries[i].Category == ErrorCategoryTimestamp {
+			timestamp = &entries[i]
+		}
+	}
+	if timestamp == nil {
+		t.Fatalf("Expected a timestamp dead letter, got %+v", entries)
+	}
+	line := strings.Split(lenientNDJSON, "\n")[4]
+	if timestamp.Line != 5 || timestamp.Record != 3 || timestamp.Raw != line ||
+		timestamp.Offset == nil || !strings.HasPrefix(lenientNDJSON[*timestamp.Offset:], line) {
+		t.Errorf("Dead letter does not point at line 5 (%q): %+v", line, timestamp)
+	}
+}
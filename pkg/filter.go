@@ -0,0 +1,619 @@
+package pkg
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter is a compiled record filter expression, e.g.
+//
+//	referrer == "t.co" && year >= 2021 && bitlink ~ "bit.ly/2k"
+//
+// Comparisons (== != < <= > >=) work on string and numeric fields; ~ and !~ match a
+// regular expression; "in (...)" tests list membership and "in cidr(...)" tests
+// remote_ip against networks. Comparisons combine with && (and), || (or), ! (not)
+// and parentheses. A Filter is read-only once compiled and safe for concurrent use.
+type Filter struct {
+	source string
+	root   filterNode
+	fields map[string]bool
+}
+
+// filterFieldType is the type of a field's values
+type filterFieldType int
+
+const (
+	filterString filterFieldType = iota
+	filterNumber
+)
+
+// filterFields are the fields an expression can refer to, with their types: every
+// pivot Dimension under the same name, plus the raw record and click time fields
+var filterFields = func() map[string]filterFieldType {
+	fields := map[string]filterFieldType{
+		"user_agent": filterString,
+		"remote_ip":  filterString,
+		"year":       filterNumber, // Click time in the configured zone
+		"month":      filterNumber,
+		"day":        filterNumber,
+		"hour":       filterNumber,
+	}
+	for _, dimension := range Dimensions {
+		fields[string(dimension)] = filterString
+	}
+	return fields
+}()
+
+// FilterError is a compile error, located in the expression
+type FilterError struct {
+	Expr string
+	Pos  int // 0-based byte offset
+	Msg  string
+}
+
+// Error renders the message with the expression and a caret under the error
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid filter at column %d: %s\n  %s\n  %s^", e.Pos+1, e.Msg, e.Expr, strings.Repeat(" ", e.Pos))
+}
+
+// CompileFilter parses and type-checks an expression
+func CompileFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{expr: expr, tokens: tokens, fields: make(map[string]bool)}
+	if p.peek().kind == tokenEOF {
+		return nil, p.errorf(p.peek(), "empty expression")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := p.peek(); token.kind != tokenEOF {
+		return nil, p.errorf(token, "unexpected %s; expected && or ||", token)
+	}
+	return &Filter{source: expr, root: root, fields: p.fields}, nil
+}
+
+// String returns the expression as given
+func (f *Filter) String() string {
+	return f.source
+}
+
+// Fields returns the fields the expression refers to, sorted
+func (f *Filter) Fields() []string {
+	fields := make([]string, 0, len(f.fields))
+	for field := range f.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// NeedsGeoIP reports whether the expression refers to a geolocation field
+func (f *Filter) NeedsGeoIP() bool {
+	for field := range f.fields {
+		if Dimension(field).IsGeo() {
+			return true
+		}
+	}
+	return false
+}
+
+// filterEnv supplies field values for one click; derived values come from the
+// aggregator's caches
+type filterEnv struct {
+	aggregator *Aggregator
+	record     *DecodeRecord
+	at         time.Time // In the aggregator's zone
+}
+
+func (e *filterEnv) str(field string) string {
+	switch Dimension(field) {
+	case DimensionBitlink:
+		return e.record.Bitlink
+	case DimensionURL:
+		if longURL, found := e.aggregator.mapping.GetLongURL(e.record.Bitlink); found {
+			return longURL
+		}
+		return e.record.Bitlink
+	case DimensionDomain:
+		return bitlinkDomain(e.record.Bitlink)
+	case DimensionReferrer:
+		return e.record.Referrer
+	case DimensionReferrerHost:
+		return e.aggregator.normalizeReferrer(e.record.Referrer).Host
+	case DimensionChannel:
+		return e.aggregator.normalizeReferrer(e.record.Referrer).Channel.String()
+	case DimensionBrowser:
+		return e.aggregator.classifyUserAgent(e.record.UserAgent).Browser
+	case DimensionOS:
+		return e.aggregator.classifyUserAgent(e.record.UserAgent).OS
+	case DimensionDevice:
+		return e.aggregator.classifyUserAgent(e.record.UserAgent).Device.String()
+	case DimensionCountry:
+		return e.aggregator.locateIP(e.record.RemoteIP).Country
+	case DimensionRegion:
+		return e.aggregator.locateIP(e.record.RemoteIP).Region
+	case DimensionCity:
+		return e.aggregator.locateIP(e.record.RemoteIP).City
+	case DimensionDate:
+		return e.aggregator.config.Granularity.Label(e.at)
+	}
+	switch field {
+	case "user_agent":
+		return e.record.UserAgent
+	case "remote_ip":
+		return e.record.RemoteIP
+	}
+	return ""
+}
+
+func (e *filterEnv) num(field string) float64 {
+	switch field {
+	case "year":
+		return float64(e.at.Year())
+	case "month":
+		return float64(e.at.Month())
+	case "day":
+		return float64(e.at.Day())
+	case "hour":
+		return float64(e.at.Hour())
+	}
+	return 0
+}
+
+// filterNode is a node of the compiled expression tree
+type filterNode interface {
+	eval(env *filterEnv) bool
+}
+
+type filterAnd struct{ left, right filterNode }
+type filterOr struct{ left, right filterNode }
+type filterNot struct{ operand filterNode }
+
+func (n filterAnd) eval(env *filterEnv) bool { return n.left.eval(env) && n.right.eval(env) }
+func (n filterOr) eval(env *filterEnv) bool  { return n.left.eval(env) || n.right.eval(env) }
+func (n filterNot) eval(env *filterEnv) bool { return !n.operand.eval(env) }
+
+// filterCompareString compares a string field with a literal
+type filterCompareString struct {
+	field string
+	op    string
+	value string
+}
+
+func (n filterCompareString) eval(env *filterEnv) bool {
+	return compareOrdered(strings.Compare(env.str(n.field), n.value), n.op)
+}
+
+// filterCompareNumber compares a numeric field with a literal
+type filterCompareNumber struct {
+	field string
+	op    string
+	value float64
+}
+
+func (n filterCompareNumber) eval(env *filterEnv) bool {
+	got := env.num(n.field)
+	c := 0
+	if got < n.value {
+		c = -1
+	} else if got > n.value {
+		c = 1
+	}
+	return compareOrdered(c, n.op)
+}
+
+// compareOrdered applies a comparison operator to the result of a three-way compare
+func compareOrdered(c int, op string) bool {
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// filterMatch matches a string field against a regular expression
+type filterMatch struct {
+	field   string
+	pattern *regexp.Regexp
+}
+
+func (n filterMatch) eval(env *filterEnv) bool {
+	return n.pattern.MatchString(env.str(n.field))
+}
+
+// filterIn tests membership in a literal list
+type filterIn struct {
+	field   string
+	strings map[string]bool
+	numbers map[float64]bool
+}
+
+func (n filterIn) eval(env *filterEnv) bool {
+	if n.numbers != nil {
+		return n.numbers[env.num(n.field)]
+	}
+	return n.strings[env.str(n.field)]
+}
+
+// filterCIDR tests remote_ip against networks
+type filterCIDR struct {
+	networks []netip.Prefix
+}
+
+func (n filterCIDR) eval(env *filterEnv) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(env.record.RemoteIP))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, network := range n.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenKind classifies filter expression tokens
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp // Operators and punctuation
+)
+
+type filterToken struct {
+	kind  tokenKind
+	text  string // Source text; the unquoted value for strings
+	pos   int
+	value float64 // For numbers
+}
+
+func (t filterToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// filterOperators are matched longest first
+var filterOperators = []string{"==", "!=", "<=", ">=", "!~", "&&", "||", "<", ">", "~", "!", "(", ")", ","}
+
+// lexFilter splits an expression into tokens
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for pos := 0; pos < len(expr); {
+		c := expr[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+
+		case c == '"':
+			end := pos + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, &FilterError{Expr: expr, Pos: pos, Msg: "unterminated string"}
+			}
+			value, err := strconv.Unquote(expr[pos : end+1])
+			if err != nil {
+				return nil, &FilterError{Expr: expr, Pos: pos, Msg: "invalid string escape"}
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: value, pos: pos})
+			pos = end + 1
+
+		case c >= '0' && c <= '9' || c == '-' || c == '.':
+			end := pos + 1
+			for end < len(expr) && (expr[end] >= '0' && expr[end] <= '9' || expr[end] == '.') {
+				end++
+			}
+			value, err := strconv.ParseFloat(expr[pos:end], 64)
+			if err != nil {
+				return nil, &FilterError{Expr: expr, Pos: pos, Msg: fmt.Sprintf("invalid number %q", expr[pos:end])}
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: expr[pos:end], pos: pos, value: value})
+			pos = end
+
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			end := pos + 1
+			for end < len(expr) && (expr[end] == '_' || expr[end] >= 'a' && expr[end] <= 'z' || expr[end] >= 'A' && expr[end] <= 'Z' || expr[end] >= '0' && expr[end] <= '9') {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: expr[pos:end], pos: pos})
+			pos = end
+
+		default:
+			matched := ""
+			for _, op := range filterOperators {
+				if strings.HasPrefix(expr[pos:], op) {
+					matched = op
+					break
+				}
+			}
+			if matched == "" {
+				hint := ""
+				if c == '=' || c == '&' || c == '|' {
+					hint = fmt.Sprintf(" (did you mean %c%c?)", c, c)
+				} else if c == '\'' {
+					hint = " (strings use double quotes)"
+				}
+				return nil, &FilterError{Expr: expr, Pos: pos, Msg: fmt.Sprintf("unexpected character %q%s", c, hint)}
+			}
+			tokens = append(tokens, filterToken{kind: tokenOp, text: matched, pos: pos})
+			pos += len(matched)
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+// filterParser is a recursive-descent parser over the token list:
+//
+//	or         = and { ("||" | "or") and }
+//	and        = unary { ("&&" | "and") unary }
+//	unary      = ("!" | "not") unary | "(" or ")" | comparison
+//	comparison = field op literal | field ("~" | "!~") string
+//	           | field ["not"] "in" "(" literal { "," literal } ")"
+//	           | "remote_ip" ["not"] "in" "cidr" "(" string { "," string } ")"
+type filterParser struct {
+	expr   string
+	tokens []filterToken
+	next   int
+	fields map[string]bool
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.next]
+}
+
+func (p *filterParser) advance() filterToken {
+	token := p.tokens[p.next]
+	if token.kind != tokenEOF {
+		p.next++
+	}
+	return token
+}
+
+// accept consumes the next token if it is one of the operators or keywords given
+func (p *filterParser) accept(texts ...string) bool {
+	token := p.peek()
+	if token.kind != tokenOp && token.kind != tokenIdent {
+		return false
+	}
+	for _, text := range texts {
+		if token.kind == tokenOp && token.text == text || token.kind == tokenIdent && strings.EqualFold(token.text, text) {
+			p.next++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *filterParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf(p.peek(), "expected %q, found %s", text, p.peek())
+	}
+	return nil
+}
+
+func (p *filterParser) errorf(token filterToken, format string, args ...interface{}) error {
+	return &FilterError{Expr: p.expr, Pos: token.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&", "and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.accept("!", "not") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{operand}, nil
+	}
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	fieldToken := p.advance()
+	if fieldToken.kind != tokenIdent {
+		return nil, p.errorf(fieldToken, "expected a field name, found %s", fieldToken)
+	}
+	field := strings.ToLower(fieldToken.text)
+	fieldType, ok := filterFields[field]
+	if !ok {
+		return nil, p.errorf(fieldToken, "unknown field %q (expected one of %s)", fieldToken.text, strings.Join(filterFieldNames(), ", "))
+	}
+	p.fields[field] = true
+
+	opToken := p.peek()
+	negate := p.accept("not")
+	if p.accept("in") {
+		node, err := p.parseIn(field, fieldType, fieldToken)
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			return filterNot{node}, nil
+		}
+		return node, nil
+	}
+	if negate {
+		return nil, p.errorf(p.peek(), "expected \"in\" after \"not\", found %s", p.peek())
+	}
+
+	if opToken.kind != tokenOp {
+		return nil, p.errorf(opToken, "expected a comparison operator after %s, found %s", field, opToken)
+	}
+	p.advance()
+	switch opToken.text {
+	case "~", "!~":
+		if fieldType != filterString {
+			return nil, p.errorf(opToken, "%s is numeric; %s needs a string field", field, opToken.text)
+		}
+		literal := p.advance()
+		if literal.kind != tokenString {
+			return nil, p.errorf(literal, "%s needs a quoted regular expression, found %s", opToken.text, literal)
+		}
+		pattern, err := regexp.Compile(literal.text)
+		if err != nil {
+			return nil, p.errorf(literal, "invalid regular expression: %v", err)
+		}
+		var node filterNode = filterMatch{field: field, pattern: pattern}
+		if opToken.text == "!~" {
+			node = filterNot{node}
+		}
+		return node, nil
+
+	case "==", "!=", "<", "<=", ">", ">=":
+		literal := p.advance()
+		if err := p.checkLiteral(field, fieldType, literal); err != nil {
+			return nil, err
+		}
+		if fieldType == filterNumber {
+			return filterCompareNumber{field: field, op: opToken.text, value: literal.value}, nil
+		}
+		return filterCompareString{field: field, op: opToken.text, value: literal.text}, nil
+	}
+	return nil, p.errorf(opToken, "expected a comparison operator after %s, found %s", field, opToken)
+}
+
+// parseIn parses the list or cidr() after "in"
+func (p *filterParser) parseIn(field string, fieldType filterFieldType, fieldToken filterToken) (filterNode, error) {
+	if p.accept("cidr") {
+		if field != "remote_ip" {
+			return nil, p.errorf(fieldToken, "cidr() matches remote_ip only, not %s", field)
+		}
+		node := filterCIDR{}
+		err := p.parseList(func(literal filterToken) error {
+			if literal.kind != tokenString {
+				return p.errorf(literal, "cidr() needs quoted networks such as \"10.0.0.0/8\", found %s", literal)
+			}
+			network, err := netip.ParsePrefix(literal.text)
+			if err != nil {
+				return p.errorf(literal, "invalid network: %v", err)
+			}
+			node.networks = append(node.networks, network.Masked())
+			return nil
+		})
+		return node, err
+	}
+
+	node := filterIn{field: field, strings: make(map[string]bool)}
+	if fieldType == filterNumber {
+		node.strings, node.numbers = nil, make(map[float64]bool)
+	}
+	err := p.parseList(func(literal filterToken) error {
+		if err := p.checkLiteral(field, fieldType, literal); err != nil {
+			return err
+		}
+		if fieldType == filterNumber {
+			node.numbers[literal.value] = true
+		} else {
+			node.strings[literal.text] = true
+		}
+		return nil
+	})
+	return node, err
+}
+
+// parseList parses a parenthesised, comma-separated list of literals
+func (p *filterParser) parseList(add func(filterToken) error) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for {
+		if err := add(p.advance()); err != nil {
+			return err
+		}
+		if p.accept(")") {
+			return nil
+		}
+		if err := p.expect(","); err != nil {
+			return err
+		}
+	}
+}
+
+// checkLiteral checks that a literal has the field's type
+func (p *filterParser) checkLiteral(field string, fieldType filterFieldType, literal filterToken) error {
+	switch {
+	case fieldType == filterNumber && literal.kind != tokenNumber:
+		return p.errorf(literal, "%s is numeric; expected a number, found %s", field, literal)
+	case fieldType == filterString && literal.kind != tokenString:
+		return p.errorf(literal, "%s is a string; expected a quoted value, found %s", field, literal)
+	}
+	return nil
+}
+
+// filterFieldNames returns the field names, sorted
+func filterFieldNames() []string {
+	names := make([]string, 0, len(filterFields))
+	for name := range filterFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilter_Eval(t *testing.T) {
+	aggregator := NewAggregator(URLMapping{"http://bit.ly/2kkAHNs": "https://google.com/"}, AggregationConfig{Granularity: GranularityMonth})
+	record := DecodeRecord{
+		Bitlink:   "http://bit.ly/2kkAHNs",
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 14_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148 Safari/604.1",
+		Referrer:  "t.co",
+		RemoteIP:  "10.1.2.3",
+	}
+	env := &filterEnv{aggregator: aggregator, record: &record, at: time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC)}
+
+	cases := map[string]bool{
+		`referrer == "t.co" && year >= 2021 && bitlink ~ "bit.ly/2k"`: true,
+		`referrer == "t.co" && year > 2021`:                           false,
+		`referrer != "t.co" || month == 3`:                            true,
+		`!(referrer == "t.co")`:                                       false,
+		`not referrer == "t.co" or hour < 12`:                         false,
+		`(referrer == "x" || referrer == "t.co") and day <= 14`:       true,
+		`url == "https://google.com/" && domain == "bit.ly"`:          true,
+		`referrer_host == "twitter.com" && channel == "social"`:       true,
+		`device == "mobile" && os == "iOS" && browser == "Safari"`:    true,
+		`date == "2021-03"`:                                           true,
+		`referrer in ("twitter.com", "t.co")`:                         true,
+		`referrer not in ("twitter.com", "t.co")`:                     false,
+		`month in (1, 2, 3)`:                                          true,
+		`remote_ip in cidr("10.0.0.0/8", "2001:db8::/32")`:            true,
+		`remote_ip in cidr("192.168.0.0/16")`:                         false,
+		`remote_ip not in cidr("192.168.0.0/16")`:                     true,
+		`user_agent ~ "(?i)iphone"`:                                   true,
+		`user_agent !~ "Android"`:                                     true,
+		`bitlink < "http://bit.ly/3"`:                                 true,
+		`country == "unknown"`:                                        true, // No GeoIP database
+		`referrer == "t.co" || year == 1`:                             true,
+		`referrer == "x" && year == 2021 || referrer == "t.co"`:       true, // && binds tighter
+		`referrer == "x" && (year == 2021 || referrer == "t.co")`:     false,
+	}
+	for expr, expected := range cases {
+		filter, err := CompileFilter(expr)
+		if err != nil {
+			t.Errorf("CompileFilter(%s) failed: %v", expr, err)
+			continue
+		}
+		if got := filter.root.eval(env); got != expected {
+			t.Errorf("%s: expected %v, got %v", expr, expected, got)
+		}
+	}
+}
+
+func TestCompileFilter_Errors(t *testing.T) {
+	cases := []struct {
+		expr    string
+		pos     int
+		message string
+	}{
+		{``, 0, "empty expression"},
+		{`referrer = "t.co"`, 9, "did you mean ==?"},
+		{`refferer == "t.co"`, 0, `unknown field "refferer"`},
+		{`referrer == 't.co'`, 12, "double quotes"},
+		{`referrer == "t.co`, 12, "unterminated string"},
+		{`year >= "2021"`, 8, "year is numeric"},
+		{`referrer == 5`, 12, "referrer is a string"},
+		{`year ~ "20"`, 5, "needs a string field"},
+		{`bitlink ~ "bit.ly/(2k"`, 10, "invalid regular expression"},
+		{`referrer in cidr("10.0.0.0/8")`, 0, "cidr() matches remote_ip only"},
+		{`remote_ip in cidr("10.0.0.0/33")`, 18, "invalid network"},
+		{`referrer in ("a", "b"`, 21, `expected ","`},
+		{`(referrer == "a"`, 16, `expected ")"`},
+		{`referrer == "a" year == 2021`, 16, "expected && or ||"},
+		{`referrer == "a" &&`, 18, "expected a field name"},
+		{`referrer not "a"`, 13, `expected "in" after "not"`},
+	}
+	for _, c := range cases {
+		_, err := CompileFilter(c.expr)
+		var filterErr *FilterError
+		if !errors.As(err, &filterErr) {
+			t.Errorf("CompileFilter(%s): expected a FilterError, got %v", c.expr, err)
+			continue
+		}
+		if filterErr.Pos != c.pos || !strings.Contains(filterErr.Msg, c.message) {
+			t.Errorf("CompileFilter(%s): expected %q at %d, got %q at %d", c.expr, c.message, c.pos, filterErr.Msg, filterErr.Pos)
+		}
+	}
+}
+
+func TestFilterError_Error(t *testing.T) {
+	_, err := CompileFilter(`year >= "2021"`)
+	expected := "invalid filter at column 9: year is numeric; expected a number, found \"2021\"\n  year >= \"2021\"\n          ^"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error:\n%s\ngot:\n%v", expected, err)
+	}
+}
+
+func TestFilter_Fields(t *testing.T) {
+	filter, err := CompileFilter(`country == "US" && (referrer in ("t.co") || year >= 2021)`)
+	if err != nil {
+		t.Fatalf("CompileFilter failed: %v", err)
+	}
+	if got := strings.Join(filter.Fields(), ","); got != "country,referrer,year" {
+		t.Errorf("Expected fields country,referrer,year, got %s", got)
+	}
+	if !filter.NeedsGeoIP() {
+		t.Error("Expected a country filter to need GeoIP")
+	}
+}
+
+// Every -group-by dimension can be filtered on under the same name
+func TestFilter_DimensionFields(t *testing.T) {
+	for _, dimension := range Dimensions {
+		if _, err := CompileFilter(string(dimension) + ` == "x"`); err != nil {
+			t.Errorf("Expected %s to be a filter field: %v", dimension, err)
+		}
+	}
+}
+
+func TestAggregator_Where(t *testing.T) {
+	filter, err := CompileFilter(`referrer == "t.co" && year >= 2021`)
+	if err != nil {
+		t.Fatalf("CompileFilter failed: %v", err)
+	}
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{Where: filter})
+	for _, record := range []DecodeRecord{
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T00:00:00Z", Referrer: "t.co"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2020-12-31T23:59:59Z", Referrer: "t.co"},
+		{Bitlink: "http://bit.ly/b", Timestamp: "2021-06-01T00:00:00Z", Referrer: "direct"},
+	} {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	results := aggregator.GetResults()
+	if results.TotalClicks != 1 || results.FilteredOut != 2 || results.FilteredReasons.WhereFalse != 2 {
+		t.Errorf("Expected 1 click and 2 records filtered by the expression, got %d clicks, %+v", results.TotalClicks, results.FilteredReasons)
+	}
+	if len(results.UnknownBitlinks) != 1 {
+		t.Errorf("Expected filtered records not to be tracked as unknown bitlinks, got %v", results.UnknownBitlinks)
+	}
+	if results.FilterWhere != filter.String() {
+		t.Errorf("Expected FilterWhere %q, got %q", filter.String(), results.FilterWhere)
+	}
+}
@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoUnknown is the bucket for IPs the database has no (or only a partial) match for
+const GeoUnknown = "unknown"
+
+// GeoLocation is where a click's IP address is located
+type GeoLocation struct {
+	Country string // ISO 3166-1 code, e.g. "US"
+	Region  string // ISO 3166-2 code of the first subdivision, e.g. "US-CA"
+	City    string // English city name qualified by region, e.g. "San Francisco, US-CA"
+}
+
+// unknownLocation is used for unparseable or unmatched IPs
+var unknownLocation = GeoLocation{Country: GeoUnknown, Region: GeoUnknown, City: GeoUnknown}
+
+// GeoIPDB looks up IP addresses in a local MaxMind-format (MMDB) database
+// such as GeoLite2-City or GeoIP2-City. It is safe for concurrent use.
+type GeoIPDB struct {
+	reader *maxminddb.Reader
+}
+
+// geoIPRecord is the subset of the GeoIP2 City schema that is aggregated
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// OpenGeoIPDB opens an MMDB file for lookups
+func OpenGeoIPDB(filename string) (*GeoIPDB, error) {
+	reader, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening GeoIP database: %w", err)
+	}
+	return &GeoIPDB{reader: reader}, nil
+}
+
+// Close releases the database
+func (db *GeoIPDB) Close() error {
+	return db.reader.Close()
+}
+
+// Lookup locates an IP address; parts the database does not know are GeoUnknown
+func (db *GeoIPDB) Lookup(ip string) GeoLocation {
+	addr := net.ParseIP(strings.TrimSpace(ip))
+	if addr == nil {
+		return unknownLocation
+	}
+
+	var record geoIPRecord
+	if err := db.reader.Lookup(addr, &record); err != nil || record.Country.ISOCode == "" {
+		return unknownLocation
+	}
+
+	location := unknownLocation
+	location.Country = record.Country.ISOCode
+	if len(record.Subdivisions) > 0 && record.Subdivisions[0].ISOCode != "" {
+		location.Region = location.Country + "-" + record.Subdivisions[0].ISOCode
+	}
+	if city := record.City.Names["en"]; city != "" {
+		// Qualify the name, since many cities share one (Portland, US-OR and Portland, US-ME)
+		location.City = city + ", " + location.Region
+		if location.Region == GeoUnknown {
+			location.City = city + ", " + location.Country
+		}
+	}
+	return location
+}
+
+// GeoFilter restricts aggregation to clicks from the listed places
+// Each non-empty list must contain the click's value; matching is case-insensitive.
+// Cities match either the bare name ("London") or the qualified label ("London, GB-ENG").
+type GeoFilter struct {
+	Countries []string
+	Regions   []string
+	Cities    []string
+}
+
+// IsZero reports whether the filter accepts every location
+func (f GeoFilter) IsZero() bool {
+	return len(f.Countries) == 0 && len(f.Regions) == 0 && len(f.Cities) == 0
+}
+
+// Matches reports whether a location passes the filter
+func (f GeoFilter) Matches(location GeoLocation) bool {
+	if len(f.Countries) > 0 && !containsFold(f.Countries, location.Country) {
+		return false
+	}
+	if len(f.Regions) > 0 && !containsFold(f.Regions, location.Region) {
+		return false
+	}
+	if len(f.Cities) > 0 {
+		name, _, _ := strings.Cut(location.City, ", ")
+		if !containsFold(f.Cities, location.City) && !containsFold(f.Cities, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the filter for the summary, e.g. "country=US,GB region=US-CA"
+func (f GeoFilter) String() string {
+	var parts []string
+	if len(f.Countries) > 0 {
+		parts = append(parts, "country="+strings.Join(f.Countries, ","))
+	}
+	if len(f.Regions) > 0 {
+		parts = append(parts, "region="+strings.Join(f.Regions, ","))
+	}
+	if len(f.Cities) > 0 {
+		parts = append(parts, "city="+strings.Join(f.Cities, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// containsFold reports whether values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
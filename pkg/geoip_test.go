@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+// geoIPFixture is a GeoIP2-City-shaped test database; see testdata/geoip/generate.go
+const geoIPFixture = "testdata/geoip-test.mmdb"
+
+func openGeoIPFixture(t *testing.T) *GeoIPDB {
+	t.Helper()
+
+	db, err := OpenGeoIPDB(geoIPFixture)
+	if err != nil {
+		t.Fatalf("OpenGeoIPDB failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestGeoIPDB_Lookup(t *testing.T) {
+	db := openGeoIPFixture(t)
+
+	cases := map[string]GeoLocation{
+		"4.14.247.63":      {Country: "US", Region: "US-CA", City: "San Francisco, US-CA"},
+		"65.110.33.199":    {Country: "US", Region: "US-NY", City: "New York, US-NY"},
+		"81.2.69.160":      {Country: "GB", Region: "GB-ENG", City: "London, GB-ENG"},
+		"2001:db8::1":      {Country: "JP", Region: "JP-13", City: "Tokyo, JP-13"},
+		"::ffff:2.203.1.1": {Country: "DE", Region: "DE-BE", City: "Berlin, DE-BE"},
+		"1.130.0.1":        {Country: "AU", Region: GeoUnknown, City: GeoUnknown}, // Country-level match
+		"2.57.169.255":     unknownLocation,                                       // Not in the database
+		"not-an-ip":        unknownLocation,
+		"":                 unknownLocation,
+	}
+	for ip, expected := range cases {
+		if got := db.Lookup(ip); got != expected {
+			t.Errorf("Lookup(%q): expected %+v, got %+v", ip, expected, got)
+		}
+	}
+}
+
+func TestOpenGeoIPDB_Errors(t *testing.T) {
+	if _, err := OpenGeoIPDB("testdata/missing.mmdb"); err == nil {
+		t.Error("Expected error for missing database, got nil")
+	}
+	if _, err := OpenGeoIPDB(writeTempFile(t, "geoip-*.mmdb", "not an mmdb file")); err == nil {
+		t.Error("Expected error for invalid database, got nil")
+	}
+}
+
+func TestGeoFilter_Matches(t *testing.T) {
+	london := GeoLocation{Country: "GB", Region: "GB-ENG", City: "London, GB-ENG"}
+
+	cases := []struct {
+		filter   GeoFilter
+		expected bool
+	}{
+		{GeoFilter{}, true},
+		{GeoFilter{Countries: []string{"us", "gb"}}, true},
+		{GeoFilter{Countries: []string{"US"}}, false},
+		{GeoFilter{Regions: []string{"GB-ENG"}}, true},
+		{GeoFilter{Cities: []string{"london"}}, true},
+		{GeoFilter{Cities: []string{"London, GB-ENG"}}, true},
+		{GeoFilter{Cities: []string{"London, CA-ON"}}, false},
+		{GeoFilter{Countries: []string{"GB"}, Regions: []string{"GB-SCT"}}, false}, // Every list must match
+		{GeoFilter{Countries: []string{GeoUnknown}}, false},
+	}
+	for _, tc := range cases {
+		if got := tc.filter.Matches(london); got != tc.expected {
+			t.Errorf("%+v.Matches(London): expected %v, got %v", tc.filter, tc.expected, got)
+		}
+	}
+}
+
+func TestAggregator_GeoIP(t *testing.T) {
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", RemoteIP: "4.14.247.63"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", RemoteIP: "4.14.244.85"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", RemoteIP: "65.110.33.199"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", RemoteIP: "2.203.85.0"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", RemoteIP: "2.57.169.255"},
+	}
+
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{GeoIP: openGeoIPFixture(t)})
+	for _, record := range records {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	results := aggregator.GetResults()
+	expectedCountries := map[string]int{"US": 3, "DE": 1, GeoUnknown: 1}
+	if !reflect.DeepEqual(results.ClicksByCountry, expectedCountries) {
+		t.Errorf("Expected ClicksByCountry %v, got %v", expectedCountries, results.ClicksByCountry)
+	}
+	expectedRegions := map[string]int{"US-CA": 2, "US-NY": 1, "DE-BE": 1, GeoUnknown: 1}
+	if !reflect.DeepEqual(results.ClicksByRegion, expectedRegions) {
+		t.Errorf("Expected ClicksByRegion %v, got %v", expectedRegions, results.ClicksByRegion)
+	}
+	if results.ClicksByCity["San Francisco, US-CA"] != 2 || results.ClicksByCity[GeoUnknown] != 1 {
+		t.Errorf("Unexpected ClicksByCity: %v", results.ClicksByCity)
+	}
+}
+
+func TestAggregator_GeoFilter(t *testing.T) {
+	config := AggregationConfig{
+		FilterYear: 2021,
+		GeoIP:      openGeoIPFixture(t),
+		GeoFilter:  GeoFilter{Countries: []string{"US"}},
+	}
+	aggregator := NewAggregator(URLMapping{}, config)
+
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", RemoteIP: "4.14.247.63"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", RemoteIP: "2.203.85.0"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2021-03-01T00:00:00Z", RemoteIP: "2.57.169.255"},
+		{Bitlink: "http://bit.ly/x", Timestamp: "2020-03-01T00:00:00Z", RemoteIP: "4.14.247.63"}, // Out of range first
+	}
+	for _, record := range records {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	results := aggregator.GetResults()
+	if results.TotalClicks != 1 || results.ClicksByCountry["US"] != 1 {
+		t.Errorf("Expected 1 click from the US, got %d (%v)", results.TotalClicks, results.ClicksByCountry)
+	}
+	if results.FilteredOut != 3 || results.FilteredReasons.GeoMismatch != 2 || results.FilteredReasons.BeforeRange != 1 {
+		t.Errorf("Unexpected filter breakdown: %d %+v", results.FilteredOut, results.FilteredReasons)
+	}
+}
@@ -0,0 +1,221 @@
+package pkg
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+//go:embed templates/report.html.tmpl
+var reportTemplateSource string
+
+// reportTemplate renders the self-contained HTML report; styles, charts and the
+// table sorting script are all inline, so the file works offline
+var reportTemplate = template.Must(template.New("report").Parse(reportTemplateSource))
+
+// Chart geometry, in SVG user units
+const (
+	chartWidth      = 720
+	barChartTop     = 10
+	barRowHeight    = 22
+	barHeight       = 16
+	barLabelWidth   = 260 // Right edge of the bar labels
+	barCountWidth   = 60  // Room after the longest bar for its count
+	barLabelRunes   = 40  // Longer labels are shortened; the tooltip has the full text
+	barChartEntries = 10
+	lineChartHeight = 260
+	lineChartLeft   = 50
+	lineChartRight  = chartWidth - 20
+	lineChartTop    = 20
+	lineChartBottom = lineChartHeight - 40
+)
+
+// htmlReport is the data behind reportTemplate
+type htmlReport struct {
+	Title  string
+	Meta   []reportField
+	Bars   []htmlBarChart
+	Line   htmlLineChart
+	Tables []htmlTable
+}
+
+// htmlBarChart is a horizontal bar chart of the most clicked keys
+type htmlBarChart struct {
+	Title  string
+	Width  int
+	Height int
+	Bars   []htmlBar
+}
+
+// htmlBar is one bar with its label and count positions
+type htmlBar struct {
+	Label      string
+	ShortLabel string
+	Clicks     int
+	LabelX     int
+	CountX     int
+	X          int
+	Y          int
+	TextY      int
+	Width      int
+	Height     int
+}
+
+// htmlLineChart is the clicks over time chart
+type htmlLineChart struct {
+	Title                    string
+	Width, Height            int
+	Left, Right, Top, Bottom int
+	YLabelX, XLabelY         int
+	Max                      int
+	First, Last              string // Labels of the first and last bucket
+	Points                   string // SVG polyline points
+}
+
+// htmlTable is a sortable table of one of ResultTables
+type htmlTable struct {
+	Title   string
+	Open    bool
+	Columns []htmlCell
+	Rows    [][]htmlCell
+}
+
+// htmlCell is a table heading or value; numeric cells are right-aligned and sort as numbers
+type htmlCell struct {
+	Name    string
+	Text    string
+	Numeric bool
+}
+
+// WriteHTML writes the current results as a self-contained HTML page
+func (a *Aggregator) WriteHTML(w io.Writer) error {
+	return WriteHTML(w, a.GetResults())
+}
+
+// WriteHTML writes the results as a single self-contained HTML page: a metadata
+// header, bar charts of the top URLs and referrers, a line chart of clicks over
+// time and a sortable table per aggregation
+func WriteHTML(w io.Writer, results AggregationResults) error {
+	if err := reportTemplate.Execute(w, newHTMLReport(&results)); err != nil {
+		return fmt.Errorf("error writing HTML report: %w", err)
+	}
+	return nil
+}
+
+// newHTMLReport lays out the report
+func newHTMLReport(r *AggregationResults) htmlReport {
+	report := htmlReport{
+		Title: "Click Analytics Report",
+		Meta:  reportFields(r),
+		Bars: []htmlBarChart{
+			barChart("Top URLs", r.ClicksByURL),
+			barChart("Top Referrers", r.ClicksByReferrer),
+		},
+	}
+
+	series, err := r.TimeSeries()
+	if err != nil {
+		series = nil // Labels that do not parse leave the chart empty, as in the summary
+	}
+	report.Line = lineChart(fmt.Sprintf("Clicks over Time (%s)", r.Granularity), series)
+
+	for i, table := range r.ResultTables() {
+		report.Tables = append(report.Tables, tableHTML(table, i == 0))
+	}
+	return report
+}
+
+// barChart charts the most clicked keys, most clicked first whatever the sort order
+func barChart(title string, counts map[string]int) htmlBarChart {
+	items := make([]KeyValue, 0, len(counts))
+	for key, value := range counts {
+		items = append(items, KeyValue{Key: key, Value: value})
+	}
+	sortKeyValues(items, true)
+	items = limitPivot(items, barChartEntries)
+
+	chart := htmlBarChart{Title: title, Width: chartWidth, Height: 2*barChartTop + len(items)*barRowHeight}
+	maxWidth := chartWidth - barLabelWidth - barCountWidth
+	for i, item := range items {
+		width := 0
+		if items[0].Value > 0 {
+			width = item.Value * maxWidth / items[0].Value
+		}
+		y := barChartTop + i*barRowHeight
+		chart.Bars = append(chart.Bars, htmlBar{
+			Label:      tableKey(item.Key),
+			ShortLabel: shortenLabel(tableKey(item.Key), barLabelRunes),
+			Clicks:     item.Value,
+			LabelX:     barLabelWidth - 8,
+			X:          barLabelWidth,
+			Y:          y,
+			Width:      width,
+			Height:     barHeight,
+			CountX:     barLabelWidth + width + 6,
+			TextY:      y + barHeight - 4,
+		})
+	}
+	return chart
+}
+
+// lineChart plots clicks per bucket in order
+func lineChart(title string, series []TimeBucket) htmlLineChart {
+	chart := htmlLineChart{
+		Title: title, Width: chartWidth, Height: lineChartHeight,
+		Left: lineChartLeft, Right: lineChartRight, Top: lineChartTop, Bottom: lineChartBottom,
+		YLabelX: lineChartLeft - 6, XLabelY: lineChartBottom + 18,
+	}
+	if len(series) == 0 {
+		return chart
+	}
+
+	for _, bucket := range series {
+		chart.Max = max(chart.Max, bucket.Clicks)
+	}
+	chart.First, chart.Last = series[0].Label, series[len(series)-1].Label
+
+	points := make([]string, len(series))
+	for i, bucket := range series {
+		x := float64(lineChartLeft+lineChartRight) / 2 // A single bucket is centred
+		if len(series) > 1 {
+			x = lineChartLeft + float64(i)*float64(lineChartRight-lineChartLeft)/float64(len(series)-1)
+		}
+		y := float64(lineChartBottom)
+		if chart.Max > 0 {
+			y -= float64(bucket.Clicks) * float64(lineChartBottom-lineChartTop) / float64(chart.Max)
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	chart.Points = strings.Join(points, " ")
+	return chart
+}
+
+// tableHTML prepares a result table for the template; count columns are numeric,
+// and cells beyond the header in a ragged row are rendered as text
+func tableHTML(table ResultTable, open bool) htmlTable {
+	result := htmlTable{Title: tableTitle(table.Name), Open: open}
+	numeric := make([]bool, len(table.Header))
+	for i, name := range table.Header {
+		numeric[i] = isCountColumn(name)
+		result.Columns = append(result.Columns, htmlCell{Name: name, Numeric: numeric[i]})
+	}
+	for _, row := range table.Rows {
+		cells := make([]htmlCell, len(row))
+		for i, value := range row {
+			cells[i] = htmlCell{Text: value, Numeric: i < len(numeric) && numeric[i]}
+		}
+		result.Rows = append(result.Rows, cells)
+	}
+	return result
+}
+
+// shortenLabel truncates labels longer than limit runes with an ellipsis
+func shortenLabel(label string, limit int) string {
+	runes := []rune(label)
+	if len(runes) <= limit {
+		return label
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAggregator_WriteHTML(t *testing.T) {
+	mapping := URLMapping{"http://bit.ly/a": "https://a.com/?q=1&r=2"}
+	aggregator := NewAggregator(mapping, AggregationConfig{SortDesc: true})
+	for _, record := range []DecodeRecord{
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T00:00:00Z", Referrer: "<script>alert(1)</script>"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-03T00:00:00Z", Referrer: "direct"},
+	} {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := aggregator.WriteHTML(&buf); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+	page := buf.String()
+
+	for _, expected := range []string{
+		"<h2>Top URLs</h2>", "<h2>Top Referrers</h2>", `<rect class="bar"`,
+		`<polyline class="line" points="50.0,20.0 375.0,220.0 700.0,20.0">`,
+		`<table class="sortable">`, `<th class="number">clicks</th>`,
+		"&lt;script&gt;alert(1)&lt;/script&gt;", "https://a.com/?q=1&amp;r=2",
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected the report to contain %q", expected)
+		}
+	}
+	// Only the inline sorting script, and nothing fetched from the network
+	if strings.Count(page, "<script") != 1 || strings.Contains(page, "<link") || strings.Contains(page, "src=") {
+		t.Error("Expected a self-contained report with no injected or external resources")
+	}
+}
+
+func TestAggregator_WriteHTML_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewAggregator(URLMapping{}, AggregationConfig{}).WriteHTML(&buf); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+	if strings.Count(buf.String(), "<p>No clicks.</p>") != 3 {
+		t.Error("Expected every chart to report no clicks")
+	}
+}
+
+func TestTableHTML_RaggedRows(t *testing.T) {
+	table := ResultTable{Name: "clicks_by_url", Header: []string{"url", "clicks"}, Rows: [][]string{{"a", "1", "extra"}, {"b"}}}
+
+	result := tableHTML(table, false)
+	if len(result.Rows) != 2 || len(result.Rows[0]) != 3 || result.Rows[0][2].Numeric || !result.Rows[0][1].Numeric {
+		t.Errorf("Unexpected cells for ragged rows: %+v", result.Rows)
+	}
+}
+
+func TestShortenLabel(t *testing.T) {
+	if got := shortenLabel("https://é.com/", 5); got != "http…" {
+		t.Errorf("Unexpected shortened label %q", got)
+	}
+	if got := shortenLabel("abc", 5); got != "abc" {
+		t.Errorf("Expected short labels unchanged, got %q", got)
+	}
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog precision limits and default
+// Precision p uses 2^p one-byte registers and has a standard error of about 1.04/sqrt(2^p).
+const (
+	MinHLLPrecision     = 4
+	MaxHLLPrecision     = 18
+	DefaultHLLPrecision = 14 // 16 KiB per sketch, about 0.81% standard error
+)
+
+// HyperLogLog is a mergeable cardinality sketch over 64-bit hashes
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// NewHyperLogLog creates an empty sketch with the given precision
+func NewHyperLogLog(precision uint8) (*HyperLogLog, error) {
+	if precision < MinHLLPrecision || precision > MaxHLLPrecision {
+		return nil, fmt.Errorf("invalid HyperLogLog precision %d (expected %d to %d)", precision, MinHLLPrecision, MaxHLLPrecision)
+	}
+	return newSketch(precision), nil
+}
+
+// newSketch creates an empty sketch with an already validated precision
+func newSketch(precision uint8) *HyperLogLog {
+	return &HyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}
+}
+
+// Precision returns the sketch's precision
+func (h *HyperLogLog) Precision() uint8 {
+	return h.precision
+}
+
+// Add records a hashed item
+// The first p bits pick a register; the register keeps the longest run of leading
+// zeros (plus one) seen in the remaining bits.
+func (h *HyperLogLog) Add(hash uint64) {
+	index := hash >> (64 - h.precision)
+	remaining := hash<<h.precision | 1<<(h.precision-1) // Guard bit bounds the run length
+	if rank := uint8(bits.LeadingZeros64(remaining)) + 1; rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// Merge folds another sketch of the same precision into this one
+// The result is the sketch of the union of both inputs.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if other.precision != h.precision {
+		return fmt.Errorf("cannot merge HyperLogLog sketches with precision %d and %d", h.precision, other.precision)
+	}
+	h.mergeRegisters(other)
+	return nil
+}
+
+// mergeRegisters keeps the larger of each pair of registers; precisions must match
+func (h *HyperLogLog) mergeRegisters(other *HyperLogLog) {
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+}
+
+// Estimate returns the approximate number of distinct items added
+// Small cardinalities use linear counting, following Flajolet et al.; 64-bit
+// hashes make the large-range correction unnecessary.
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := hllAlpha(len(h.registers)) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// StandardError returns the sketch's relative standard error
+func (h *HyperLogLog) StandardError() float64 {
+	return hllStandardError(h.precision)
+}
+
+// hllStandardError is the relative standard error of a sketch with the given precision
+func hllStandardError(precision uint8) float64 {
+	return 1.04 / math.Sqrt(float64(uint64(1)<<precision))
+}
+
+// hllAlpha is the bias correction constant for m registers
+func hllAlpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// hashString hashes s to 64 well-mixed bits
+// FNV-1a is fast and stable across runs, but its high bits mix poorly for short
+// inputs, so the result is passed through the MurmurHash3 finalizer.
+func hashString(s string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(s))
+	return mix64(hasher.Sum64())
+}
+
+// mix64 is the MurmurHash3 64-bit finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,186 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHyperLogLog_Estimate(t *testing.T) {
+	for _, precision := range []uint8{10, 14} {
+		for _, n := range []int{0, 100, 5000, 200000} {
+			sketch, err := NewHyperLogLog(precision)
+			if err != nil {
+				t.Fatalf("NewHyperLogLog(%d) failed: %v", precision, err)
+			}
+			for i := 0; i < n; i++ {
+				hash := hashString(fmt.Sprintf("10.%d.%d.%d", i>>16, i>>8&255, i&255))
+				sketch.Add(hash)
+				sketch.Add(hash) // Repeats must not count
+			}
+
+			// Hashing is deterministic, so allow three standard errors
+			estimate := float64(sketch.Estimate())
+			if tolerance := 3 * sketch.StandardError() * float64(n); math.Abs(estimate-float64(n)) > tolerance {
+				t.Errorf("p=%d n=%d: estimate %.0f outside ±%.0f", precision, n, estimate, tolerance)
+			}
+		}
+	}
+}
+
+func TestHyperLogLog_Merge(t *testing.T) {
+	first, _ := NewHyperLogLog(12)
+	second, _ := NewHyperLogLog(12)
+	union, _ := NewHyperLogLog(12)
+	for i := 0; i < 30000; i++ {
+		hash := hashString(fmt.Sprint(i))
+		if i < 20000 {
+			first.Add(hash)
+		}
+		if i >= 10000 {
+			second.Add(hash)
+		}
+		union.Add(hash)
+	}
+
+	if err := first.Merge(second); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if first.Estimate() != union.Estimate() {
+		t.Errorf("Expected merged estimate %d to equal the union's, got %d", union.Estimate(), first.Estimate())
+	}
+
+	other, _ := NewHyperLogLog(10)
+	if err := first.Merge(other); err == nil {
+		t.Error("Expected error merging sketches of different precision, got nil")
+	}
+}
+
+func TestNewHyperLogLog_InvalidPrecision(t *testing.T) {
+	for _, precision := range []uint8{0, MinHLLPrecision - 1, MaxHLLPrecision + 1} {
+		if _, err := NewHyperLogLog(precision); err == nil {
+			t.Errorf("Expected error for precision %d, got nil", precision)
+		}
+	}
+}
+
+func TestParseVisitorKey(t *testing.T) {
+	cases := map[string]VisitorKey{"": VisitorKeyIP, "ip": VisitorKeyIP, "IP+UA": VisitorKeyIPUserAgent}
+	for value, expected := range cases {
+		if got, err := ParseVisitorKey(value); err != nil || got != expected {
+			t.Errorf("ParseVisitorKey(%q): expected %v, got %v (err %v)", value, expected, got, err)
+		}
+	}
+	if _, err := ParseVisitorKey("cookie"); err == nil {
+		t.Error("Expected error for unknown key, got nil")
+	}
+}
+
+func TestAggregator_UniqueVisitorsExact(t *testing.T) {
+	mapping := URLMapping{"http://bit.ly/a": "https://a.com/", "http://bit.ly/b": "https://b.com/"}
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T10:00:00Z", Referrer: "direct", RemoteIP: "1.1.1.1", UserAgent: "Chrome"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T11:00:00Z", Referrer: "direct", RemoteIP: "1.1.1.1", UserAgent: "Firefox"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-02T10:00:00Z", Referrer: "t.co", RemoteIP: "2.2.2.2", UserAgent: "Chrome"},
+		{Bitlink: "http://bit.ly/b", Timestamp: "2021-01-02T10:00:00Z", Referrer: "t.co", RemoteIP: "1.1.1.1", UserAgent: "Chrome"},
+		{Bitlink: "http://bit.ly/b", Timestamp: "2021-01-02T12:00:00Z", Referrer: "t.co", RemoteIP: "", UserAgent: "Chrome"}, // No IP: not a visitor
+	}
+
+	cases := []struct {
+		key        VisitorKey
+		total      int
+		byURL      map[string]int
+		byReferrer map[string]int
+		byDate     map[string]int
+	}{
+		{
+			key:        VisitorKeyIP,
+			total:      2,
+			byURL:      map[string]int{"https://a.com/": 2, "https://b.com/": 1},
+			byReferrer: map[string]int{"direct": 1, "t.co": 2},
+			byDate:     map[string]int{"2021-01-01": 1, "2021-01-02": 2},
+		},
+		{
+			key:        VisitorKeyIPUserAgent,
+			total:      3,
+			byURL:      map[string]int{"https://a.com/": 3, "https://b.com/": 1},
+			byReferrer: map[string]int{"direct": 2, "t.co": 2},
+			byDate:     map[string]int{"2021-01-01": 2, "2021-01-02": 2},
+		},
+	}
+	for _, c := range cases {
+		aggregator := NewAggregator(mapping, AggregationConfig{Visitors: &VisitorConfig{Key: c.key, Exact: true}})
+		for _, record := range records {
+			if err := aggregator.ProcessRecord(record); err != nil {
+				t.Fatalf("ProcessRecord failed: %v", err)
+			}
+		}
+
+		results := aggregator.GetResults()
+		if results.UniqueVisitors != c.total {
+			t.Errorf("key %s: expected %d unique visitors, got %d", c.key, c.total, results.UniqueVisitors)
+		}
+		if !reflect.DeepEqual(results.UniqueVisitorsByURL, c.byURL) {
+			t.Errorf("key %s: expected by URL %v, got %v", c.key, c.byURL, results.UniqueVisitorsByURL)
+		}
+		if !reflect.DeepEqual(results.UniqueVisitorsByReferrer, c.byReferrer) {
+			t.Errorf("key %s: expected by referrer %v, got %v", c.key, c.byReferrer, results.UniqueVisitorsByReferrer)
+		}
+		if !reflect.DeepEqual(results.UniqueVisitorsByDate, c.byDate) {
+			t.Errorf("key %s: expected by date %v, got %v", c.key, c.byDate, results.UniqueVisitorsByDate)
+		}
+	}
+}
+
+func TestAggregator_UniqueVisitorsEstimateMatchesExact(t *testing.T) {
+	records := syntheticRecords(50000)
+	exact := NewAggregator(syntheticMapping(), AggregationConfig{Visitors: &VisitorConfig{Exact: true}})
+	estimated := NewAggregator(syntheticMapping(), AggregationConfig{Visitors: &VisitorConfig{Precision: 12}})
+	for _, record := range records {
+		exact.ProcessRecord(record)
+		estimated.ProcessRecord(record)
+	}
+
+	exactResults, estimatedResults := exact.GetResults(), estimated.GetResults()
+	if exactResults.UniqueVisitors < 10000 {
+		t.Fatalf("Expected a large visitor set for the comparison, got %d", exactResults.UniqueVisitors)
+	}
+	tolerance := 3 * hllStandardError(12)
+	check := func(name string, expected, got int) {
+		if math.Abs(float64(got-expected)) > tolerance*float64(expected) {
+			t.Errorf("%s: estimate %d not within %.1f%% of exact %d", name, got, tolerance*100, expected)
+		}
+	}
+	check("total", exactResults.UniqueVisitors, estimatedResults.UniqueVisitors)
+	for referrer, expected := range exactResults.UniqueVisitorsByReferrer {
+		check("referrer "+referrer, expected, estimatedResults.UniqueVisitorsByReferrer[referrer])
+	}
+	for date, expected := range exactResults.UniqueVisitorsByDate {
+		check("date "+date, expected, estimatedResults.UniqueVisitorsByDate[date])
+	}
+	for url, expected := range exactResults.UniqueVisitorsByURL {
+		check("url "+url, expected, estimatedResults.UniqueVisitorsByURL[url])
+	}
+}
+
+func TestAggregator_UniqueVisitorsParallelMatchesSerial(t *testing.T) {
+	records := syntheticRecords(20011)
+	for _, visitors := range []VisitorConfig{{Exact: true}, {Precision: 10}} {
+		config := AggregationConfig{Visitors: &visitors}
+
+		serial := NewAggregator(syntheticMapping(), config)
+		for _, record := range records {
+			serial.ProcessRecord(record)
+		}
+		parallel := NewAggregator(syntheticMapping(), config)
+		if err := parallel.ProcessParallel(sliceSource(records), ParallelConfig{Workers: 4, BatchSize: 100}); err != nil {
+			t.Fatalf("ProcessParallel failed: %v", err)
+		}
+
+		// Register-wise maxima do not depend on the order sketches are merged in
+		if !reflect.DeepEqual(serial.GetResults(), parallel.GetResults()) {
+			t.Errorf("Parallel visitor counts (%+v) differ from serial counts", visitors)
+		}
+	}
+}
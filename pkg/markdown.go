@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// markdownEscaper keeps values from breaking out of a table cell or being read as
+// HTML; newlines would end the row
+var markdownEscaper = strings.NewReplacer("|", `\|`, "<", "&lt;", "\r\n", " ", "\n", " ", "\r", " ")
+
+// WriteMarkdown writes the results as a GitHub-flavoured Markdown document: the
+// report header as a list, then a table per aggregation with counts right-aligned
+func WriteMarkdown(w io.Writer, results AggregationResults) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "# Click Analytics Report\n\n")
+	for _, field := range reportFields(&results) {
+		fmt.Fprintf(b, "- **%s:** %s\n", field.Label, markdownEscaper.Replace(field.Value))
+	}
+
+	for _, table := range results.ResultTables() {
+		fmt.Fprintf(b, "\n## %s\n\n", tableTitle(table.Name))
+		if len(table.Rows) == 0 {
+			fmt.Fprintf(b, "No rows.\n")
+			continue
+		}
+		writeMarkdownRow(b, table.Header)
+		alignments := make([]string, len(table.Header))
+		for i, name := range table.Header {
+			alignments[i] = "---"
+			if isCountColumn(name) {
+				alignments[i] = "---:"
+			}
+		}
+		fmt.Fprintf(b, "| %s |\n", strings.Join(alignments, " | "))
+		for _, row := range table.Rows {
+			writeMarkdownRow(b, row)
+		}
+	}
+
+	if err := b.Flush(); err != nil {
+		return fmt.Errorf("error writing Markdown report: %w", err)
+	}
+	return nil
+}
+
+// writeMarkdownRow writes one table row with its cells escaped
+func writeMarkdownRow(w io.Writer, cells []string) {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = markdownEscaper.Replace(cell)
+	}
+	fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+}
@@ -0,0 +1,34 @@
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	results := tableTestAggregator(t, AggregationConfig{SortDesc: true}).GetResults()
+	if err := WriteMarkdown(&buf, results); err != nil {
+		t.Fatalf("WriteMarkdown failed: %v", err)
+	}
+	markdown := buf.String()
+
+	for _, expected := range []string{
+		"# Click Analytics Report\n\n- **Filter:** none\n",
+		"- **Total Clicks:** 5\n",
+		"## Clicks by url\n\n| url | clicks |\n| --- | ---: |\n| https://c.com/ | 2 |\n",
+		"| Newsletter, \"Weekly\"\tIssue 3 | 1 |\n",
+		"## Unknown bitlinks\n\n| bitlink | domain | clicks | first_seen | last_seen |\n| --- | --- | ---: | --- | --- |\n",
+	} {
+		if !strings.Contains(markdown, expected) {
+			t.Errorf("Expected the report to contain %q, got:\n%s", expected, markdown)
+		}
+	}
+}
+
+func TestMarkdownEscaper(t *testing.T) {
+	if got := markdownEscaper.Replace("a|b\r\nc<script>"); got != `a\|b c&lt;script>` {
+		t.Errorf("Unexpected escaping: %q", got)
+	}
+}
@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricsContentType is the media type of the Prometheus text exposition format
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricsWriter writes metric families in the Prometheus text exposition format
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+// family writes the HELP and TYPE lines that start a metric family
+func (m metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeMetricHelp(help), name, kind)
+}
+
+// sample writes one sample; labels alternate name and value
+func (m metricsWriter) sample(name string, value float64, labels ...string) {
+	m.w.WriteString(name)
+	if len(labels) > 0 {
+		m.w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				m.w.WriteByte(',')
+			}
+			fmt.Fprintf(m.w, "%s=\"%s\"", labels[i], escapeMetricLabel(labels[i+1]))
+		}
+		m.w.WriteByte('}')
+	}
+	m.w.WriteByte(' ')
+	m.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.w.WriteByte('\n')
+}
+
+// counts writes one sample per key of counts, in key order so output diffs cleanly,
+// labelled with the dimension and the key. The empty key is labelled EmptyKeyLabel,
+// as in the result tables.
+func (m metricsWriter) counts(name, dimension, label string, counts map[string]int) {
+	labelled := make(map[string]int, len(counts))
+	for key, count := range counts {
+		labelled[tableKey(key)] += count // A series may only appear once
+	}
+	keys := make([]string, 0, len(labelled))
+	for key := range labelled {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		m.sample(name, float64(labelled[key]), "dimension", dimension, label, key)
+	}
+}
+
+// WriteMetrics writes the results in the Prometheus text exposition format, for
+// node_exporter's textfile collector or a /metrics endpoint. Every
+// encode_clicks_total series carries a dimension label ("all", "url" or
+// "referrer"), so sums select one dimension rather than count clicks twice.
+func WriteMetrics(w io.Writer, results AggregationResults) error {
+	m := metricsWriter{w: bufio.NewWriter(w)}
+
+	m.family("encode_clicks_total", "counter", "Clicks counted in the filtered range: in total, by long URL (mapped bitlinks only) and by referrer.")
+	m.sample("encode_clicks_total", float64(results.TotalClicks), "dimension", "all")
+	m.counts("encode_clicks_total", "url", "long_url", results.ClicksByURL)
+	m.counts("encode_clicks_total", "referrer", "referrer", results.ClicksByReferrer)
+
+	m.family("encode_records_processed_total", "counter", "Decode records read, including filtered and unknown ones.")
+	m.sample("encode_records_processed_total", float64(results.ProcessedRecords))
+
+	reasons := results.FilteredReasons
+	m.family("encode_records_filtered_total", "counter", "Decode records excluded by the time, location and -where filters, by reason.")
+	m.sample("encode_records_filtered_total", float64(reasons.BeforeRange), "reason", "before_range")
+	m.sample("encode_records_filtered_total", float64(reasons.AfterRange), "reason", "after_range")
+	m.sample("encode_records_filtered_total", float64(reasons.Unparseable), "reason", "unparseable")
+	m.sample("encode_records_filtered_total", float64(reasons.GeoMismatch), "reason", "geo_mismatch")
+	m.sample("encode_records_filtered_total", float64(reasons.WhereFalse), "reason", "where_false")
+
+	unknownClicks := 0
+	for _, clicks := range results.UnknownBitlinks {
+		unknownClicks += clicks
+	}
+	m.family("encode_unknown_bitlink_clicks_total", "counter", "Clicks on bitlinks missing from the encodes mapping.")
+	m.sample("encode_unknown_bitlink_clicks_total", float64(unknownClicks))
+	m.family("encode_unknown_bitlinks", "gauge", "Distinct bitlinks missing from the encodes mapping.")
+	m.sample("encode_unknown_bitlinks", float64(len(results.UnknownBitlinks)))
+
+	m.family("encode_processing_duration_seconds", "gauge", "Time spent streaming and aggregating the decode records.")
+	m.sample("encode_processing_duration_seconds", results.ProcessingTime.Seconds())
+
+	if err := m.w.Flush(); err != nil {
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	return nil
+}
+
+// WriteMetricsFile writes the metrics to path through a temporary file in the same
+// directory, so the textfile collector never reads a partly written file
+func WriteMetricsFile(path string, results AggregationResults) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating metrics file: %w", err)
+	}
+	defer os.Remove(file.Name()) // No-op once renamed
+
+	if err := WriteMetrics(file, results); err != nil {
+		file.Close()
+		return err
+	}
+	// CreateTemp makes the file private; the collector may run as another user
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	return nil
+}
+
+// MetricsHandler serves the metrics of the finished results; they are rendered
+// once, since the results no longer change
+func MetricsHandler(results AggregationResults) http.Handler {
+	var buf bytes.Buffer
+	WriteMetrics(&buf, results) // Writing to a buffer cannot fail
+	body := buf.Bytes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", MetricsContentType)
+		w.Write(body)
+	})
+}
+
+// escapeMetricLabel escapes a label value: backslash, double quote and newline
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// escapeMetricHelp escapes HELP text: backslash and newline
+func escapeMetricHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metricsTestResults has a referrer needing escaping, a click without one, a filtered
+// record and an unknown bitlink
+func metricsTestResults(t *testing.T) AggregationResults {
+	aggregator := aggregateRecords(t, URLMapping{"http://bit.ly/a": "https://a.com/"}, AggregationConfig{FilterYear: 2021}, []DecodeRecord{
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T00:00:00Z", Referrer: "say \"hi\"\\\nbye"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-02T00:00:00Z", Referrer: "direct"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2020-01-02T00:00:00Z", Referrer: "direct"},
+		{Bitlink: "http://es.pn/x", Timestamp: "2021-01-03T00:00:00Z", Referrer: "direct"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-04T00:00:00Z"},
+	}...)
+	results := aggregator.GetResults()
+	results.ProcessingTime = 1500 * time.Millisecond
+	return results
+}
+
+func TestWriteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf, metricsTestResults(t)); err != nil {
+		t.Fatalf("WriteMetrics failed: %v", err)
+	}
+	output := buf.String()
+
+	for _, expected := range []string{
+		"# TYPE encode_clicks_total counter\nencode_clicks_total{dimension=\"all\"} 4\n",
+		"encode_clicks_total{dimension=\"url\",long_url=\"https://a.com/\"} 3\n",
+		"encode_clicks_total{dimension=\"referrer\",referrer=\"(empty)\"} 1\n",
+		"encode_clicks_total{dimension=\"referrer\",referrer=\"direct\"} 2\n",
+		"encode_clicks_total{dimension=\"referrer\",referrer=\"say \\\"hi\\\"\\\\\\nbye\"} 1\n",
+		"encode_records_processed_total 5\n",
+		"encode_records_filtered_total{reason=\"before_range\"} 1\n",
+		"encode_unknown_bitlink_clicks_total 1\n",
+		"encode_unknown_bitlinks 1\n",
+		"# TYPE encode_processing_duration_seconds gauge\nencode_processing_duration_seconds 1.5\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", expected, output)
+		}
+	}
+
+	// Every line is a comment or a "name{labels} value" sample
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if !strings.HasPrefix(line, "# ") && !strings.HasPrefix(line, "encode_") {
+			t.Errorf("Unexpected line %q", line)
+		}
+	}
+}
+
+func TestWriteMetricsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encode.prom")
+	results := metricsTestResults(t)
+	for i := 0; i < 2; i++ { // The second write replaces the first
+		if err := WriteMetricsFile(path, results); err != nil {
+			t.Fatalf("WriteMetricsFile failed: %v", err)
+		}
+	}
+
+	var expected bytes.Buffer
+	WriteMetrics(&expected, results)
+	data, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(data, expected.Bytes()) {
+		t.Errorf("Unexpected metrics file (err %v):\n%s", err, data)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("Expected only the metrics file to remain, got %d entries", len(entries))
+	}
+
+	if err := WriteMetricsFile(filepath.Join(path, "missing", "encode.prom"), results); err == nil {
+		t.Error("Expected error for a missing directory, got nil")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	handler := MetricsHandler(metricsTestResults(t))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != MetricsContentType {
+		t.Errorf("Unexpected response: %d %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), "encode_records_processed_total 5\n") {
+		t.Errorf("Unexpected body:\n%s", recorder.Body)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", recorder.Code)
+	}
+}
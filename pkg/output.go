@@ -0,0 +1,269 @@
+package pkg
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// OutputFormat names one of the built-in reporters; LookupReporter also finds
+// reporters added with RegisterReporter
+type OutputFormat int
+
+const (
+	OutputText       OutputFormat = iota // Human-readable summary (WriteSummary)
+	OutputJSON                           // Schema-versioned JSON document (WriteJSON)
+	OutputCSV                            // One CSV file per aggregation table (WriteTables)
+	OutputTSV                            // One tab-separated file per aggregation table (WriteTables)
+	OutputHTML                           // Self-contained HTML report (WriteHTML)
+	OutputPrometheus                     // Prometheus text exposition format (WriteMetrics)
+	OutputMarkdown                       // GitHub-flavoured Markdown report (WriteMarkdown)
+)
+
+// outputFormats lists the formats in declaration order
+var outputFormats = []OutputFormat{OutputText, OutputJSON, OutputCSV, OutputTSV, OutputHTML, OutputPrometheus, OutputMarkdown}
+
+// String returns the flag-friendly name of the format, which is its reporter's name
+func (f OutputFormat) String() string {
+	switch f {
+	case OutputJSON:
+		return "json"
+	case OutputCSV:
+		return "csv"
+	case OutputTSV:
+		return "tsv"
+	case OutputHTML:
+		return "html"
+	case OutputPrometheus:
+		return "prometheus"
+	case OutputMarkdown:
+		return "markdown"
+	default:
+		return "text"
+	}
+}
+
+// Reporter returns the registered reporter for the format
+func (f OutputFormat) Reporter() Reporter {
+	reporter, _ := LookupReporter(f.String()) // Built-ins are always registered
+	return reporter
+}
+
+// ParseOutputFormat converts a flag value (text, json, csv, tsv, html, prometheus, markdown) into an OutputFormat
+func ParseOutputFormat(value string) (OutputFormat, error) {
+	name := strings.ToLower(strings.TrimSpace(value))
+	if name == "" {
+		return OutputText, nil
+	}
+	for _, format := range outputFormats {
+		if format.String() == name {
+			return format, nil
+		}
+	}
+	return OutputText, fmt.Errorf("unknown output format %q (expected text, json, csv, tsv, html, prometheus or markdown)", value)
+}
+
+// ResultsSchemaVersion is the schema_version of ResultsDocument; it is bumped when
+// a field is removed or changes meaning, not when one is added
+const ResultsSchemaVersion = 1
+
+// ResultsSchema is the JSON Schema (draft 2020-12) describing ResultsDocument
+//
+//go:embed schema/results.schema.json
+var ResultsSchema []byte
+
+// ResultsDocument is the JSON form of AggregationResults. Count lists are sorted
+// like the summary (by count, following SortDesc) and keep the empty key "", so
+// each click list adds up to TotalClicks; maps are keyed by name.
+type ResultsDocument struct {
+	SchemaVersion    int                   `json:"schema_version"`
+	Filter           FilterDocument        `json:"filter"`
+	Granularity      string                `json:"granularity"`
+	TimeZone         string                `json:"time_zone"`
+	ProcessedRecords int                   `json:"processed_records"`
+	TotalClicks      int                   `json:"total_clicks"`
+	FilteredOut      int                   `json:"filtered_out"`
+	FilteredReasons  FilterBreakdown       `json:"filtered_reasons"`
+	ProcessingTime   float64               `json:"processing_time_seconds"`
+	SourceFiles      []SourceFile          `json:"source_files"`
+	TimestampFormats map[string]int        `json:"timestamp_formats"`
+	RecordErrors     map[string]int        `json:"record_errors"`
+	Bots             BotsDocument          `json:"bots"`
+	URLs             []KeyValue            `json:"urls"` // Mapped long URLs only
+	Referrers        []KeyValue            `json:"referrers"`
+	ReferrerHosts    []KeyValue            `json:"referrer_hosts"`
+	Channels         []KeyValue            `json:"channels"`
+	Dates            []KeyValue            `json:"dates"`       // Keyed by bucket label
+	TimeSeries       []TimeBucket          `json:"time_series"` // Chronological and zero-filled
+	Browsers         []KeyValue            `json:"browsers"`
+	OperatingSystems []KeyValue            `json:"operating_systems"`
+	Devices          []KeyValue            `json:"devices"`
+	Countries        []KeyValue            `json:"countries"` // Empty without a GeoIP database
+	Regions          []KeyValue            `json:"regions"`
+	Cities           []KeyValue            `json:"cities"`
+	UnknownBitlinks  *UnknownBitlinkReport `json:"unknown_bitlinks"`
+	UniqueVisitors   *VisitorsDocument     `json:"unique_visitors"` // null unless visitors are counted
+	Pivot            *PivotDocument        `json:"pivot"`           // null without GroupBy
+}
+
+// FilterDocument describes the filters that were applied
+type FilterDocument struct {
+	Year      int        `json:"year"` // 0 when filtering by range or not at all
+	From      *time.Time `json:"from"` // null when unbounded
+	To        *time.Time `json:"to"`   // Exclusive; null when unbounded
+	Countries []string   `json:"countries"`
+	Regions   []string   `json:"regions"`
+	Cities    []string   `json:"cities"`
+	Where     string     `json:"where"`
+	SortDesc  bool       `json:"sort_desc"` // Order of the count lists
+}
+
+// BotsDocument describes bot detection
+type BotsDocument struct {
+	Enabled  bool           `json:"enabled"`
+	Excluded bool           `json:"excluded"`
+	Clicks   int            `json:"clicks"`
+	ByRule   map[string]int `json:"by_rule"`
+}
+
+// VisitorsDocument holds the unique visitor counts
+type VisitorsDocument struct {
+	Total         int        `json:"total"`
+	Exact         bool       `json:"exact"`
+	Key           string     `json:"key"`
+	Precision     int        `json:"precision"`      // HyperLogLog precision (unused when exact)
+	StandardError float64    `json:"standard_error"` // Relative; 0 when exact
+	ByURL         []KeyValue `json:"by_url"`
+	ByReferrer    []KeyValue `json:"by_referrer"`
+	ByDate        []KeyValue `json:"by_date"`
+}
+
+// PivotDocument is the flattened pivot table
+type PivotDocument struct {
+	Dimensions []Dimension `json:"dimensions"`
+	Rows       []PivotRow  `json:"rows"`
+}
+
+// ResultsDocument builds the JSON form of the current results
+func (a *Aggregator) ResultsDocument() ResultsDocument {
+	results := a.GetResults()
+	return results.ResultsDocument()
+}
+
+// ResultsDocument builds the JSON form of the results
+func (r *AggregationResults) ResultsDocument() ResultsDocument {
+	filter := FilterDocument{
+		Year:      r.FilterYear,
+		Where:     r.FilterWhere,
+		SortDesc:  r.SortDesc,
+		Countries: nonNil(r.FilterGeo.Countries),
+		Regions:   nonNil(r.FilterGeo.Regions),
+		Cities:    nonNil(r.FilterGeo.Cities),
+	}
+	if !r.FilterRange.From.IsZero() {
+		filter.From = &r.FilterRange.From
+	}
+	if !r.FilterRange.To.IsZero() {
+		filter.To = &r.FilterRange.To
+	}
+
+	series, err := r.TimeSeries()
+	if err != nil {
+		series = nil // Labels that do not parse leave the series empty, as in the summary
+	}
+
+	doc := ResultsDocument{
+		SchemaVersion:    ResultsSchemaVersion,
+		Filter:           filter,
+		Granularity:      r.Granularity.String(),
+		TimeZone:         r.TimeZone,
+		ProcessedRecords: r.ProcessedRecords,
+		TotalClicks:      r.TotalClicks,
+		FilteredOut:      r.FilteredOut,
+		FilteredReasons:  r.FilteredReasons,
+		ProcessingTime:   r.ProcessingTime.Seconds(),
+		SourceFiles:      nonNil(r.SourceFiles),
+		TimestampFormats: r.TimestampFormats,
+		RecordErrors:     r.RecordErrors,
+		Bots: BotsDocument{
+			Enabled:  r.BotsEnabled,
+			Excluded: r.BotsExcluded,
+			Clicks:   r.BotClicks,
+			ByRule:   r.BotClicksByRule,
+		},
+		URLs:             sortedCounts(r, r.ClicksByURL),
+		Referrers:        sortedCounts(r, r.ClicksByReferrer),
+		ReferrerHosts:    sortedCounts(r, r.ClicksByReferrerHost),
+		Channels:         sortedCounts(r, r.ClicksByChannel),
+		Dates:            sortedCounts(r, r.ClicksByDate),
+		TimeSeries:       nonNil(series),
+		Browsers:         sortedCounts(r, r.ClicksByBrowser),
+		OperatingSystems: sortedCounts(r, r.ClicksByOS),
+		Devices:          sortedCounts(r, r.ClicksByDevice),
+		Countries:        sortedCounts(r, r.ClicksByCountry),
+		Regions:          sortedCounts(r, r.ClicksByRegion),
+		Cities:           sortedCounts(r, r.ClicksByCity),
+		UnknownBitlinks:  r.UnknownBitlinkReport(),
+	}
+	if r.Visitors != nil {
+		doc.UniqueVisitors = &VisitorsDocument{
+			Total:         r.UniqueVisitors,
+			Exact:         r.Visitors.Exact,
+			Key:           r.Visitors.Key.String(),
+			Precision:     int(r.Visitors.Precision),
+			StandardError: r.Visitors.standardError(),
+			ByURL:         sortedCounts(r, r.UniqueVisitorsByURL),
+			ByReferrer:    sortedCounts(r, r.UniqueVisitorsByReferrer),
+			ByDate:        sortedCounts(r, r.UniqueVisitorsByDate),
+		}
+	}
+	if r.Pivot != nil {
+		doc.Pivot = &PivotDocument{
+			Dimensions: r.Pivot.Dimensions,
+			Rows:       r.Pivot.Rows(PivotOptions{Descending: r.SortDesc}),
+		}
+	}
+	return doc
+}
+
+// WriteJSON writes the current results as an indented ResultsDocument
+func (a *Aggregator) WriteJSON(w io.Writer) error {
+	return WriteJSON(w, a.GetResults())
+}
+
+// WriteJSON writes the results as an indented ResultsDocument
+func WriteJSON(w io.Writer, results AggregationResults) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false) // Keep & in URLs readable
+	if err := encoder.Encode(results.ResultsDocument()); err != nil {
+		return fmt.Errorf("error writing JSON results: %w", err)
+	}
+	return nil
+}
+
+// sortedCounts is sortedAllCounts for JSON, where an empty list is [] rather than null
+func sortedCounts(results *AggregationResults, data map[string]int) []KeyValue {
+	return nonNil(results.sortedAllCounts(data))
+}
+
+// nonNil returns items, or an empty slice when items is nil
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+// jsonQuote returns s as a JSON string literal, leaving <, > and & readable
+func jsonQuote(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s) // Encoding a string cannot fail
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,224 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTestJSON aggregates a few clicks, including a URL that needs escaping, and
+// returns the JSON document
+func writeTestJSON(t *testing.T, config AggregationConfig) []byte {
+	mapping := URLMapping{
+		"http://bit.ly/quote": `https://example.com/?q="a&b"`,
+		"http://bit.ly/plain": "https://example.com/",
+	}
+	aggregator := aggregateRecords(t, mapping, config, []DecodeRecord{
+		{Bitlink: "http://bit.ly/quote", Timestamp: "2021-01-01T00:00:00Z", Referrer: "t.co", RemoteIP: "1.1.1.1"},
+		{Bitlink: "http://bit.ly/quote", Timestamp: "2021-01-01T00:00:00Z", RemoteIP: "3.3.3.3"}, // No referrer
+		{Bitlink: "http://bit.ly/quote", Timestamp: "2021-01-03T00:00:00Z", Referrer: "direct", RemoteIP: "2.2.2.2"},
+		{Bitlink: "http://bit.ly/plain", Timestamp: "2021-01-03T00:00:00Z", Referrer: "direct", RemoteIP: "1.1.1.1"},
+		{Bitlink: "https://bit.ly/plain", Timestamp: "2021-01-03T00:00:00Z", Referrer: "direct", RemoteIP: "1.1.1.1"},
+	}...)
+	aggregator.AddSourceFiles(SourceFile{Path: "decodes.json", Records: 5})
+
+	var buf bytes.Buffer
+	if err := aggregator.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestAggregator_WriteJSON(t *testing.T) {
+	var doc ResultsDocument
+	if err := json.Unmarshal(writeTestJSON(t, AggregationConfig{FilterYear: 2021, SortDesc: true}), &doc); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+
+	if doc.SchemaVersion != ResultsSchemaVersion || doc.TotalClicks != 5 || doc.Filter.Year != 2021 || !doc.Filter.SortDesc {
+		t.Errorf("Unexpected header: version %d, %d clicks, filter %+v", doc.SchemaVersion, doc.TotalClicks, doc.Filter)
+	}
+	if len(doc.URLs) != 2 || doc.URLs[0] != (KeyValue{Key: `https://example.com/?q="a&b"`, Value: 3}) {
+		t.Errorf("Expected the quoted URL first with 3 clicks, got %v", doc.URLs)
+	}
+	// Every click list accounts for every click, including the one with no referrer
+	sum := func(items []KeyValue) int {
+		total := 0
+		for _, item := range items {
+			total += item.Value
+		}
+		return total
+	}
+	lists := map[string][]KeyValue{
+		"referrers": doc.Referrers, "referrer_hosts": doc.ReferrerHosts, "channels": doc.Channels, "dates": doc.Dates,
+		"browsers": doc.Browsers, "operating_systems": doc.OperatingSystems, "devices": doc.Devices,
+	}
+	for name, items := range lists {
+		if got := sum(items); got != doc.TotalClicks {
+			t.Errorf("Expected %s to sum to %d clicks, got %d: %v", name, doc.TotalClicks, got, items)
+		}
+	}
+	if got := sum(doc.URLs) + doc.UnknownBitlinks.Clicks; got != doc.TotalClicks {
+		t.Errorf("Expected urls and unknown bitlinks to sum to %d clicks, got %d", doc.TotalClicks, got)
+	}
+	if len(doc.TimeSeries) != 3 || doc.TimeSeries[1].Clicks != 0 {
+		t.Errorf("Expected a zero-filled 3-day series, got %v", doc.TimeSeries)
+	}
+	if doc.UnknownBitlinks.Clicks != 1 || doc.UnknownBitlinks.Domains[0].Bitlinks[0].NearMatches[0].Bitlink != "http://bit.ly/plain" {
+		t.Errorf("Unexpected unknown bitlinks: %+v", doc.UnknownBitlinks)
+	}
+	if doc.UniqueVisitors != nil || doc.Pivot != nil {
+		t.Error("Expected no visitors or pivot without their options")
+	}
+}
+
+func TestAggregator_WriteJSON_MatchesSchema(t *testing.T) {
+	var schema map[string]any
+	if err := json.Unmarshal(ResultsSchema, &schema); err != nil {
+		t.Fatalf("Invalid schema: %v", err)
+	}
+	// Fields are added without a version bump, so consumers must not reject unknown ones
+	if bytes.Contains(ResultsSchema, []byte(`"additionalProperties": false`)) {
+		t.Error("Expected the schema to leave objects open to new properties")
+	}
+
+	configs := map[string]AggregationConfig{
+		"defaults": {},
+		"everything": {
+			FilterYear: 2021,
+			GeoFilter:  GeoFilter{Countries: []string{"unknown"}},
+			Bots:       botTestDetector(),
+			Visitors:   &VisitorConfig{Precision: DefaultHLLPrecision},
+			GroupBy:    []Dimension{DimensionURL, DimensionDate},
+		},
+	}
+	for name, config := range configs {
+		var doc any
+		if err := json.Unmarshal(writeTestJSON(t, config), &doc); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", name, err)
+		}
+		for _, problem := range validateSchema(schema, schema, doc, "$") {
+			t.Errorf("%s: %s", name, problem)
+		}
+	}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	for value, expected := range map[string]OutputFormat{"": OutputText, "text": OutputText, " JSON ": OutputJSON, "html": OutputHTML, "prometheus": OutputPrometheus, "markdown": OutputMarkdown} {
+		if got, err := ParseOutputFormat(value); err != nil || got != expected {
+			t.Errorf("ParseOutputFormat(%q): expected %v, got %v (err %v)", value, expected, got, err)
+		}
+	}
+	if _, err := ParseOutputFormat("xml"); err == nil {
+		t.Error("Expected error for xml, got nil")
+	}
+
+	// Every format names a registered reporter
+	for _, format := range outputFormats {
+		if format.Reporter() == nil {
+			t.Errorf("Expected a reporter for %s", format)
+		}
+	}
+	if OutputTSV.Reporter() != (TableReporter{Delimiter: '\t'}) {
+		t.Errorf("Expected the tsv reporter, got %#v", OutputTSV.Reporter())
+	}
+}
+
+func TestJSONQuote(t *testing.T) {
+	if got := jsonQuote(`https://a.com/?q="x"&y=<z>`); got != `"https://a.com/?q=\"x\"&y=<z>"` {
+		t.Errorf("Unexpected quoting: %s", got)
+	}
+}
+
+// validateSchema checks value against the subset of JSON Schema used by
+// results.schema.json: type, const, enum, required, properties,
+// additionalProperties, items and local $refs. It is stricter than the schema: an
+// object property that is neither listed nor covered by an additionalProperties
+// schema is reported, so every field the document writes stays documented.
+func validateSchema(root, schema map[string]any, value any, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		definition := root["$defs"].(map[string]any)[strings.TrimPrefix(ref, "#/$defs/")]
+		return validateSchema(root, definition.(map[string]any), value, path)
+	}
+
+	if constant, ok := schema["const"]; ok && value != constant {
+		return []string{fmt.Sprintf("%s: expected %v, got %v", path, constant, value)}
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || value == allowed
+		}
+		if !found {
+			return []string{fmt.Sprintf("%s: %v is not one of %v", path, value, enum)}
+		}
+	}
+	if types, ok := schema["type"]; ok && !matchesSchemaType(types, value) {
+		return []string{fmt.Sprintf("%s: %v does not have type %v", path, value, types)}
+	}
+
+	var problems []string
+	switch value := value.(type) {
+	case map[string]any:
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing %s", path, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := path + "." + name
+			if property, ok := properties[name].(map[string]any); ok {
+				problems = append(problems, validateSchema(root, property, value[name], child)...)
+			} else if additional, ok := schema["additionalProperties"].(map[string]any); ok {
+				problems = append(problems, validateSchema(root, additional, value[name], child)...)
+			} else {
+				problems = append(problems, fmt.Sprintf("%s: not in the schema", child))
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				problems = append(problems, validateSchema(root, items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return problems
+}
+
+// matchesSchemaType reports whether a decoded JSON value has one of the schema types
+func matchesSchemaType(types any, value any) bool {
+	names, ok := types.([]any)
+	if !ok {
+		names = []any{types}
+	}
+	for _, name := range names {
+		switch value := value.(type) {
+		case nil:
+			ok = name == "null"
+		case bool:
+			ok = name == "boolean"
+		case string:
+			ok = name == "string"
+		case float64:
+			ok = name == "number" || (name == "integer" && value == float64(int64(value)))
+		case []any:
+			ok = name == "array"
+		case map[string]any:
+			ok = name == "object"
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Defaults used when ParallelConfig fields are left at zero
+const (
+	defaultBatchSize = 1024
+	workerQueueDepth = 4 // Batches buffered per worker so the decoder rarely blocks
+)
+
+// errPipelineStopped is returned to the record source when a worker has failed
+var errPipelineStopped = errors.New("parallel pipeline stopped")
+
+// ParallelConfig controls the worker pool used by ProcessParallel
+type ParallelConfig struct {
+	Workers   int // Number of aggregation workers (0 means runtime.GOMAXPROCS)
+	BatchSize int // Records handed to a worker at a time (0 means 1024)
+	// Errors handles records the aggregator rejects; nil stops at the first one.
+	// Rejected records keep the file, line, offset and raw bytes recorded by the
+	// decoder; records from other sources are identified by their index in the stream.
+	Errors *ErrorPolicy
+}
+
+// RecordSource streams decode records into a callback
+// StreamDecodesFiles and StreamDecodesFrom can be adapted with a closure
+type RecordSource func(callback func(DecodeRecord) error) error
+
+// recordBatch is a run of records from the source bound for one worker
+type recordBatch struct {
+	records []DecodeRecord
+	indexes []int // Stream index of each record
+}
+
+// newRecordBatch allocates an empty batch with room for size records
+func newRecordBatch(size int) recordBatch {
+	return recordBatch{records: make([]DecodeRecord, 0, size), indexes: make([]int, 0, size)}
+}
+
+// aggregatorShard is one worker's private aggregator and the first error it hit
+type aggregatorShard struct {
+	aggregator *Aggregator
+	err        error
+	errRecord  int // Stream index of the record where err occurred
+}
+
+// ProcessParallel aggregates records using a worker pool
+// One goroutine (the caller's source) decodes records and hands out batches
+// round-robin to N workers, each aggregating into its own shard. Shards are merged
+// into a when the source is exhausted. Results are identical to feeding the same
+// records through ProcessRecord serially; with a SequentialRule active (such as
+// the default rate rule) records are sharded by remote IP instead, so each IP's
+// clicks reach the rule in stream order on a single worker.
+// If a record fails to aggregate, the earliest failing record in stream order is
+// reported and the aggregator is left unchanged. If the source itself fails (for
+// example when its context is cancelled), every record delivered before the failure
+// is still merged so partial results are available, and the source error is returned.
+func (a *Aggregator) ProcessParallel(source RecordSource, config ParallelConfig) error {
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	shards := make([]*aggregatorShard, workers)
+	queues := make([]chan recordBatch, workers)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		shards[w] = &aggregatorShard{aggregator: NewAggregator(a.mapping, a.config)}
+		queues[w] = make(chan recordBatch, workerQueueDepth)
+
+		wg.Add(1)
+		go func(shard *aggregatorShard, queue <-chan recordBatch) {
+			defer wg.Done()
+			for batch := range queue {
+				if shard.err != nil {
+					continue // Drain remaining batches after a failure
+				}
+				for i, record := range batch.records {
+					index := batch.indexes[i]
+					err := shard.aggregator.ProcessRecord(record)
+					var rejected *RecordError
+					if err != nil && config.Errors.lenient() && errors.As(err, &rejected) {
+						if record.position != nil {
+							rejected.setPosition(record.position)
+						} else {
+							rejected.Record = index
+							rejected.Raw, _ = json.Marshal(record)
+						}
+						err = config.Errors.Reject(rejected)
+					}
+					if err != nil {
+						shard.err = fmt.Errorf("error processing record %d: %w", index, err)
+						shard.errRecord = index
+						failed.Store(true)
+						break
+					}
+				}
+			}
+		}(shards[w], queues[w])
+	}
+
+	// Decode on the calling goroutine, dispatching full batches round-robin or,
+	// for a SequentialRule, to the worker that owns the click's IP
+	byIP := a.config.Bots != nil && a.config.Bots.Sequential()
+	pending := make([]recordBatch, workers)
+	for w := range pending {
+		pending[w] = newRecordBatch(batchSize)
+	}
+	next, index := 0, 0
+	dispatch := func(w int) {
+		queues[w] <- pending[w]
+		pending[w] = newRecordBatch(batchSize)
+	}
+
+	sourceErr := source(func(record DecodeRecord) error {
+		if failed.Load() {
+			return errPipelineStopped
+		}
+		w := next
+		if byIP && record.RemoteIP != "" {
+			w = ipShard(record.RemoteIP, workers)
+		}
+		pending[w].records = append(pending[w].records, record)
+		pending[w].indexes = append(pending[w].indexes, index)
+		index++
+		if len(pending[w].records) == batchSize {
+			dispatch(w)
+			if w == next {
+				next = (next + 1) % workers
+			}
+		}
+		return nil
+	})
+	// Partial batches are flushed even after a failure, since one may hold a
+	// failing record from earlier in the stream
+	for w := range pending {
+		if len(pending[w].records) > 0 {
+			dispatch(w)
+		}
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	// Report the worker error that occurred earliest in the stream
+	var firstFailure *aggregatorShard
+	for _, shard := range shards {
+		if shard.err != nil && (firstFailure == nil || shard.errRecord < firstFailure.errRecord) {
+			firstFailure = shard
+		}
+	}
+	if firstFailure != nil {
+		return firstFailure.err
+	}
+
+	for _, shard := range shards {
+		a.Merge(shard.aggregator)
+	}
+	return sourceErr
+}
+
+// ipShard maps an IP to a worker with FNV-1a, so all of its clicks share a shard
+func ipShard(ip string, workers int) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(ip); i++ {
+		hash ^= uint32(ip[i])
+		hash *= 16777619
+	}
+	return int(hash % uint32(workers))
+}
+
+// Merge folds another aggregator's results into this one
+// other is treated as having processed records after this aggregator's, so its
+// source files are appended. Processing time is not merged.
+func (a *Aggregator) Merge(other *Aggregator) {
+	a.results.TotalClicks += other.results.TotalClicks
+	a.results.ProcessedRecords += other.results.ProcessedRecords
+	a.results.FilteredOut += other.results.FilteredOut
+	a.results.FilteredReasons.BeforeRange += other.results.FilteredReasons.BeforeRange
+	a.results.FilteredReasons.AfterRange += other.results.FilteredReasons.AfterRange
+	a.results.FilteredReasons.Unparseable += other.results.FilteredReasons.Unparseable
+	a.results.FilteredReasons.GeoMismatch += other.results.FilteredReasons.GeoMismatch
+	a.results.FilteredReasons.WhereFalse += other.results.FilteredReasons.WhereFalse
+	a.results.BotClicks += other.results.BotClicks
+	mergeCountMap(a.results.ClicksByURL, other.results.ClicksByURL)
+	mergeCountMap(a.results.UnknownBitlinks, other.results.UnknownBitlinks)
+	for bitlink, seen := range other.results.UnknownBitlinkSeen {
+		a.results.UnknownBitlinkSeen[bitlink] = a.results.UnknownBitlinkSeen[bitlink].merge(seen)
+	}
+	mergeCountMap(a.results.ClicksByReferrer, other.results.ClicksByReferrer)
+	mergeCountMap(a.results.ClicksByReferrerHost, other.results.ClicksByReferrerHost)
+	mergeCountMap(a.results.ClicksByChannel, other.results.ClicksByChannel)
+	mergeCountMap(a.results.ClicksByDate, other.results.ClicksByDate)
+	mergeCountMap(a.results.ClicksByBrowser, other.results.ClicksByBrowser)
+	mergeCountMap(a.results.ClicksByOS, other.results.ClicksByOS)
+	mergeCountMap(a.results.ClicksByDevice, other.results.ClicksByDevice)
+	mergeCountMap(a.results.ClicksByCountry, other.results.ClicksByCountry)
+	mergeCountMap(a.results.ClicksByRegion, other.results.ClicksByRegion)
+	mergeCountMap(a.results.ClicksByCity, other.results.ClicksByCity)
+	mergeCountMap(a.results.TimestampFormats, other.results.TimestampFormats)
+	mergeCountMap(a.results.RecordErrors, other.results.RecordErrors)
+	mergeCountMap(a.results.BotClicksByRule, other.results.BotClicksByRule)
+	a.results.SourceFiles = append(a.results.SourceFiles, other.results.SourceFiles...)
+	if a.results.Pivot != nil && other.results.Pivot != nil {
+		a.results.Pivot.Merge(other.results.Pivot)
+	}
+	if a.visitors != nil && other.visitors != nil {
+		a.visitors.merge(other.visitors)
+	}
+}
+
+// mergeCountMap adds every count in src to dst
+func mergeCountMap(dst, src map[string]int) {
+	for key, count := range src {
+		dst[key] += count
+	}
+}
@@ -0,0 +1,246 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syntheticRecords builds a deterministic mix of mapped, unknown and out-of-year records
+func syntheticRecords(n int) []DecodeRecord {
+	bitlinks := []string{"http://bit.ly/google", "http://bit.ly/github", "http://bit.ly/unknown", "http://es.pn/unknown"}
+	referrers := []string{"direct", "t.co", "facebook.com", "reddit.com"}
+
+	records := make([]DecodeRecord, n)
+	for i := range records {
+		records[i] = DecodeRecord{
+			Bitlink:   fmt.Sprintf("%s%d", bitlinks[i%len(bitlinks)], i%7),
+			UserAgent: "Mozilla/5.0",
+			Timestamp: fmt.Sprintf("%d-%02d-%02dT00:00:00Z", 2020+i%2, 1+i%12, 1+i%28),
+			Referrer:  referrers[i%len(referrers)],
+			RemoteIP:  fmt.Sprintf("10.0.%d.%d", i%256, i%251),
+		}
+	}
+	return records
+}
+
+func syntheticMapping() URLMapping {
+	mapping := URLMapping{}
+	for i := 0; i < 7; i++ {
+		mapping[fmt.Sprintf("http://bit.ly/google%d", i)] = fmt.Sprintf("https://google.com/%d", i)
+		mapping[fmt.Sprintf("http://bit.ly/github%d", i)] = fmt.Sprintf("https://github.com/%d", i)
+	}
+	return mapping
+}
+
+// sliceSource adapts an in-memory slice to a RecordSource
+func sliceSource(records []DecodeRecord) RecordSource {
+	return func(callback func(DecodeRecord) error) error {
+		for _, record := range records {
+			if err := callback(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestAggregator_ProcessParallel_MatchesSerial(t *testing.T) {
+	records := syntheticRecords(10007) // Not a multiple of the batch size
+	config := AggregationConfig{FilterYear: 2021, SortDesc: true}
+
+	serial := NewAggregator(syntheticMapping(), config)
+	for _, record := range records {
+		if err := serial.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	for _, workers := range []int{1, 3, 8} {
+		parallel := NewAggregator(syntheticMapping(), config)
+		err := parallel.ProcessParallel(sliceSource(records), ParallelConfig{Workers: workers, BatchSize: 100})
+		if err != nil {
+			t.Fatalf("ProcessParallel(%d workers) failed: %v", workers, err)
+		}
+
+		if !reflect.DeepEqual(serial.GetResults(), parallel.GetResults()) {
+			t.Errorf("Parallel results with %d workers differ from serial results", workers)
+		}
+	}
+}
+
+func TestAggregator_ProcessParallel_ReportsFirstError(t *testing.T) {
+	records := syntheticRecords(1000)
+	records[250].Timestamp = "invalid-timestamp"
+	records[750].Timestamp = "also-invalid"
+
+	aggregator := NewAggregator(syntheticMapping(), AggregationConfig{})
+	err := aggregator.ProcessParallel(sliceSource(records), ParallelConfig{Workers: 4, BatchSize: 10})
+	if err == nil {
+		t.Fatal("Expected error for invalid timestamp, got nil")
+	}
+	if !strings.Contains(err.Error(), "record 250") {
+		t.Errorf("Expected error for record 250, got %v", err)
+	}
+	if aggregator.GetResults().ProcessedRecords != 0 {
+		t.Errorf("Expected aggregator to be unchanged after error, got %d processed", aggregator.GetResults().ProcessedRecords)
+	}
+}
+
+func TestAggregator_ProcessParallel_SourceError(t *testing.T) {
+	sourceErr := fmt.Errorf("decode failure")
+	source := func(callback func(DecodeRecord) error) error {
+		return sourceErr
+	}
+
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{})
+	if err := aggregator.ProcessParallel(source, ParallelConfig{Workers: 2}); err != sourceErr {
+		t.Errorf("Expected source error, got %v", err)
+	}
+}
+
+func TestAggregator_Merge(t *testing.T) {
+	mapping := URLMapping{"http://bit.ly/google": "https://google.com/"}
+	first := NewAggregator(mapping, AggregationConfig{FilterYear: 2020})
+	second := NewAggregator(mapping, AggregationConfig{FilterYear: 2020})
+
+	first.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/google", Timestamp: "2020-01-01T00:00:00Z", Referrer: "direct"})
+	first.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/a", Timestamp: "2020-01-01T00:00:00Z", Referrer: "direct"})
+	second.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/google", Timestamp: "2020-01-02T00:00:00Z", Referrer: "t.co"})
+	second.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/b", Timestamp: "2020-01-02T00:00:00Z", Referrer: "t.co"})
+	second.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/google", Timestamp: "2021-01-02T00:00:00Z", Referrer: "t.co"})
+
+	first.Merge(second)
+	results := first.GetResults()
+
+	if results.TotalClicks != 4 || results.ProcessedRecords != 5 || results.FilteredOut != 1 {
+		t.Errorf("Unexpected merged counters: total=%d processed=%d filtered=%d", results.TotalClicks, results.ProcessedRecords, results.FilteredOut)
+	}
+	if results.ClicksByURL["https://google.com/"] != 2 {
+		t.Errorf("Expected 2 merged clicks for google.com, got %d", results.ClicksByURL["https://google.com/"])
+	}
+	if results.ClicksByReferrer["t.co"] != 2 || results.ClicksByDate["2020-01-02"] != 2 {
+		t.Errorf("Unexpected merged referrer/date maps: %v %v", results.ClicksByReferrer, results.ClicksByDate)
+	}
+	if !reflect.DeepEqual(results.UnknownBitlinks, map[string]int{"http://bit.ly/a": 1, "http://bit.ly/b": 1}) {
+		t.Errorf("Expected merged unknown bitlinks, got %v", results.UnknownBitlinks)
+	}
+}
+
+// Benchmark inputs are built once, on first use, so plain test runs stay fast
+var (
+	benchmarkOnce    sync.Once
+	benchmarkRecords []DecodeRecord
+	benchmarkNDJSON  []byte
+)
+
+func loadBenchmarkData(b *testing.B) {
+	benchmarkOnce.Do(func() {
+		benchmarkRecords = syntheticRecords(200000)
+
+		var buf bytes.Buffer
+		for _, record := range benchmarkRecords {
+			line, _ := json.Marshal(record)
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+		benchmarkNDJSON = buf.Bytes()
+	})
+	b.ResetTimer()
+}
+
+func BenchmarkProcessSerial(b *testing.B) {
+	loadBenchmarkData(b)
+	for i := 0; i < b.N; i++ {
+		aggregator := NewAggregator(syntheticMapping(), AggregationConfig{FilterYear: 2021})
+		for _, record := range benchmarkRecords {
+			if err := aggregator.ProcessRecord(record); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkProcessParallel(b *testing.B) {
+	loadBenchmarkData(b)
+	for _, workers := range []int{2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				aggregator := NewAggregator(syntheticMapping(), AggregationConfig{FilterYear: 2021})
+				err := aggregator.ProcessParallel(sliceSource(benchmarkRecords), ParallelConfig{Workers: workers})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkStreamAndProcess measures decoding plus aggregation, serial vs parallel
+func BenchmarkStreamAndProcess(b *testing.B) {
+	loadBenchmarkData(b)
+	b.Run("serial", func(b *testing.B) {
+		b.SetBytes(int64(len(benchmarkNDJSON)))
+		for i := 0; i < b.N; i++ {
+			aggregator := NewAggregator(syntheticMapping(), AggregationConfig{FilterYear: 2021})
+			if err := StreamDecodesFrom(bytes.NewReader(benchmarkNDJSON), FormatNDJSON, aggregator.ProcessRecord); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	for _, workers := range []int{2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(benchmarkNDJSON)))
+			for i := 0; i < b.N; i++ {
+				aggregator := NewAggregator(syntheticMapping(), AggregationConfig{FilterYear: 2021})
+				source := func(callback func(DecodeRecord) error) error {
+					return StreamDecodesFrom(bytes.NewReader(benchmarkNDJSON), FormatNDJSON, callback)
+				}
+				if err := aggregator.ProcessParallel(source, ParallelConfig{Workers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// unknownHeavySource streams n synthetic records without holding them in memory;
+// three quarters of the clicks are on unknown bitlinks, most of them distinct
+func unknownHeavySource(n int) RecordSource {
+	pool := syntheticRecords(10000)
+	for i := 1; i < len(pool); i += 2 {
+		pool[i].Bitlink = fmt.Sprintf("http://bit.ly/unmapped%d", i)
+	}
+	return func(callback func(DecodeRecord) error) error {
+		for i := 0; i < n; i++ {
+			if err := callback(pool[i%len(pool)]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// BenchmarkUnknownBitlinks10M aggregates 10M records, mostly on unknown bitlinks, and
+// builds the final summary; both should stay linear in the number of records
+func BenchmarkUnknownBitlinks10M(b *testing.B) {
+	const records = 10000000
+	source := unknownHeavySource(records)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		aggregator := NewAggregator(syntheticMapping(), AggregationConfig{})
+		if err := source(aggregator.ProcessRecord); err != nil {
+			b.Fatal(err)
+		}
+		if urls := aggregator.GetSortedURLs(true); len(urls) != 7 {
+			b.Fatalf("Expected 7 mapped URLs in the final summary, got %d", len(urls))
+		}
+	}
+	b.ReportMetric(float64(records)*float64(b.N)/b.Elapsed().Seconds(), "records/s")
+}
@@ -0,0 +1,327 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dimension is an attribute clicks can be grouped by in a pivot table
+// Every dimension is also a -where string field of the same name (see filterFields).
+type Dimension string
+
+const (
+	DimensionURL          Dimension = "url"           // Long URL (the bitlink for unknown bitlinks)
+	DimensionBitlink      Dimension = "bitlink"       // Short link as clicked
+	DimensionDomain       Dimension = "domain"        // Short link domain, e.g. bit.ly
+	DimensionReferrer     Dimension = "referrer"      // Referrer as logged
+	DimensionReferrerHost Dimension = "referrer_host" // Canonical referrer host
+	DimensionChannel      Dimension = "channel"       // Referrer channel
+	DimensionDate         Dimension = "date"          // Time bucket at the configured granularity
+	DimensionBrowser      Dimension = "browser"
+	DimensionOS           Dimension = "os"
+	DimensionDevice       Dimension = "device"
+	DimensionCountry      Dimension = "country" // Geo dimensions need a GeoIP database
+	DimensionRegion       Dimension = "region"
+	DimensionCity         Dimension = "city"
+)
+
+// Dimensions lists every dimension in the order they are documented
+var Dimensions = []Dimension{
+	DimensionURL, DimensionBitlink, DimensionDomain, DimensionReferrer, DimensionReferrerHost, DimensionChannel,
+	DimensionDate, DimensionBrowser, DimensionOS, DimensionDevice, DimensionCountry, DimensionRegion, DimensionCity,
+}
+
+// IsGeo reports whether the dimension needs a GeoIP database
+func (d Dimension) IsGeo() bool {
+	return d == DimensionCountry || d == DimensionRegion || d == DimensionCity
+}
+
+// ParseDimensions parses a comma-separated list of dimensions, e.g. "url,referrer,date"
+func ParseDimensions(value string) ([]Dimension, error) {
+	var dimensions []Dimension
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		dimension, known := Dimension(name), false
+		for _, d := range Dimensions {
+			known = known || d == dimension
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown dimension %q (expected one of %s)", name, joinDimensions(Dimensions, ", "))
+		}
+		for _, d := range dimensions {
+			if d == dimension {
+				return nil, fmt.Errorf("dimension %q listed twice", name)
+			}
+		}
+		dimensions = append(dimensions, dimension)
+	}
+	return dimensions, nil
+}
+
+// joinDimensions joins dimension names with sep
+func joinDimensions(dimensions []Dimension, sep string) string {
+	names := make([]string, len(dimensions))
+	for i, d := range dimensions {
+		names[i] = string(d)
+	}
+	return strings.Join(names, sep)
+}
+
+// pivotClick is everything known about a counted click, for extracting dimension values
+type pivotClick struct {
+	record   *DecodeRecord
+	longURL  string
+	bucket   string
+	referrer NormalizedReferrer
+	agent    UserAgent
+	location GeoLocation
+}
+
+// value returns the click's value for a dimension
+func (c pivotClick) value(d Dimension) string {
+	switch d {
+	case DimensionURL:
+		return c.longURL
+	case DimensionBitlink:
+		return c.record.Bitlink
+	case DimensionDomain:
+		return bitlinkDomain(c.record.Bitlink)
+	case DimensionReferrer:
+		return c.record.Referrer
+	case DimensionReferrerHost:
+		return c.referrer.Host
+	case DimensionChannel:
+		return c.referrer.Channel.String()
+	case DimensionDate:
+		return c.bucket
+	case DimensionBrowser:
+		return c.agent.Browser
+	case DimensionOS:
+		return c.agent.OS
+	case DimensionDevice:
+		return c.agent.Device.String()
+	case DimensionCountry:
+		return c.location.Country
+	case DimensionRegion:
+		return c.location.Region
+	case DimensionCity:
+		return c.location.City
+	}
+	return ""
+}
+
+// bitlinkDomain returns the host of a short link, e.g. bit.ly for http://bit.ly/2kkAHNs
+func bitlinkDomain(bitlink string) string {
+	if _, rest, found := strings.Cut(bitlink, "://"); found {
+		bitlink = rest
+	}
+	host, _, _ := strings.Cut(bitlink, "/")
+	return strings.ToLower(host)
+}
+
+// PivotTable counts clicks per combination of dimension values (a group-by or cross-tab)
+type PivotTable struct {
+	Dimensions []Dimension
+	cells      map[string]int // Keyed by pivotKey of the values
+}
+
+// NewPivotTable creates an empty table grouped by the dimensions, in order
+func NewPivotTable(dimensions []Dimension) *PivotTable {
+	return &PivotTable{Dimensions: dimensions, cells: make(map[string]int)}
+}
+
+// Add counts clicks for one combination of values, given in dimension order
+func (t *PivotTable) Add(values []string, clicks int) {
+	t.cells[pivotKey(values)] += clicks
+}
+
+// pivotKey encodes values as one map key, each prefixed with its length, so a
+// value may hold any byte (including a NUL decoded from "\u0000")
+func pivotKey(values []string) string {
+	var key strings.Builder
+	for _, value := range values {
+		key.WriteString(strconv.Itoa(len(value)))
+		key.WriteByte(':')
+		key.WriteString(value)
+	}
+	return key.String()
+}
+
+// pivotValues decodes a key built by pivotKey
+func pivotValues(key string) []string {
+	var values []string
+	for key != "" {
+		length, rest, _ := strings.Cut(key, ":")
+		n, _ := strconv.Atoi(length)
+		values = append(values, rest[:n])
+		key = rest[n:]
+	}
+	return values
+}
+
+// Len returns the number of distinct value combinations
+func (t *PivotTable) Len() int {
+	return len(t.cells)
+}
+
+// Merge adds every cell of another table with the same dimensions
+func (t *PivotTable) Merge(other *PivotTable) {
+	mergeCountMap(t.cells, other.cells)
+}
+
+// PivotSort selects how pivot rows are ordered
+type PivotSort int
+
+const (
+	PivotSortClicks PivotSort = iota // By clicks, ties by values
+	PivotSortValues                  // By values, dimension by dimension
+)
+
+// ParsePivotSort converts a flag value into a PivotSort
+func ParsePivotSort(value string) (PivotSort, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "clicks":
+		return PivotSortClicks, nil
+	case "values", "keys":
+		return PivotSortValues, nil
+	}
+	return PivotSortClicks, fmt.Errorf("unknown pivot sort %q (expected clicks or values)", value)
+}
+
+// PivotOptions control the order and size of pivot output
+type PivotOptions struct {
+	Sort       PivotSort
+	Descending bool
+	Limit      int // Maximum rows, or children per node when nested (0 means no limit)
+}
+
+// PivotRow is one flattened pivot row
+type PivotRow struct {
+	Values []string `json:"values"` // In the table's dimension order
+	Clicks int      `json:"clicks"`
+}
+
+// Rows returns the flattened table, sorted and limited
+func (t *PivotTable) Rows(options PivotOptions) []PivotRow {
+	rows := make([]PivotRow, 0, len(t.cells))
+	for key, clicks := range t.cells {
+		rows = append(rows, PivotRow{Values: pivotValues(key), Clicks: clicks})
+	}
+	sortPivot(rows, options, func(r PivotRow) ([]string, int) { return r.Values, r.Clicks })
+	return limitPivot(rows, options.Limit)
+}
+
+// PivotNode is one value of a dimension in the nested table, with its total clicks
+// and its breakdown by the next dimension
+type PivotNode struct {
+	Dimension Dimension    `json:"dimension"`
+	Value     string       `json:"value"`
+	Clicks    int          `json:"clicks"`
+	Children  []*PivotNode `json:"children,omitempty"`
+}
+
+// Nested returns the table as a tree, one level per dimension; every level is
+// sorted and limited independently
+func (t *PivotTable) Nested(options PivotOptions) []*PivotNode {
+	return nestRows(t.Rows(PivotOptions{}), t.Dimensions, 0, options)
+}
+
+// nestRows groups rows by the value at depth and recurses into the next dimension
+func nestRows(rows []PivotRow, dimensions []Dimension, depth int, options PivotOptions) []*PivotNode {
+	if depth >= len(dimensions) {
+		return nil
+	}
+
+	groups := make(map[string][]PivotRow)
+	var nodes []*PivotNode
+	for _, row := range rows {
+		value := row.Values[depth]
+		if _, ok := groups[value]; !ok {
+			nodes = append(nodes, &PivotNode{Dimension: dimensions[depth], Value: value})
+		}
+		groups[value] = append(groups[value], row)
+	}
+	for _, node := range nodes {
+		for _, row := range groups[node.Value] {
+			node.Clicks += row.Clicks
+		}
+	}
+
+	sortPivot(nodes, options, func(n *PivotNode) ([]string, int) { return []string{n.Value}, n.Clicks })
+	nodes = limitPivot(nodes, options.Limit)
+	for _, node := range nodes {
+		node.Children = nestRows(groups[node.Value], dimensions, depth+1, options)
+	}
+	return nodes
+}
+
+// sortPivot orders rows or nodes by clicks or values; ties fall back to the values
+// so output is stable across runs
+func sortPivot[T any](items []T, options PivotOptions, key func(T) ([]string, int)) {
+	sort.Slice(items, func(i, j int) bool {
+		valuesI, clicksI := key(items[i])
+		valuesJ, clicksJ := key(items[j])
+		if options.Sort == PivotSortClicks && clicksI != clicksJ {
+			if options.Descending {
+				return clicksI > clicksJ
+			}
+			return clicksI < clicksJ
+		}
+		if c := compareValues(valuesI, valuesJ); c != 0 {
+			if options.Sort == PivotSortValues && options.Descending {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// compareValues compares value lists dimension by dimension
+func compareValues(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// limitPivot keeps the first limit items (all of them when limit is 0)
+func limitPivot[T any](items []T, limit int) []T {
+	if limit > 0 && len(items) > limit {
+		return items[:limit]
+	}
+	return items
+}
+
+// WritePivotJSON exports the table as JSON, flattened or nested:
+//
+//	{"dimensions": ["url", "referrer"], "rows": [{"values": ["https://google.com/", "t.co"], "clicks": 42}]}
+//	{"dimensions": ["url", "referrer"], "tree": [{"dimension": "url", "value": "https://google.com/", "clicks": 42, "children": [...]}]}
+func WritePivotJSON(w io.Writer, table *PivotTable, options PivotOptions, nested bool) error {
+	output := struct {
+		Dimensions []Dimension  `json:"dimensions"`
+		Rows       []PivotRow   `json:"rows,omitempty"`
+		Tree       []*PivotNode `json:"tree,omitempty"`
+	}{Dimensions: table.Dimensions}
+	if nested {
+		output.Tree = table.Nested(options)
+	} else {
+		output.Rows = table.Rows(options)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(output); err != nil {
+		return fmt.Errorf("error writing pivot table: %w", err)
+	}
+	return nil
+}
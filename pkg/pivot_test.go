@@ -0,0 +1,205 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseDimensions(t *testing.T) {
+	dimensions, err := ParseDimensions(" url, Referrer_Host ,date,")
+	if err != nil {
+		t.Fatalf("ParseDimensions failed: %v", err)
+	}
+	expected := []Dimension{DimensionURL, DimensionReferrerHost, DimensionDate}
+	if !reflect.DeepEqual(dimensions, expected) {
+		t.Errorf("Expected %v, got %v", expected, dimensions)
+	}
+
+	if dimensions, err := ParseDimensions(""); err != nil || len(dimensions) != 0 {
+		t.Errorf("Expected no dimensions for an empty list, got %v (err %v)", dimensions, err)
+	}
+	for _, invalid := range []string{"url,weekday", "url,url", "referrer-host"} {
+		if _, err := ParseDimensions(invalid); err == nil {
+			t.Errorf("Expected error for %q, got nil", invalid)
+		}
+	}
+}
+
+// samplePivot is a url × referrer table with a tie between two rows
+func samplePivot() *PivotTable {
+	table := NewPivotTable([]Dimension{DimensionURL, DimensionReferrer})
+	table.Add([]string{"https://a.com/", "t.co"}, 5)
+	table.Add([]string{"https://a.com/", "direct"}, 2)
+	table.Add([]string{"https://b.com/", "t.co"}, 2)
+	table.Add([]string{"https://b.com/", "direct"}, 1)
+	table.Add([]string{"https://a.com/", "t.co"}, 1)
+	return table
+}
+
+func TestPivotTable_Rows(t *testing.T) {
+	table := samplePivot()
+
+	cases := []struct {
+		options  PivotOptions
+		expected []PivotRow
+	}{
+		{
+			PivotOptions{Descending: true, Limit: 3},
+			[]PivotRow{
+				{[]string{"https://a.com/", "t.co"}, 6},
+				{[]string{"https://a.com/", "direct"}, 2}, // Ties ordered by values
+				{[]string{"https://b.com/", "t.co"}, 2},
+			},
+		},
+		{
+			PivotOptions{Sort: PivotSortValues, Descending: true},
+			[]PivotRow{
+				{[]string{"https://b.com/", "t.co"}, 2},
+				{[]string{"https://b.com/", "direct"}, 1},
+				{[]string{"https://a.com/", "t.co"}, 6},
+				{[]string{"https://a.com/", "direct"}, 2},
+			},
+		},
+		{
+			PivotOptions{Limit: 1},
+			[]PivotRow{{[]string{"https://b.com/", "direct"}, 1}},
+		},
+	}
+	for _, c := range cases {
+		if rows := table.Rows(c.options); !reflect.DeepEqual(rows, c.expected) {
+			t.Errorf("Rows(%+v): expected %v, got %v", c.options, c.expected, rows)
+		}
+	}
+}
+
+func TestPivotTable_Nested(t *testing.T) {
+	nodes := samplePivot().Nested(PivotOptions{Descending: true, Limit: 1})
+
+	expected := []*PivotNode{{
+		Dimension: DimensionURL, Value: "https://a.com/", Clicks: 8,
+		Children: []*PivotNode{{Dimension: DimensionReferrer, Value: "t.co", Clicks: 6}},
+	}}
+	if !reflect.DeepEqual(nodes, expected) {
+		got, _ := json.Marshal(nodes)
+		t.Errorf("Unexpected nested table: %s", got)
+	}
+}
+
+func TestPivotTable_Merge(t *testing.T) {
+	table := samplePivot()
+	table.Merge(samplePivot())
+
+	if table.Len() != 4 {
+		t.Errorf("Expected 4 cells, got %d", table.Len())
+	}
+	if rows := table.Rows(PivotOptions{Descending: true, Limit: 1}); rows[0].Clicks != 12 {
+		t.Errorf("Expected merged top cell of 12 clicks, got %v", rows[0])
+	}
+}
+
+func TestWritePivotJSON(t *testing.T) {
+	var flat, nested bytes.Buffer
+	if err := WritePivotJSON(&flat, samplePivot(), PivotOptions{Descending: true, Limit: 1}, false); err != nil {
+		t.Fatalf("WritePivotJSON failed: %v", err)
+	}
+	if err := WritePivotJSON(&nested, samplePivot(), PivotOptions{Descending: true, Limit: 1}, true); err != nil {
+		t.Fatalf("WritePivotJSON (nested) failed: %v", err)
+	}
+
+	var output struct {
+		Dimensions []string
+		Rows       []PivotRow
+		Tree       []*PivotNode
+	}
+	if err := json.Unmarshal(flat.Bytes(), &output); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(output.Dimensions, []string{"url", "referrer"}) || len(output.Rows) != 1 || output.Tree != nil {
+		t.Errorf("Unexpected flat output: %s", flat.String())
+	}
+	output.Rows = nil
+	if err := json.Unmarshal(nested.Bytes(), &output); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(output.Tree) != 1 || output.Rows != nil || output.Tree[0].Children[0].Value != "t.co" {
+		t.Errorf("Unexpected nested output: %s", nested.String())
+	}
+}
+
+func TestAggregator_GroupBy(t *testing.T) {
+	mapping := URLMapping{"http://bit.ly/a": "https://a.com/"}
+	config := AggregationConfig{
+		Granularity: GranularityMonth,
+		GroupBy:     []Dimension{DimensionURL, DimensionDomain, DimensionChannel, DimensionDate, DimensionCountry},
+	}
+	aggregator := NewAggregator(mapping, config)
+	records := []DecodeRecord{
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T00:00:00Z", Referrer: "t.co"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-31T00:00:00Z", Referrer: "twitter.com"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-02-01T00:00:00Z", Referrer: "direct"},
+		{Bitlink: "https://es.pn/x", Timestamp: "2021-02-01T00:00:00Z", Referrer: "direct"},
+	}
+	for _, record := range records {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	expected := []PivotRow{
+		{[]string{"https://a.com/", "bit.ly", "direct", "2021-02", GeoUnknown}, 1},
+		{[]string{"https://es.pn/x", "es.pn", "direct", "2021-02", GeoUnknown}, 1},
+		{[]string{"https://a.com/", "bit.ly", "social", "2021-01", GeoUnknown}, 2},
+	}
+	if rows := aggregator.GetResults().Pivot.Rows(PivotOptions{}); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Expected %v, got %v", expected, rows)
+	}
+	if NewAggregator(mapping, AggregationConfig{}).GetResults().Pivot != nil {
+		t.Error("Expected no pivot table without GroupBy")
+	}
+}
+
+// Values are stored whole, so bytes such as a NUL from "\u0000" cannot split a cell
+func TestAggregator_GroupByNULInReferrer(t *testing.T) {
+	mapping := URLMapping{"http://bit.ly/a": "https://a.com/"}
+	aggregator := NewAggregator(mapping, AggregationConfig{GroupBy: []Dimension{DimensionReferrer, DimensionURL}})
+
+	var records []DecodeRecord
+	input := `[{"bitlink": "http://bit.ly/a", "timestamp": "2021-01-01T00:00:00Z", "referrer": "t.co\u0000x"},
+		{"bitlink": "http://bit.ly/a", "timestamp": "2021-01-01T00:00:00Z", "referrer": "\u0000"}]`
+	if err := json.Unmarshal([]byte(input), &records); err != nil {
+		t.Fatalf("Failed to decode records: %v", err)
+	}
+	for _, record := range records {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	expected := []PivotRow{
+		{[]string{"\x00", "https://a.com/"}, 1},
+		{[]string{"t.co\x00x", "https://a.com/"}, 1},
+	}
+	if rows := aggregator.GetResults().Pivot.Rows(PivotOptions{}); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Expected %q, got %q", expected, rows)
+	}
+}
+
+func TestAggregator_GroupByParallelMatchesSerial(t *testing.T) {
+	records := syntheticRecords(10007)
+	config := AggregationConfig{GroupBy: []Dimension{DimensionURL, DimensionReferrer, DimensionDate}}
+
+	serial := NewAggregator(syntheticMapping(), config)
+	for _, record := range records {
+		serial.ProcessRecord(record)
+	}
+	parallel := NewAggregator(syntheticMapping(), config)
+	if err := parallel.ProcessParallel(sliceSource(records), ParallelConfig{Workers: 3, BatchSize: 100}); err != nil {
+		t.Fatalf("ProcessParallel failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(serial.GetResults().Pivot, parallel.GetResults().Pivot) {
+		t.Error("Parallel pivot table differs from serial table")
+	}
+}
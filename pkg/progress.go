@@ -0,0 +1,172 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Defaults for StreamOptions
+const (
+	defaultProgressInterval = 500 * time.Millisecond
+	progressCheckEvery      = 1024 // Records between clock reads when progress is enabled
+)
+
+// Progress is a snapshot of streaming progress
+type Progress struct {
+	File          string        // Input currently being read ("" for io.Reader sources)
+	BytesRead     int64         // Raw (possibly compressed) bytes read across all inputs
+	Records       int           // Records decoded so far
+	Elapsed       time.Duration // Time since streaming started
+	RecordsPerSec float64       // Average decode rate since streaming started
+	Done          bool          // True for the final report
+}
+
+// StreamOptions configures the context-aware streaming readers
+type StreamOptions struct {
+	Format           DecodeFormat
+	Progress         func(Progress) // Called periodically and once when streaming ends; may be nil
+	ProgressInterval time.Duration  // Minimum time between progress reports (0 means 500ms)
+	Errors           *ErrorPolicy   // Handles bad records; nil stops at the first one
+}
+
+// progressTracker counts bytes and records and throttles progress callbacks
+type progressTracker struct {
+	options   StreamOptions
+	file      string
+	bytesRead int64
+	records   int
+	started   time.Time
+	lastSent  time.Time
+}
+
+func newProgressTracker(options StreamOptions) *progressTracker {
+	if options.ProgressInterval <= 0 {
+		options.ProgressInterval = defaultProgressInterval
+	}
+	now := time.Now()
+	return &progressTracker{options: options, started: now, lastSent: now}
+}
+
+// snapshot builds a Progress value from the current counters
+func (t *progressTracker) snapshot(done bool) Progress {
+	elapsed := time.Since(t.started)
+	progress := Progress{
+		File:      t.file,
+		BytesRead: t.bytesRead,
+		Records:   t.records,
+		Elapsed:   elapsed,
+		Done:      done,
+	}
+	if elapsed > 0 {
+		progress.RecordsPerSec = float64(t.records) / elapsed.Seconds()
+	}
+	return progress
+}
+
+// recordDecoded counts a record and reports progress when the interval has elapsed
+func (t *progressTracker) recordDecoded() {
+	t.records++
+	if t.options.Progress == nil || t.records%progressCheckEvery != 0 {
+		return
+	}
+	if time.Since(t.lastSent) >= t.options.ProgressInterval {
+		t.lastSent = time.Now()
+		t.options.Progress(t.snapshot(false))
+	}
+}
+
+// finish sends the final progress report
+func (t *progressTracker) finish() {
+	if t.options.Progress != nil {
+		t.options.Progress(t.snapshot(true))
+	}
+}
+
+// wrap returns a callback that honours cancellation and counts records
+func (t *progressTracker) wrap(ctx context.Context, callback func(DecodeRecord) error) func(DecodeRecord) error {
+	done := ctx.Done()
+	return func(record DecodeRecord) error {
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
+		}
+		t.recordDecoded()
+		return callback(record)
+	}
+}
+
+// countingReader tallies bytes read into the tracker
+type countingReader struct {
+	reader  io.Reader
+	tracker *progressTracker
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.tracker.bytesRead += int64(n)
+	return n, err
+}
+
+// StreamDecodesContext streams decode records from r until the input ends or ctx is done
+// Cancellation is checked before each record, so a cancelled stream stops cleanly
+// after the record in flight; the returned error then wraps ctx.Err().
+func StreamDecodesContext(ctx context.Context, r io.Reader, options StreamOptions, callback func(DecodeRecord) error) error {
+	tracker := newProgressTracker(options)
+	defer tracker.finish()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	input := &countingReader{reader: r, tracker: tracker}
+	return streamDecodesNamed(input, "", options.Format, options.Errors, tracker.wrap(ctx, callback))
+}
+
+// StreamDecodesFilesContext is StreamDecodesFiles with cancellation and progress reporting
+// Progress byte counts cover the raw file contents across every input file.
+func StreamDecodesFilesContext(ctx context.Context, patterns []string, options StreamOptions, callback func(DecodeRecord) error) ([]SourceFile, error) {
+	paths, err := ExpandInputPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := newProgressTracker(options)
+	defer tracker.finish()
+
+	var source *SourceFile
+	guarded := tracker.wrap(ctx, func(record DecodeRecord) error {
+		source.Records++
+		return callback(record)
+	})
+
+	sources := make([]SourceFile, 0, len(paths))
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return sources, err
+		}
+
+		tracker.file = path
+		source = &SourceFile{Path: path}
+		err := streamDecodesFile(path, tracker, options, guarded)
+		sources = append(sources, *source)
+		if err != nil {
+			return sources, fmt.Errorf("error streaming %s: %w", path, err)
+		}
+	}
+
+	return sources, nil
+}
+
+// streamDecodesFile opens one decodes file and streams it through a byte counter
+func streamDecodesFile(path string, tracker *progressTracker, options StreamOptions, callback func(DecodeRecord) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening decodes file: %w", err)
+	}
+	defer file.Close()
+
+	return streamDecodesNamed(&countingReader{reader: file, tracker: tracker}, path, options.Format, options.Errors, callback)
+}
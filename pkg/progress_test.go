@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ndjsonLines renders n minimal NDJSON records
+func ndjsonLines(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `{"bitlink": "http://bit.ly/%d", "timestamp": "2020-01-01T00:00:00Z"}`+"\n", i)
+	}
+	return sb.String()
+}
+
+func TestStreamDecodesContext_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	records := 0
+	err := StreamDecodesContext(ctx, strings.NewReader(ndjsonLines(100)), StreamOptions{}, func(record DecodeRecord) error {
+		records++
+		if records == 10 {
+			cancel()
+		}
+		return nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if records != 10 {
+		t.Errorf("Expected streaming to stop after 10 records, got %d", records)
+	}
+}
+
+func TestStreamDecodesContext_Deadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := StreamDecodesContext(ctx, strings.NewReader(ndjsonLines(5)), StreamOptions{}, func(record DecodeRecord) error {
+		t.Error("Callback should not run after the deadline")
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestStreamDecodesContext_Progress(t *testing.T) {
+	input := ndjsonLines(3000)
+
+	var reports []Progress
+	options := StreamOptions{
+		Format:           FormatNDJSON,
+		ProgressInterval: time.Nanosecond, // Report at every check
+		Progress: func(progress Progress) {
+			reports = append(reports, progress)
+		},
+	}
+	err := StreamDecodesContext(context.Background(), strings.NewReader(input), options, func(record DecodeRecord) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamDecodesContext failed: %v", err)
+	}
+
+	// Two periodic reports (at 1024 and 2048 records) plus the final one
+	if len(reports) != 3 {
+		t.Fatalf("Expected 3 progress reports, got %d: %+v", len(reports), reports)
+	}
+	if reports[0].Records != 1024 || reports[0].Done {
+		t.Errorf("Unexpected first report: %+v", reports[0])
+	}
+
+	final := reports[len(reports)-1]
+	if !final.Done || final.Records != 3000 || final.BytesRead != int64(len(input)) {
+		t.Errorf("Unexpected final report: %+v (input is %d bytes)", final, len(input))
+	}
+	if final.RecordsPerSec <= 0 {
+		t.Errorf("Expected a positive records/sec rate, got %f", final.RecordsPerSec)
+	}
+}
+
+func TestStreamDecodesFilesContext_CancelBetweenFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a.ndjson")
+	for _, name := range []string{"a.ndjson", "b.ndjson"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(ndjsonLines(5)), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var final Progress
+	options := StreamOptions{Progress: func(progress Progress) { final = progress }}
+	sources, err := StreamDecodesFilesContext(ctx, []string{filepath.Join(dir, "*.ndjson")}, options, func(record DecodeRecord) error {
+		if record.Bitlink == "http://bit.ly/4" {
+			cancel()
+		}
+		return nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if len(sources) != 1 || sources[0].Records != 5 {
+		t.Errorf("Expected only the first file to be read, got %+v", sources)
+	}
+	if !final.Done || final.Records != 5 || final.File != first {
+		t.Errorf("Unexpected final progress: %+v", final)
+	}
+}
+
+func TestAggregator_ProcessParallel_CancelKeepsPartialResults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	delivered := 0
+	source := func(callback func(DecodeRecord) error) error {
+		return StreamDecodesContext(ctx, strings.NewReader(ndjsonLines(500)), StreamOptions{}, func(record DecodeRecord) error {
+			delivered++
+			if delivered == 250 {
+				cancel()
+			}
+			return callback(record)
+		})
+	}
+
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{})
+	err := aggregator.ProcessParallel(source, ParallelConfig{Workers: 3, BatchSize: 16})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if processed := aggregator.GetResults().ProcessedRecords; processed != 250 {
+		t.Errorf("Expected 250 partially processed records, got %d", processed)
+	}
+}
@@ -1,11 +1,17 @@
 package pkg
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // DecodeRecord represents a click event from the decodes.json file
@@ -15,6 +21,39 @@ type DecodeRecord struct {
 	Timestamp string `json:"timestamp"`
 	Referrer  string `json:"referrer"`
 	RemoteIP  string `json:"remote_ip"`
+
+	// Where the record was read from; set in the lenient error modes so records that
+	// are rejected after leaving the decoder (e.g. in ProcessParallel) keep it
+	position *RecordError
+}
+
+// UnmarshalJSON decodes a record, accepting the timestamp as a JSON string or number
+// Numeric timestamps (Unix epoch seconds or milliseconds) are kept as their literal text
+func (r *DecodeRecord) UnmarshalJSON(data []byte) error {
+	type plainRecord DecodeRecord // Same fields without this method, avoiding recursion
+	var aux struct {
+		plainRecord
+		Timestamp json.RawMessage `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	*r = DecodeRecord(aux.plainRecord)
+	raw := bytes.TrimSpace(aux.Timestamp)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		r.Timestamp = ""
+	case raw[0] == '"':
+		if err := json.Unmarshal(raw, &r.Timestamp); err != nil {
+			return fmt.Errorf("invalid timestamp: %w", err)
+		}
+	case raw[0] == '-' || (raw[0] >= '0' && raw[0] <= '9'):
+		r.Timestamp = string(raw)
+	default:
+		return fmt.Errorf("invalid timestamp %s: expected a string or number", raw)
+	}
+	return nil
 }
 
 // EncodeRecord represents a URL mapping from the encodes.csv file
@@ -28,6 +67,7 @@ type EncodeRecord struct {
 type URLMapping map[string]string
 
 // ReadEncodesMappings reads the CSV file and creates a hash map for O(1) lookups
+// gzip, bzip2 and zstd compressed files are decompressed transparently
 func ReadEncodesMappings(filename string) (URLMapping, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -35,7 +75,25 @@ func ReadEncodesMappings(filename string) (URLMapping, error) {
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
+	return readEncodesMappings(file, filename)
+}
+
+// ReadEncodesMappingsFrom reads encodes CSV data from any io.Reader (stdin, memory, embedded files)
+// Compression is detected from magic bytes only, since there is no filename to inspect
+func ReadEncodesMappingsFrom(r io.Reader) (URLMapping, error) {
+	return readEncodesMappings(r, "")
+}
+
+// readEncodesMappings decompresses and parses encodes CSV data
+// The filename, when known, is used as a fallback for compression detection
+func readEncodesMappings(r io.Reader, filename string) (URLMapping, error) {
+	input, closeInput, err := decompressReader(r, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+
+	reader := csv.NewReader(input)
 	records, err := reader.ReadAll()
 	if err != nil {
 		return nil, fmt.Errorf("error reading CSV: %w", err)
@@ -58,16 +116,219 @@ func ReadEncodesMappings(filename string) (URLMapping, error) {
 	return mapping, nil
 }
 
+// DecodeFormat selects how the decodes input is interpreted
+type DecodeFormat int
+
+const (
+	FormatAuto      DecodeFormat = iota // Detect array vs NDJSON from the first non-whitespace byte
+	FormatJSONArray                     // A single top-level JSON array of records
+	FormatNDJSON                        // One JSON record per line (newline-delimited JSON)
+)
+
+// String returns the flag-friendly name of the format
+func (f DecodeFormat) String() string {
+	switch f {
+	case FormatJSONArray:
+		return "array"
+	case FormatNDJSON:
+		return "ndjson"
+	default:
+		return "auto"
+	}
+}
+
+// ParseDecodeFormat converts a flag value (auto, array, ndjson) into a DecodeFormat
+func ParseDecodeFormat(value string) (DecodeFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "auto":
+		return FormatAuto, nil
+	case "array", "json":
+		return FormatJSONArray, nil
+	case "ndjson", "jsonl":
+		return FormatNDJSON, nil
+	}
+	return FormatAuto, fmt.Errorf("unknown decodes format %q (expected auto, array or ndjson)", value)
+}
+
 // StreamDecodes processes the JSON file using streaming decoder
 // The callback function is called for each decode record as it's read
+// The file must contain a single top-level JSON array; use StreamDecodesFormat for NDJSON
 func StreamDecodes(filename string, callback func(DecodeRecord) error) error {
+	return StreamDecodesFormat(filename, FormatJSONArray, callback)
+}
+
+// StreamDecodesFormat streams decode records from a JSON array or NDJSON file
+// With FormatAuto the format is detected from the first non-whitespace byte:
+// '[' selects a JSON array, anything else is read as one record per line
+// gzip, bzip2 and zstd compressed files are decompressed while streaming
+func StreamDecodesFormat(filename string, format DecodeFormat, callback func(DecodeRecord) error) error {
 	file, err := os.Open(filename)
 	if err != nil {
 		return fmt.Errorf("error opening decodes file: %w", err)
 	}
 	defer file.Close()
 
-	decoder := json.NewDecoder(file)
+	return streamDecodesNamed(file, filename, format, nil, callback)
+}
+
+// StreamDecodesFrom streams decode records from any io.Reader (stdin, memory, embedded files)
+// It accepts the same formats and compression schemes as StreamDecodesFormat,
+// detecting compression from magic bytes only
+func StreamDecodesFrom(r io.Reader, format DecodeFormat, callback func(DecodeRecord) error) error {
+	return streamDecodesNamed(r, "", format, nil, callback)
+}
+
+// SourceFile records the provenance of one decodes input file
+type SourceFile struct {
+	Path    string `json:"path"`    // Path as read (glob patterns are expanded)
+	Records int    `json:"records"` // Number of records decoded from this file
+}
+
+// ExpandInputPatterns expands glob patterns into an ordered list of file paths
+// Patterns are processed in the order given and each glob's matches are sorted lexically,
+// so date-stamped shards (decodes-2021-01-01.json, ...) are read chronologically.
+// Plain paths are passed through unchanged; a glob matching nothing is an error.
+// Paths matched by more than one pattern are only returned once.
+func ExpandInputPatterns(patterns []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if hasGlobMeta(pattern) {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid input pattern %q: %w", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match input pattern %q", pattern)
+			}
+		}
+
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				paths = append(paths, match)
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// hasGlobMeta reports whether a path contains glob metacharacters
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// StreamDecodesFiles streams every file matched by the given paths or globs, in order,
+// into a single callback. It returns per-file record counts for the files that were read,
+// including the partially-read file when an error stops the stream.
+func StreamDecodesFiles(patterns []string, format DecodeFormat, callback func(DecodeRecord) error) ([]SourceFile, error) {
+	return StreamDecodesFilesContext(context.Background(), patterns, StreamOptions{Format: format}, callback)
+}
+
+// streamDecodesNamed decompresses the input and streams its records
+// The filename, when known, is used as a fallback for compression detection
+// and to locate rejected records. A nil policy fails on the first bad record.
+func streamDecodesNamed(r io.Reader, filename string, format DecodeFormat, policy *ErrorPolicy, callback func(DecodeRecord) error) error {
+	input, closeInput, err := decompressReader(r, filename)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+
+	return streamDecodes(input, format, recordStream{file: filename, errors: policy, callback: callback})
+}
+
+// recordStream is what the format decoders deliver records to
+type recordStream struct {
+	file     string       // Input name recorded on rejected records
+	errors   *ErrorPolicy // nil fails on the first bad record
+	callback func(DecodeRecord) error
+}
+
+// reject hands a record that failed to decode to the error policy
+// It returns nil when the stream should carry on without it.
+func (s recordStream) reject(position RecordError, category string, err error) error {
+	position.Category, position.Err = category, err
+	return s.errors.Reject(&position)
+}
+
+// deliver passes a record to the callback; in a lenient mode, a *RecordError from
+// the callback is completed with the record's position and handed to the policy
+func (s recordStream) deliver(record DecodeRecord, position RecordError) error {
+	if s.errors.lenient() {
+		record.position = &position
+	}
+	err := s.callback(record)
+	var rejected *RecordError
+	if err == nil || !s.errors.lenient() || !errors.As(err, &rejected) {
+		return err
+	}
+	rejected.setPosition(&position)
+	return s.errors.Reject(rejected)
+}
+
+// streamDecodes dispatches to the array or NDJSON decoder for the given format
+func streamDecodes(r io.Reader, format DecodeFormat, stream recordStream) error {
+	reader := bufio.NewReader(r)
+
+	// Input consumed while skipping leading whitespace, so line numbers and offsets stay accurate
+	skippedLines, skippedBytes := 0, int64(0)
+	if format == FormatAuto {
+		detected, lines, skipped, err := detectDecodeFormat(reader)
+		if err != nil {
+			return err
+		}
+		format, skippedLines, skippedBytes = detected, lines, skipped
+	}
+
+	if format == FormatNDJSON {
+		return streamNDJSON(reader, skippedLines, skippedBytes, stream)
+	}
+	return streamJSONArray(reader, skippedBytes, stream)
+}
+
+// detectDecodeFormat skips leading whitespace and inspects the first significant byte
+// It returns the detected format and the number of newlines and bytes skipped
+func detectDecodeFormat(reader *bufio.Reader) (DecodeFormat, int, int64, error) {
+	lines, skipped := 0, int64(0)
+	for {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			// Empty input is a valid (empty) NDJSON stream
+			return FormatNDJSON, lines, skipped, nil
+		}
+		if err != nil {
+			return FormatAuto, lines, skipped, fmt.Errorf("error detecting decodes format: %w", err)
+		}
+
+		switch b {
+		case ' ', '\t', '\r':
+			skipped++
+			continue
+		case '\n':
+			lines++
+			skipped++
+			continue
+		}
+
+		if err := reader.UnreadByte(); err != nil {
+			return FormatAuto, lines, skipped, fmt.Errorf("error detecting decodes format: %w", err)
+		}
+		if b == '[' {
+			return FormatJSONArray, lines, skipped, nil
+		}
+		return FormatNDJSON, lines, skipped, nil
+	}
+}
+
+// streamJSONArray decodes records from a single top-level JSON array
+// byteOffset is the number of input bytes consumed before r
+func streamJSONArray(r io.Reader, byteOffset int64, stream recordStream) error {
+	decoder := json.NewDecoder(r)
 
 	// Read the opening bracket of the JSON array
 	token, err := decoder.Token()
@@ -82,12 +343,26 @@ func StreamDecodes(filename string, callback func(DecodeRecord) error) error {
 	recordCount := 0
 	for decoder.More() {
 		var record DecodeRecord
-		if err := decoder.Decode(&record); err != nil {
-			return fmt.Errorf("error decoding record %d: %w", recordCount, err)
+		position := RecordError{File: stream.file, Record: recordCount, Offset: -1}
+		if stream.errors.lenient() {
+			// Keep the raw bytes for rejected records. A record of the wrong shape leaves the
+			// decoder at the next element, but after a syntax error the array cannot be resumed.
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return fmt.Errorf("error decoding record %d: %w", recordCount, err)
+			}
+			position.Raw = raw
+			position.Offset = byteOffset + decoder.InputOffset() - int64(len(raw))
+			err = json.Unmarshal(raw, &record)
+		} else {
+			err = decoder.Decode(&record)
 		}
 
-		// Call the callback function for each record
-		if err := callback(record); err != nil {
+		if err != nil {
+			if err := stream.reject(position, ErrorCategoryDecode, err); err != nil {
+				return fmt.Errorf("error decoding record %d: %w", recordCount, err)
+			}
+		} else if err := stream.deliver(record, position); err != nil {
 			return fmt.Errorf("error in callback for record %d: %w", recordCount, err)
 		}
 
@@ -95,7 +370,7 @@ func StreamDecodes(filename string, callback func(DecodeRecord) error) error {
 	}
 
 	// Read the closing bracket of the JSON array
-	token, err = decoder.Token()
+	_, err = decoder.Token()
 	if err != nil && err != io.EOF {
 		return fmt.Errorf("error reading JSON array end: %w", err)
 	}
@@ -103,6 +378,43 @@ func StreamDecodes(filename string, callback func(DecodeRecord) error) error {
 	return nil
 }
 
+// streamNDJSON decodes one record per line, skipping blank lines
+// Errors report 1-based line numbers; lineOffset and byteOffset account for input already consumed
+func streamNDJSON(reader *bufio.Reader, lineOffset int, byteOffset int64, stream recordStream) error {
+	lineNumber, offset, recordCount := lineOffset, byteOffset, 0
+	for {
+		// ReadBytes has no line-length limit, unlike bufio.Scanner
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("error reading line %d: %w", lineNumber+1, readErr)
+		}
+		if len(line) > 0 {
+			lineNumber++
+		}
+		lineStart := offset
+		offset += int64(len(line))
+
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) > 0 {
+			position := RecordError{File: stream.file, Line: lineNumber, Record: recordCount, Offset: lineStart, Raw: trimmed}
+			recordCount++
+
+			var record DecodeRecord
+			if err := json.Unmarshal(trimmed, &record); err != nil {
+				if err := stream.reject(position, ErrorCategoryDecode, err); err != nil {
+					return fmt.Errorf("error decoding line %d: %w", lineNumber, err)
+				}
+			} else if err := stream.deliver(record, position); err != nil {
+				return fmt.Errorf("error in callback for line %d: %w", lineNumber, err)
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
 // GetLongURL looks up the original URL for a given bitlink
 func (mapping URLMapping) GetLongURL(bitlink string) (string, bool) {
 	longURL, exists := mapping[bitlink]
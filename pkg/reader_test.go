@@ -3,7 +3,9 @@ package pkg
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -201,3 +203,267 @@ func TestURLMapping_GetLongURL(t *testing.T) {
 		t.Error("Expected URL to not exist in mapping")
 	}
 }
+
+// writeTempFile writes content to a temporary file and returns its path
+func writeTempFile(t *testing.T, pattern, content string) string {
+	t.Helper()
+
+	tmpFile, err := os.CreateTemp("", pattern)
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(tmpFile.Name()) })
+	defer tmpFile.Close()
+
+	if _, err := tmpFile.WriteString(content); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	return tmpFile.Name()
+}
+
+// collectDecodes streams a file with the given format and returns every record
+func collectDecodes(t *testing.T, filename string, format DecodeFormat) ([]DecodeRecord, error) {
+	t.Helper()
+
+	var records []DecodeRecord
+	err := StreamDecodesFormat(filename, format, func(record DecodeRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+func TestStreamDecodesFormat_NDJSON(t *testing.T) {
+	testNDJSON := `{"bitlink": "http://bit.ly/2kkAHNs", "user_agent": "Mozilla/5.0", "timestamp": "2020-02-15T00:00:00Z", "referrer": "t.co", "remote_ip": "4.14.247.63"}
+
+{"bitlink": "http://bit.ly/31Tt55y", "user_agent": "Chrome", "timestamp": "2020-02-16T00:00:00Z", "referrer": "direct", "remote_ip": "192.168.1.1"}
+`
+	filename := writeTempFile(t, "test_decodes*.ndjson", testNDJSON)
+
+	expected := []DecodeRecord{
+		{Bitlink: "http://bit.ly/2kkAHNs", UserAgent: "Mozilla/5.0", Timestamp: "2020-02-15T00:00:00Z", Referrer: "t.co", RemoteIP: "4.14.247.63"},
+		{Bitlink: "http://bit.ly/31Tt55y", UserAgent: "Chrome", Timestamp: "2020-02-16T00:00:00Z", Referrer: "direct", RemoteIP: "192.168.1.1"},
+	}
+
+	// Both the explicit and auto-detected formats should yield the same records
+	for _, format := range []DecodeFormat{FormatNDJSON, FormatAuto} {
+		records, err := collectDecodes(t, filename, format)
+		if err != nil {
+			t.Fatalf("StreamDecodesFormat(%s) failed: %v", format, err)
+		}
+		if !reflect.DeepEqual(records, expected) {
+			t.Errorf("Format %s: expected records %+v, got %+v", format, expected, records)
+		}
+	}
+}
+
+func TestStreamDecodesFormat_AutoDetectArray(t *testing.T) {
+	testJSON := `
+	[
+		{"bitlink": "http://bit.ly/2kkAHNs", "user_agent": "Mozilla/5.0", "timestamp": "2020-02-15T00:00:00Z", "referrer": "t.co", "remote_ip": "4.14.247.63"}
+	]`
+	filename := writeTempFile(t, "test_decodes*.json", testJSON)
+
+	records, err := collectDecodes(t, filename, FormatAuto)
+	if err != nil {
+		t.Fatalf("StreamDecodesFormat failed: %v", err)
+	}
+	if len(records) != 1 || records[0].Referrer != "t.co" {
+		t.Errorf("Expected 1 record from t.co, got %+v", records)
+	}
+}
+
+func TestStreamDecodesFormat_NDJSONLineNumberInError(t *testing.T) {
+	// Leading blank line plus one good record puts the malformed record on line 3
+	testNDJSON := `
+{"bitlink": "http://bit.ly/a", "timestamp": "2020-01-01T00:00:00Z"}
+{"bitlink": "http://bit.ly/b", "timestamp": 
+{"bitlink": "http://bit.ly/c", "timestamp": "2020-01-03T00:00:00Z"}`
+	filename := writeTempFile(t, "test_bad*.ndjson", testNDJSON)
+
+	records, err := collectDecodes(t, filename, FormatAuto)
+	if err == nil {
+		t.Fatal("Expected error for malformed NDJSON line, got nil")
+	}
+	if !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected error to mention line 3, got %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("Expected 1 record before the error, got %d", len(records))
+	}
+}
+
+func TestStreamDecodesFormat_ArrayRejectsNDJSON(t *testing.T) {
+	filename := writeTempFile(t, "test_ndjson*.json", `{"bitlink": "http://bit.ly/a"}`)
+
+	if _, err := collectDecodes(t, filename, FormatJSONArray); err == nil {
+		t.Error("Expected error when reading NDJSON as a JSON array, got nil")
+	}
+}
+
+func TestStreamDecodesFormat_EmptyInput(t *testing.T) {
+	filename := writeTempFile(t, "test_empty*.ndjson", "\n\n")
+
+	records, err := collectDecodes(t, filename, FormatAuto)
+	if err != nil {
+		t.Fatalf("Expected empty input to succeed, got %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("Expected no records, got %d", len(records))
+	}
+}
+
+func TestParseDecodeFormat(t *testing.T) {
+	cases := map[string]DecodeFormat{
+		"":       FormatAuto,
+		"auto":   FormatAuto,
+		"array":  FormatJSONArray,
+		"NDJSON": FormatNDJSON,
+		"jsonl":  FormatNDJSON,
+	}
+	for input, expected := range cases {
+		format, err := ParseDecodeFormat(input)
+		if err != nil {
+			t.Errorf("ParseDecodeFormat(%q) failed: %v", input, err)
+		}
+		if format != expected {
+			t.Errorf("ParseDecodeFormat(%q): expected %s, got %s", input, expected, format)
+		}
+	}
+
+	if _, err := ParseDecodeFormat("xml"); err == nil {
+		t.Error("Expected error for unknown format, got nil")
+	}
+}
+
+func TestReadEncodesMappingsFrom(t *testing.T) {
+	testCSV := `long_url,domain,hash
+https://google.com/,bit.ly,31Tt55y
+https://github.com/,bit.ly,2kJO0qS`
+
+	mapping, err := ReadEncodesMappingsFrom(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("ReadEncodesMappingsFrom failed: %v", err)
+	}
+
+	expected := URLMapping{
+		"http://bit.ly/31Tt55y": "https://google.com/",
+		"http://bit.ly/2kJO0qS": "https://github.com/",
+	}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("Expected mapping %+v, got %+v", expected, mapping)
+	}
+}
+
+func TestStreamDecodesFrom(t *testing.T) {
+	testJSON := `[{"bitlink": "http://bit.ly/2kkAHNs", "user_agent": "Mozilla/5.0", "timestamp": "2020-02-15T00:00:00Z", "referrer": "t.co", "remote_ip": "4.14.247.63"}]`
+
+	var records []DecodeRecord
+	err := StreamDecodesFrom(strings.NewReader(testJSON), FormatAuto, func(record DecodeRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamDecodesFrom failed: %v", err)
+	}
+
+	expected := []DecodeRecord{
+		{Bitlink: "http://bit.ly/2kkAHNs", UserAgent: "Mozilla/5.0", Timestamp: "2020-02-15T00:00:00Z", Referrer: "t.co", RemoteIP: "4.14.247.63"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected records %+v, got %+v", expected, records)
+	}
+}
+
+func TestStreamDecodesFiles_GlobInOrder(t *testing.T) {
+	dir := t.TempDir()
+	shards := map[string]string{
+		"decodes-2021-01-02.json":   `{"bitlink": "http://bit.ly/b", "timestamp": "2021-01-02T00:00:00Z"}`,
+		"decodes-2021-01-01.json":   `[{"bitlink": "http://bit.ly/a", "timestamp": "2021-01-01T00:00:00Z"}, {"bitlink": "http://bit.ly/a", "timestamp": "2021-01-01T01:00:00Z"}]`,
+		"decodes-2020-12-31.ndjson": `{"bitlink": "http://bit.ly/z", "timestamp": "2020-12-31T00:00:00Z"}`,
+	}
+	for name, content := range shards {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write shard: %v", err)
+		}
+	}
+
+	var bitlinks []string
+	patterns := []string{filepath.Join(dir, "decodes-2021-*.json"), filepath.Join(dir, "decodes-2020-12-31.ndjson")}
+	sources, err := StreamDecodesFiles(patterns, FormatAuto, func(record DecodeRecord) error {
+		bitlinks = append(bitlinks, record.Bitlink)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamDecodesFiles failed: %v", err)
+	}
+
+	expectedBitlinks := []string{"http://bit.ly/a", "http://bit.ly/a", "http://bit.ly/b", "http://bit.ly/z"}
+	if !reflect.DeepEqual(bitlinks, expectedBitlinks) {
+		t.Errorf("Expected records in order %v, got %v", expectedBitlinks, bitlinks)
+	}
+
+	expectedSources := []SourceFile{
+		{Path: filepath.Join(dir, "decodes-2021-01-01.json"), Records: 2},
+		{Path: filepath.Join(dir, "decodes-2021-01-02.json"), Records: 1},
+		{Path: filepath.Join(dir, "decodes-2020-12-31.ndjson"), Records: 1},
+	}
+	if !reflect.DeepEqual(sources, expectedSources) {
+		t.Errorf("Expected sources %+v, got %+v", expectedSources, sources)
+	}
+}
+
+func TestStreamDecodesFiles_Errors(t *testing.T) {
+	dir := t.TempDir()
+	noop := func(record DecodeRecord) error { return nil }
+
+	if _, err := StreamDecodesFiles([]string{filepath.Join(dir, "missing-*.json")}, FormatAuto, noop); err == nil {
+		t.Error("Expected error for glob with no matches, got nil")
+	}
+
+	good := filepath.Join(dir, "good.json")
+	if err := os.WriteFile(good, []byte(`[{"bitlink": "http://bit.ly/a"}]`), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	missing := filepath.Join(dir, "missing.json")
+
+	sources, err := StreamDecodesFiles([]string{good, missing}, FormatAuto, noop)
+	if err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("Expected error naming %s, got %v", missing, err)
+	}
+	if len(sources) != 2 || sources[0].Records != 1 {
+		t.Errorf("Expected provenance for both attempted files, got %+v", sources)
+	}
+}
+
+func TestDecodeRecord_NumericTimestamp(t *testing.T) {
+	testNDJSON := `{"bitlink": "http://bit.ly/a", "timestamp": 1614600000}
+{"bitlink": "http://bit.ly/b", "timestamp": 1614600000250}
+{"bitlink": "http://bit.ly/c", "timestamp": "1614600000"}
+{"bitlink": "http://bit.ly/d"}`
+
+	var timestamps []string
+	err := StreamDecodesFrom(strings.NewReader(testNDJSON), FormatNDJSON, func(record DecodeRecord) error {
+		timestamps = append(timestamps, record.Timestamp)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamDecodesFrom failed: %v", err)
+	}
+
+	expected := []string{"1614600000", "1614600000250", "1614600000", ""}
+	if !reflect.DeepEqual(timestamps, expected) {
+		t.Errorf("Expected timestamps %q, got %q", expected, timestamps)
+	}
+}
+
+func TestDecodeRecord_InvalidTimestampType(t *testing.T) {
+	testNDJSON := `{"bitlink": "http://bit.ly/a", "timestamp": true}`
+
+	err := StreamDecodesFrom(strings.NewReader(testNDJSON), FormatNDJSON, func(record DecodeRecord) error {
+		return nil
+	})
+	if err == nil {
+		t.Error("Expected error for boolean timestamp, got nil")
+	}
+}
@@ -0,0 +1,259 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Channel is the kind of source that sent a click
+type Channel int
+
+const (
+	ChannelOther Channel = iota
+	ChannelDirect
+	ChannelSocial
+	ChannelSearch
+	ChannelEmail
+)
+
+// String returns the label used for ClicksByChannel keys
+func (c Channel) String() string {
+	switch c {
+	case ChannelDirect:
+		return "direct"
+	case ChannelSocial:
+		return "social"
+	case ChannelSearch:
+		return "search"
+	case ChannelEmail:
+		return "email"
+	default:
+		return "other"
+	}
+}
+
+// ParseChannel converts a channel label into a Channel
+func ParseChannel(value string) (Channel, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "direct":
+		return ChannelDirect, nil
+	case "social":
+		return ChannelSocial, nil
+	case "search":
+		return ChannelSearch, nil
+	case "email":
+		return ChannelEmail, nil
+	case "other":
+		return ChannelOther, nil
+	}
+	return ChannelOther, fmt.Errorf("unknown channel %q (expected direct, social, search, email or other)", value)
+}
+
+// ReferrerDirect is the canonical host for clicks without a referrer
+const ReferrerDirect = "direct"
+
+// directReferrers are the raw values that mean no referrer, besides the empty string
+var directReferrers = []string{"direct", "(direct)", "none", "(none)", "null", "-"}
+
+// hostPrefixes are stripped from hosts, so www.reddit.com and m.facebook.com
+// count with reddit.com and facebook.com
+var hostPrefixes = []string{"www.", "m.", "mobile."}
+
+// defaultReferrerAliases map shorteners, link shims and alternate domains to the
+// site's canonical host
+var defaultReferrerAliases = map[string]string{
+	"t.co":                  "twitter.com",
+	"x.com":                 "twitter.com",
+	"lnkd.in":               "linkedin.com",
+	"fb.me":                 "facebook.com",
+	"l.facebook.com":        "facebook.com",
+	"lm.facebook.com":       "facebook.com",
+	"l.instagram.com":       "instagram.com",
+	"out.reddit.com":        "reddit.com",
+	"old.reddit.com":        "reddit.com",
+	"news.ycombinator.com":  "ycombinator.com",
+	"youtu.be":              "youtube.com",
+	"com.google.android.gm": "mail.google.com", // Gmail app (android-app://com.google.android.gm)
+}
+
+// defaultReferrerChannels classify canonical hosts; subdomains inherit their
+// parent's channel unless listed themselves
+var defaultReferrerChannels = map[string]Channel{
+	"twitter.com":           ChannelSocial,
+	"facebook.com":          ChannelSocial,
+	"instagram.com":         ChannelSocial,
+	"linkedin.com":          ChannelSocial,
+	"reddit.com":            ChannelSocial,
+	"pinterest.com":         ChannelSocial,
+	"tiktok.com":            ChannelSocial,
+	"youtube.com":           ChannelSocial,
+	"snapchat.com":          ChannelSocial,
+	"tumblr.com":            ChannelSocial,
+	"quora.com":             ChannelSocial,
+	"ycombinator.com":       ChannelSocial,
+	"producthunt.com":       ChannelSocial,
+	"google.com":            ChannelSearch,
+	"bing.com":              ChannelSearch,
+	"duckduckgo.com":        ChannelSearch,
+	"yahoo.com":             ChannelSearch,
+	"baidu.com":             ChannelSearch,
+	"yandex.ru":             ChannelSearch,
+	"ecosia.org":            ChannelSearch,
+	"search.brave.com":      ChannelSearch,
+	"mail.google.com":       ChannelEmail,
+	"mail.yahoo.com":        ChannelEmail,
+	"mail.aol.com":          ChannelEmail,
+	"mail.proton.me":        ChannelEmail,
+	"outlook.live.com":      ChannelEmail,
+	"outlook.office.com":    ChannelEmail,
+	"outlook.office365.com": ChannelEmail,
+}
+
+// searchEngines are recognised under any country domain, e.g. google.co.uk
+var searchEngines = []string{"google", "bing", "yahoo", "duckduckgo", "baidu", "yandex", "ecosia"}
+
+// NormalizedReferrer is a referrer reduced to its canonical host and channel
+type NormalizedReferrer struct {
+	Host    string // Canonical host, e.g. "twitter.com" for "https://t.co/abc"; "direct" for no referrer
+	Channel Channel
+}
+
+// ReferrerNormalizer canonicalises referrers and classifies them into channels
+// It is read-only after construction and safe for concurrent use.
+type ReferrerNormalizer struct {
+	aliases  map[string]string
+	channels map[string]Channel
+}
+
+// NewReferrerNormalizer creates a normalizer from an alias table (host to canonical
+// host) and a channel table (canonical host to channel); hosts are case-insensitive
+func NewReferrerNormalizer(aliases map[string]string, channels map[string]Channel) *ReferrerNormalizer {
+	n := &ReferrerNormalizer{aliases: make(map[string]string), channels: make(map[string]Channel)}
+	for host, canonical := range aliases {
+		n.aliases[strings.ToLower(host)] = strings.ToLower(canonical)
+	}
+	for host, channel := range channels {
+		n.channels[strings.ToLower(host)] = channel
+	}
+	return n
+}
+
+// DefaultReferrerNormalizer returns a normalizer with the built-in alias and channel tables
+func DefaultReferrerNormalizer() *ReferrerNormalizer {
+	return NewReferrerNormalizer(defaultReferrerAliases, defaultReferrerChannels)
+}
+
+// Normalize reduces a raw referrer (a URL or a bare host) to its canonical host and channel
+// Values that are not a URL or host are kept, lower-cased, in the other channel.
+func (n *ReferrerNormalizer) Normalize(referrer string) NormalizedReferrer {
+	referrer = strings.TrimSpace(referrer)
+	if referrer == "" || containsFold(directReferrers, referrer) {
+		return NormalizedReferrer{Host: ReferrerDirect, Channel: ChannelDirect}
+	}
+
+	host := referrerHost(referrer)
+	if canonical, ok := n.aliases[host]; ok {
+		host = canonical
+	}
+	return NormalizedReferrer{Host: host, Channel: n.classify(host)}
+}
+
+// classify finds the channel of a canonical host, trying the host and then each
+// parent domain, so news.google.com is search but mail.google.com is email
+func (n *ReferrerNormalizer) classify(host string) Channel {
+	for domain := host; domain != ""; {
+		if channel, ok := n.channels[domain]; ok {
+			return channel
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+
+	name, _, _ := strings.Cut(host, ".")
+	for _, engine := range searchEngines {
+		if name == engine {
+			return ChannelSearch
+		}
+	}
+	return ChannelOther
+}
+
+// referrerHost extracts the lower-cased host from a referrer URL or bare host,
+// without the port and common prefixes such as www.
+func referrerHost(referrer string) string {
+	raw := referrer
+	if !strings.Contains(raw, "://") {
+		raw = "//" + raw // Parse bare hosts such as "t.co/abc" as authorities
+	}
+
+	host := strings.ToLower(referrer)
+	if parsed, err := url.Parse(raw); err == nil && parsed.Hostname() != "" {
+		host = strings.ToLower(parsed.Hostname())
+	}
+	host = strings.TrimSuffix(host, ".")
+	for _, prefix := range hostPrefixes {
+		if trimmed, ok := strings.CutPrefix(host, prefix); ok && strings.Contains(trimmed, ".") {
+			host = trimmed
+		}
+	}
+	return host
+}
+
+// referrerRules is the JSON referrer rule file
+type referrerRules struct {
+	Aliases  map[string]string `json:"aliases"`
+	Channels map[string]string `json:"channels"`
+}
+
+// LoadReferrerRules reads a JSON referrer rule file (see ParseReferrerRules)
+func LoadReferrerRules(filename string) (*ReferrerNormalizer, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening referrer rules: %w", err)
+	}
+	defer file.Close()
+
+	normalizer, err := ParseReferrerRules(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading referrer rules %s: %w", filename, err)
+	}
+	return normalizer, nil
+}
+
+// ParseReferrerRules parses aliases and channels that extend, or override, the built-in tables:
+//
+//	{
+//	  "aliases":  {"go.example.com": "example.com", "lnkd.in": "linkedin.com"},
+//	  "channels": {"example.com": "email", "news.example.org": "social"}
+//	}
+func ParseReferrerRules(r io.Reader) (*ReferrerNormalizer, error) {
+	var rules referrerRules
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("error decoding referrer rules: %w", err)
+	}
+
+	normalizer := DefaultReferrerNormalizer()
+	for host, canonical := range rules.Aliases {
+		if strings.TrimSpace(canonical) == "" {
+			return nil, fmt.Errorf("alias for %s: empty canonical host", host)
+		}
+		normalizer.aliases[strings.ToLower(host)] = strings.ToLower(canonical)
+	}
+	for host, label := range rules.Channels {
+		channel, err := ParseChannel(label)
+		if err != nil {
+			return nil, fmt.Errorf("channel for %s: %w", host, err)
+		}
+		normalizer.channels[strings.ToLower(host)] = channel
+	}
+	return normalizer, nil
+}
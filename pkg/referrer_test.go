@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReferrerNormalizer_Normalize(t *testing.T) {
+	normalizer := DefaultReferrerNormalizer()
+
+	cases := map[string]NormalizedReferrer{
+		"":                                       {"direct", ChannelDirect},
+		"direct":                                 {"direct", ChannelDirect},
+		"(none)":                                 {"direct", ChannelDirect},
+		"t.co":                                   {"twitter.com", ChannelSocial},
+		"https://t.co/AbC123":                    {"twitter.com", ChannelSocial},
+		"twitter.com":                            {"twitter.com", ChannelSocial},
+		"https://mobile.twitter.com/user":        {"twitter.com", ChannelSocial},
+		"lnkd.in":                                {"linkedin.com", ChannelSocial},
+		"https://l.facebook.com/l.php?u=x":       {"facebook.com", ChannelSocial},
+		"https://WWW.Reddit.com:443/r/golang/":   {"reddit.com", ChannelSocial},
+		"https://news.ycombinator.com/item?id=1": {"ycombinator.com", ChannelSocial},
+		"https://www.google.com/search?q=bitly":  {"google.com", ChannelSearch},
+		"https://www.google.co.uk/":              {"google.co.uk", ChannelSearch},
+		"https://news.google.com/":               {"news.google.com", ChannelSearch},
+		"https://mail.google.com/mail/u/0/":      {"mail.google.com", ChannelEmail},
+		"android-app://com.google.android.gm":    {"mail.google.com", ChannelEmail},
+		"https://outlook.live.com/mail/0/inbox":  {"outlook.live.com", ChannelEmail},
+		"https://blog.example.com/post?utm=x":    {"blog.example.com", ChannelOther},
+		"Some Newsletter":                        {"some newsletter", ChannelOther},
+	}
+	for referrer, expected := range cases {
+		if got := normalizer.Normalize(referrer); got != expected {
+			t.Errorf("Normalize(%q): expected %+v, got %+v", referrer, expected, got)
+		}
+	}
+}
+
+func TestParseReferrerRules(t *testing.T) {
+	rules := `{
+		"aliases": {"Go.Example.com": "example.com", "t.co": "x.com"},
+		"channels": {"example.com": "email", "x.com": "social"}
+	}`
+	normalizer, err := ParseReferrerRules(strings.NewReader(rules))
+	if err != nil {
+		t.Fatalf("ParseReferrerRules failed: %v", err)
+	}
+
+	cases := map[string]NormalizedReferrer{
+		"https://go.example.com/abc": {"example.com", ChannelEmail},
+		"t.co":                       {"x.com", ChannelSocial},        // Overrides the built-in alias
+		"lnkd.in":                    {"linkedin.com", ChannelSocial}, // Built-ins are kept
+	}
+	for referrer, expected := range cases {
+		if got := normalizer.Normalize(referrer); got != expected {
+			t.Errorf("Normalize(%q): expected %+v, got %+v", referrer, expected, got)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"channels": {"example.com": "paid"}}`,
+		`{"aliases": {"example.com": ""}}`,
+		`{"hosts": {}}`,
+		`[]`,
+	} {
+		if _, err := ParseReferrerRules(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected error for %s, got nil", invalid)
+		}
+	}
+}
+
+func TestAggregator_ReferrerHostsAndChannels(t *testing.T) {
+	aggregator := NewAggregator(URLMapping{}, AggregationConfig{})
+	for _, referrer := range []string{"t.co", "twitter.com", "direct", "", "https://www.google.com/", "https://mail.google.com/"} {
+		if err := aggregator.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T00:00:00Z", Referrer: referrer}); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	results := aggregator.GetResults()
+	if len(results.ClicksByReferrer) != 6 {
+		t.Errorf("Expected raw referrers to be kept, got %v", results.ClicksByReferrer)
+	}
+	expectedHosts := map[string]int{"twitter.com": 2, "direct": 2, "google.com": 1, "mail.google.com": 1}
+	if !reflect.DeepEqual(results.ClicksByReferrerHost, expectedHosts) {
+		t.Errorf("Expected hosts %v, got %v", expectedHosts, results.ClicksByReferrerHost)
+	}
+	expectedChannels := map[string]int{"social": 2, "direct": 2, "search": 1, "email": 1}
+	if !reflect.DeepEqual(results.ClicksByChannel, expectedChannels) {
+		t.Errorf("Expected channels %v, got %v", expectedChannels, results.ClicksByChannel)
+	}
+}
@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reporter renders aggregation results in one output format. The results carry
+// everything a report needs (counts, filters, sort order and enabled features), so
+// reporters can live outside this package; see RegisterReporter.
+type Reporter interface {
+	Report(w io.Writer, results AggregationResults) error
+}
+
+// ReporterFunc adapts a function such as WriteJSON to Reporter
+type ReporterFunc func(w io.Writer, results AggregationResults) error
+
+// Report calls f(w, results)
+func (f ReporterFunc) Report(w io.Writer, results AggregationResults) error {
+	return f(w, results)
+}
+
+var (
+	reportersMu sync.RWMutex
+	// Built-in reporters by name; csv and tsv write to a single stream unless given a Dir
+	reporters = map[string]Reporter{
+		"text":       ReporterFunc(WriteSummary),
+		"json":       ReporterFunc(WriteJSON),
+		"csv":        TableReporter{Delimiter: ','},
+		"tsv":        TableReporter{Delimiter: '\t'},
+		"markdown":   ReporterFunc(WriteMarkdown),
+		"html":       ReporterFunc(WriteHTML),
+		"prometheus": ReporterFunc(WriteMetrics),
+	}
+)
+
+// RegisterReporter makes a reporter available under name (case-insensitive), e.g.
+// for -format. Like database/sql.Register, it is meant to be called from init and
+// panics if the name is empty or taken, or reporter is nil.
+func RegisterReporter(name string, reporter Reporter) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	reportersMu.Lock()
+	defer reportersMu.Unlock()
+	if name == "" || reporter == nil {
+		panic("pkg: RegisterReporter needs a name and a reporter")
+	}
+	if _, taken := reporters[name]; taken {
+		panic(fmt.Sprintf("pkg: RegisterReporter called twice for %q", name))
+	}
+	reporters[name] = reporter
+}
+
+// LookupReporter returns the reporter registered under name; an empty name is text
+func LookupReporter(name string) (Reporter, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = "text"
+	}
+	reportersMu.RLock()
+	reporter, ok := reporters[name]
+	reportersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q (expected one of %s)", name, strings.Join(ReporterNames(), ", "))
+	}
+	return reporter, nil
+}
+
+// ReporterNames returns the names of the registered reporters in alphabetical order
+func ReporterNames() []string {
+	reportersMu.RLock()
+	defer reportersMu.RUnlock()
+	names := make([]string, 0, len(reporters))
+	for name := range reporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reportField is one line of a report header
+type reportField struct {
+	Label string
+	Value string
+}
+
+// reportFields describes the filters, counts and timing for the HTML and Markdown
+// report headers; the text summary shares the counts through the helpers below
+func reportFields(r *AggregationResults) []reportField {
+	filter := "none"
+	if r.FilterYear > 0 {
+		filter = fmt.Sprintf("year %d", r.FilterYear)
+	} else if !r.FilterRange.IsZero() {
+		filter = r.FilterRange.String()
+	}
+	fields := []reportField{{"Filter", filter}}
+	if !r.FilterGeo.IsZero() {
+		fields = append(fields, reportField{"Filter Location", r.FilterGeo.String()})
+	}
+	if r.FilterWhere != "" {
+		fields = append(fields, reportField{"Filter Where", r.FilterWhere})
+	}
+	fields = append(fields,
+		reportField{"Time Zone", r.TimeZone},
+		reportField{"Granularity", r.Granularity.String()},
+		reportField{"Records Processed", fmt.Sprintf("%d (%s)", r.ProcessedRecords, processedBreakdown(r))},
+		reportField{"Total Clicks", fmt.Sprint(r.TotalClicks)},
+		reportField{"Records Filtered Out", filteredOutSummary(r)},
+		reportField{"Unknown Bitlinks", unknownBitlinkSummary(r)},
+	)
+	if r.BotsEnabled {
+		fields = append(fields, reportField{"Bot Clicks", fmt.Sprint(r.BotClicks)})
+	}
+	if r.Visitors != nil {
+		fields = append(fields, reportField{"Unique Visitors", fmt.Sprint(r.UniqueVisitors)})
+	}
+	if r.ProcessingTime > 0 {
+		fields = append(fields, reportField{"Processing Time", r.ProcessingTime.Round(time.Millisecond).String()})
+	}
+	if len(r.SourceFiles) > 0 {
+		paths := make([]string, len(r.SourceFiles))
+		for i, source := range r.SourceFiles {
+			paths[i] = source.Path
+		}
+		fields = append(fields, reportField{"Input Files", strings.Join(paths, ", ")})
+	}
+	return fields
+}
+
+// filteredOutSummary is the filtered-out count with its reasons; the location and
+// expression reasons only appear when those filters are active
+func filteredOutSummary(r *AggregationResults) string {
+	reasons := r.FilteredReasons
+	summary := fmt.Sprintf("%d (before range: %d, after range: %d, unparseable: %d",
+		r.FilteredOut, reasons.BeforeRange, reasons.AfterRange, reasons.Unparseable)
+	if !r.FilterGeo.IsZero() {
+		summary += fmt.Sprintf(", outside location: %d", reasons.GeoMismatch)
+	}
+	if r.FilterWhere != "" {
+		summary += fmt.Sprintf(", where false: %d", reasons.WhereFalse)
+	}
+	return summary + ")"
+}
+
+// unknownBitlinkSummary is the number of unknown bitlinks and their clicks
+func unknownBitlinkSummary(r *AggregationResults) string {
+	clicks := 0
+	for _, count := range r.UnknownBitlinks {
+		clicks += count
+	}
+	return fmt.Sprintf("%d (%d clicks)", len(r.UnknownBitlinks), clicks)
+}
+
+// processedBreakdown spells out how ProcessedRecords divides into clicks, filtered
+// records (including rejected timestamps) and excluded bot clicks
+func processedBreakdown(r *AggregationResults) string {
+	breakdown := fmt.Sprintf("%d counted, %d filtered out", r.TotalClicks, r.FilteredOut)
+	if r.BotsExcluded {
+		breakdown += fmt.Sprintf(", %d bot clicks excluded", r.BotClicks)
+	}
+	return breakdown
+}
+
+// tableTitle turns a ResultTable name such as clicks_by_url into "Clicks by url"
+func tableTitle(name string) string {
+	title := strings.ReplaceAll(name, "_", " ")
+	if title == "" {
+		return title
+	}
+	return strings.ToUpper(title[:1]) + title[1:]
+}
+
+// isCountColumn reports whether a ResultTable column holds counts
+func isCountColumn(name string) bool {
+	return name == "clicks" || name == "visitors"
+}
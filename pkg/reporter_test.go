@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookupReporter(t *testing.T) {
+	expected := []string{"csv", "html", "json", "markdown", "prometheus", "text", "tsv"}
+	if names := ReporterNames(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected built-in reporters %v, got %v", expected, names)
+	}
+
+	for _, name := range []string{"", "text", " JSON ", "markdown", "html", "prometheus"} {
+		if _, err := LookupReporter(name); err != nil {
+			t.Errorf("LookupReporter(%q) failed: %v", name, err)
+		}
+	}
+	if reporter, _ := LookupReporter("tsv"); reporter != (TableReporter{Delimiter: '\t'}) {
+		t.Errorf("Expected the tsv reporter to write tab-separated tables, got %#v", reporter)
+	}
+
+	_, err := LookupReporter("xml")
+	if err == nil || !strings.Contains(err.Error(), "csv, html, json") {
+		t.Errorf("Expected an error listing the formats, got %v", err)
+	}
+}
+
+func TestRegisterReporter(t *testing.T) {
+	// A renderer written outside the aggregator, using only the results
+	totals := ReporterFunc(func(w io.Writer, results AggregationResults) error {
+		_, err := fmt.Fprintf(w, "%d/%d\n", results.TotalClicks, results.ProcessedRecords)
+		return err
+	})
+	RegisterReporter("Totals", totals)
+	defer func() {
+		reportersMu.Lock()
+		delete(reporters, "totals")
+		reportersMu.Unlock()
+	}()
+
+	reporter, err := LookupReporter("totals")
+	if err != nil {
+		t.Fatalf("LookupReporter failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := reporter.Report(&buf, tableTestAggregator(t, AggregationConfig{}).GetResults()); err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if buf.String() != "5/5\n" {
+		t.Errorf("Unexpected report %q", buf.String())
+	}
+
+	for name, register := range map[string]func(){
+		"duplicate":  func() { RegisterReporter("TEXT", totals) },
+		"empty name": func() { RegisterReporter(" ", totals) },
+		"nil":        func() { RegisterReporter("nothing", nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected RegisterReporter to panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+func TestTableTitle(t *testing.T) {
+	for name, expected := range map[string]string{"clicks_by_url": "Clicks by url", "pivot": "Pivot", "": ""} {
+		if got := tableTitle(name); got != expected {
+			t.Errorf("tableTitle(%q): expected %q, got %q", name, expected, got)
+		}
+	}
+}
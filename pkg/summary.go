@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// summarySeriesLimit caps the chronological series in the text summary
+const summarySeriesLimit = 31
+
+// WriteSummary writes the human-readable text summary of the results
+func WriteSummary(w io.Writer, results AggregationResults) error {
+	r := &results
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "\n=== Aggregation Results ===\n")
+	if r.FilterYear > 0 {
+		fmt.Fprintf(b, "Filter Year: %d\n", r.FilterYear)
+	} else if !r.FilterRange.IsZero() {
+		fmt.Fprintf(b, "Filter Range: %s\n", r.FilterRange)
+	}
+	if !r.FilterGeo.IsZero() {
+		fmt.Fprintf(b, "Filter Location: %s\n", r.FilterGeo)
+	}
+	if r.FilterWhere != "" {
+		fmt.Fprintf(b, "Filter Where: %s\n", r.FilterWhere)
+	}
+	if !r.FilterRange.IsZero() || !r.FilterGeo.IsZero() || r.FilterWhere != "" || r.FilteredOut > 0 {
+		fmt.Fprintf(b, "Records Filtered Out: %s\n", filteredOutSummary(r))
+	}
+	if r.TimeZone != time.UTC.String() {
+		fmt.Fprintf(b, "Time Zone: %s\n", r.TimeZone)
+	}
+	fmt.Fprintf(b, "Total Records Processed: %d (%s)\n", r.ProcessedRecords, processedBreakdown(r))
+	fmt.Fprintf(b, "Total Clicks: %d\n", r.TotalClicks)
+	fmt.Fprintf(b, "Unknown Bitlinks: %s\n", unknownBitlinkSummary(r))
+	if len(r.RecordErrors) > 0 {
+		rejected := 0
+		for _, count := range r.RecordErrors {
+			rejected += count
+		}
+		fmt.Fprintf(b, "Records Rejected: %d (%s)\n", rejected, formatCounts(r.RecordErrors))
+	}
+	// Only worth reporting when something other than plain RFC3339 was seen
+	if formats := r.TimestampFormats; len(formats) > 1 || (len(formats) == 1 && formats[TimestampRFC3339] == 0) {
+		fmt.Fprintf(b, "Timestamp Formats:")
+		for _, format := range r.SortedCounts(formats) {
+			fmt.Fprintf(b, " %s=%d", format.Key, format.Value)
+		}
+		fmt.Fprintf(b, "\n")
+	}
+	if r.BotsEnabled {
+		action := "flagged"
+		if r.BotsExcluded {
+			action = "excluded"
+		}
+		fmt.Fprintf(b, "Bot Clicks: %d %s", r.BotClicks, action)
+		if r.BotClicks > 0 {
+			fmt.Fprintf(b, " (%s)", formatCounts(r.BotClicksByRule))
+		}
+		fmt.Fprintf(b, "\n")
+	}
+	if r.Visitors != nil {
+		if r.Visitors.Exact {
+			fmt.Fprintf(b, "Unique Visitors: %d (exact, by %s)\n", r.UniqueVisitors, r.Visitors.Key)
+		} else {
+			fmt.Fprintf(b, "Unique Visitors: ~%d (HyperLogLog p=%d, ±%.1f%%, by %s)\n", r.UniqueVisitors,
+				r.Visitors.Precision, r.Visitors.standardError()*100, r.Visitors.Key)
+		}
+	}
+	if r.ProcessingTime > 0 {
+		fmt.Fprintf(b, "Processing Time: %v\n", r.ProcessingTime)
+	}
+
+	if len(r.SourceFiles) > 1 {
+		fmt.Fprintf(b, "\n--- Input Files ---\n")
+		for _, source := range r.SourceFiles {
+			fmt.Fprintf(b, "%s: %d records\n", source.Path, source.Records)
+		}
+	}
+
+	fmt.Fprintf(b, "\n--- Top URLs by Clicks ---\n")
+	sortedURLs := r.SortedURLs(false) // Include all URLs
+	for _, urlClick := range sortedURLs {
+		fmt.Fprintf(b, "%s: %d clicks\n", urlClick.Key, urlClick.Value)
+	}
+
+	fmt.Fprintf(b, "\n--- Top Referrers ---\n")
+	sortedReferrers := r.SortedCounts(r.ClicksByReferrer)
+	for _, referrer := range sortedReferrers {
+		fmt.Fprintf(b, "%s: %d clicks\n", referrer.Key, referrer.Value)
+	}
+
+	printCounts := func(heading string, counts map[string]int) {
+		fmt.Fprintf(b, "\n--- %s ---\n", heading)
+		for _, item := range r.SortedCounts(counts) {
+			fmt.Fprintf(b, "%s: %d clicks\n", item.Key, item.Value)
+		}
+	}
+	printCounts("Top Referrer Hosts (normalized)", r.ClicksByReferrerHost)
+	printCounts("Clicks by Channel", r.ClicksByChannel)
+	printCounts("Clicks by Browser", r.ClicksByBrowser)
+	printCounts("Clicks by OS", r.ClicksByOS)
+	printCounts("Clicks by Device", r.ClicksByDevice)
+	if r.GeoEnabled {
+		printCounts("Clicks by Country", r.ClicksByCountry)
+		printCounts("Clicks by Region", r.ClicksByRegion)
+		printCounts("Clicks by City", r.ClicksByCity)
+	}
+
+	if r.Visitors != nil {
+		printVisitors := func(heading string, counts map[string]int, limit int) {
+			fmt.Fprintf(b, "\n--- %s ---\n", heading)
+			for i, item := range r.SortedCounts(counts) {
+				if limit > 0 && i >= limit {
+					break
+				}
+				fmt.Fprintf(b, "%s: %d visitors\n", item.Key, item.Value)
+			}
+		}
+		printVisitors("Unique Visitors by URL", r.UniqueVisitorsByURL, 0)
+		printVisitors("Unique Visitors by Referrer", r.UniqueVisitorsByReferrer, 0)
+		printVisitors(fmt.Sprintf("Unique Visitors by %s (first 10)", bucketHeading(r.Granularity)), r.UniqueVisitorsByDate, 10)
+	}
+
+	fmt.Fprintf(b, "\n--- Clicks by %s (first 10) ---\n", bucketHeading(r.Granularity))
+	sortedDates := r.SortedCounts(r.ClicksByDate)
+	for i, date := range sortedDates {
+		if i >= 10 {
+			break
+		}
+		fmt.Fprintf(b, "%s: %d clicks\n", date.Key, date.Value)
+	}
+
+	if r.Pivot != nil {
+		fmt.Fprintf(b, "\n--- Clicks by %s (top 20) ---\n", joinDimensions(r.Pivot.Dimensions, " × "))
+		for _, row := range r.Pivot.Rows(PivotOptions{Descending: r.SortDesc, Limit: 20}) {
+			fmt.Fprintf(b, "%s: %d clicks\n", strings.Join(row.Values, " | "), row.Clicks)
+		}
+	}
+
+	if series, err := r.TimeSeries(); err == nil && len(series) > 0 {
+		// Only the most recent buckets; the other formats carry the full series
+		if len(series) > summarySeriesLimit {
+			fmt.Fprintf(b, "\n--- Clicks over Time (%s, chronological, last %d of %d) ---\n",
+				r.Granularity, summarySeriesLimit, len(series))
+			series = series[len(series)-summarySeriesLimit:]
+		} else {
+			fmt.Fprintf(b, "\n--- Clicks over Time (%s, chronological) ---\n", r.Granularity)
+		}
+		for _, bucket := range series {
+			fmt.Fprintf(b, "%s: %d clicks\n", bucket.Label, bucket.Clicks)
+		}
+	}
+
+	if len(r.UnknownBitlinks) > 0 {
+		fmt.Fprintf(b, "\n--- Unknown Bitlinks by Domain (top 5 each) ---\n")
+		for _, domain := range r.UnknownBitlinkReport().Domains {
+			fmt.Fprintf(b, "%s: %d bitlinks, %d clicks\n", domain.Domain, len(domain.Bitlinks), domain.Clicks)
+			for i, unknown := range domain.Bitlinks {
+				if i >= 5 {
+					break
+				}
+				fmt.Fprintf(b, "  %s: %d clicks, seen %s to %s\n", unknown.Bitlink, unknown.Clicks,
+					unknown.FirstSeen.Format("2006-01-02 15:04"), unknown.LastSeen.Format("2006-01-02 15:04"))
+				for _, match := range unknown.NearMatches {
+					fmt.Fprintf(b, "    did you mean %s (%s)? -> %s\n", match.Bitlink, strings.Join(match.Differences, ", "), match.LongURL)
+				}
+			}
+		}
+	}
+
+	// Print final summary - only mapped long URLs (shortlinks without mapping are excluded)
+	fmt.Fprintf(b, "\nNote: Shortlinks without mapping are excluded from the final summary.\n")
+	fmt.Fprintf(b, "\nFinal Summary:\n")
+
+	// Get sorted URLs excluding shortlinks
+	sortedFinalURLs := r.SortedURLs(true) // Exclude shortlinks
+
+	// Print sorted results
+	fmt.Fprintf(b, "[")
+	for i, urlClick := range sortedFinalURLs {
+		if i > 0 {
+			fmt.Fprintf(b, ", ")
+		}
+		fmt.Fprintf(b, "{%s: %d}", jsonQuote(urlClick.Key), urlClick.Value)
+	}
+	fmt.Fprintf(b, "]\n")
+
+	if err := b.Flush(); err != nil {
+		return fmt.Errorf("error writing summary: %w", err)
+	}
+	return nil
+}
+
+// bucketHeading names the ClicksByDate buckets for summary headings
+func bucketHeading(g Granularity) string {
+	switch g {
+	case GranularityHour:
+		return "Hour"
+	case GranularityWeek:
+		return "ISO Week"
+	case GranularityMonth:
+		return "Month"
+	case GranularityQuarter:
+		return "Quarter"
+	case GranularityYear:
+		return "Year"
+	default:
+		return "Date"
+	}
+}
+
+// formatCounts renders counts as "decode: 2, timestamp: 1", ordered by key
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s: %d", key, counts[key])
+	}
+	return strings.Join(parts, ", ")
+}
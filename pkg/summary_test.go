@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteSummary(t *testing.T) {
+	mapping := URLMapping{"http://bit.ly/a": "https://a.com/", "http://bit.ly/b": "https://b.com/"}
+	aggregator := NewAggregator(mapping, AggregationConfig{FilterYear: 2021, SortDesc: true})
+	for _, record := range []DecodeRecord{
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T00:00:00Z", Referrer: "t.co"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-02T00:00:00Z", Referrer: "direct"},
+		{Bitlink: "http://bit.ly/b", Timestamp: "2021-01-02T00:00:00Z", Referrer: "direct"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2020-12-31T00:00:00Z", Referrer: "direct"},
+	} {
+		if err := aggregator.ProcessRecord(record); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSummary(&buf, aggregator.GetResults()); err != nil {
+		t.Fatalf("WriteSummary failed: %v", err)
+	}
+	summary := buf.String()
+	for _, expected := range []string{
+		"Filter Year: 2021\n",
+		"Records Filtered Out: 1 (before range: 1, after range: 0, unparseable: 0)\n",
+		"Total Records Processed: 4 (3 counted, 1 filtered out)\n",
+		"Total Clicks: 3\n",
+		"--- Top Referrers ---\ndirect: 2 clicks\nt.co: 1 clicks\n",
+		"--- Clicks over Time (day, chronological) ---\n2021-01-01: 1 clicks\n2021-01-02: 2 clicks\n",
+		`[{"https://a.com/": 2}, {"https://b.com/": 1}]` + "\n",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("Expected the summary to contain %q, got:\n%s", expected, summary)
+		}
+	}
+	// Sections for features that were not enabled are left out
+	for _, unexpected := range []string{"Bot Clicks", "Unique Visitors", "Clicks by Country", "Time Zone"} {
+		if strings.Contains(summary, unexpected) {
+			t.Errorf("Did not expect %q in the summary", unexpected)
+		}
+	}
+
+	if err := WriteSummary(failingWriter{}, aggregator.GetResults()); err == nil {
+		t.Error("Expected error from a failing writer, got nil")
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestWriteSummary_CapsTimeSeries(t *testing.T) {
+	aggregator := NewAggregator(URLMapping{"http://bit.ly/a": "https://a.com/"}, AggregationConfig{FilterYear: 2021})
+	for _, timestamp := range []string{"2021-01-01T00:00:00Z", "2021-12-31T00:00:00Z"} {
+		if err := aggregator.ProcessRecord(DecodeRecord{Bitlink: "http://bit.ly/a", Timestamp: timestamp}); err != nil {
+			t.Fatalf("ProcessRecord failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSummary(&buf, aggregator.GetResults()); err != nil {
+		t.Fatalf("WriteSummary failed: %v", err)
+	}
+	_, section, found := strings.Cut(buf.String(), "--- Clicks over Time (day, chronological, last 31 of 365) ---\n")
+	if !found {
+		t.Fatalf("Expected a capped time series heading, got:\n%s", buf.String())
+	}
+	section, _, _ = strings.Cut(section, "\n\n")
+	lines := strings.Split(section, "\n")
+	if len(lines) != summarySeriesLimit || lines[0] != "2021-12-01: 0 clicks" || lines[len(lines)-1] != "2021-12-31: 1 clicks" {
+		t.Errorf("Expected the last %d buckets, got %d lines: %q ... %q", summarySeriesLimit, len(lines), lines[0], lines[len(lines)-1])
+	}
+}
@@ -0,0 +1,225 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmptyKeyLabel stands in for an empty key, such as a missing referrer, in result
+// tables, so every click has a row and each table adds up to TotalClicks
+const EmptyKeyLabel = "(empty)"
+
+// ResultTable is one aggregation as a header and rows, for spreadsheet export
+type ResultTable struct {
+	Name   string // File name without extension, e.g. clicks_by_url
+	Header []string
+	Rows   [][]string
+}
+
+// ResultTables returns every aggregation of the current results as a table
+func (a *Aggregator) ResultTables() []ResultTable {
+	results := a.GetResults()
+	return results.ResultTables()
+}
+
+// ResultTables returns every aggregation as a table. Count tables are sorted like
+// SortedCounts, following SortDesc and breaking ties by key, but keep the empty key
+// as EmptyKeyLabel; the date tables are in chronological order. Geo, visitor and
+// pivot tables are only included when those features are enabled.
+func (r *AggregationResults) ResultTables() []ResultTable {
+	tables := []ResultTable{
+		r.countTable("clicks_by_url", "url", "clicks", r.ClicksByURL),
+		r.countTable("clicks_by_referrer", "referrer", "clicks", r.ClicksByReferrer),
+		r.countTable("clicks_by_referrer_host", "referrer_host", "clicks", r.ClicksByReferrerHost),
+		r.countTable("clicks_by_channel", "channel", "clicks", r.ClicksByChannel),
+		r.bucketTable("clicks_by_date", "clicks", r.ClicksByDate),
+		r.countTable("clicks_by_browser", "browser", "clicks", r.ClicksByBrowser),
+		r.countTable("clicks_by_os", "os", "clicks", r.ClicksByOS),
+		r.countTable("clicks_by_device", "device", "clicks", r.ClicksByDevice),
+	}
+	if r.GeoEnabled {
+		tables = append(tables,
+			r.countTable("clicks_by_country", "country", "clicks", r.ClicksByCountry),
+			r.countTable("clicks_by_region", "region", "clicks", r.ClicksByRegion),
+			r.countTable("clicks_by_city", "city", "clicks", r.ClicksByCity),
+		)
+	}
+
+	unknown := ResultTable{Name: "unknown_bitlinks", Header: []string{"bitlink", "domain", "clicks", "first_seen", "last_seen"}}
+	for _, item := range r.sortedAllCounts(r.UnknownBitlinks) {
+		seen := r.UnknownBitlinkSeen[item.Key]
+		unknown.Rows = append(unknown.Rows, []string{
+			tableKey(item.Key), bitlinkDomain(strings.TrimSpace(item.Key)), strconv.Itoa(item.Value), formatSeen(seen.First), formatSeen(seen.Last),
+		})
+	}
+	tables = append(tables, unknown)
+
+	if r.Visitors != nil {
+		tables = append(tables,
+			r.countTable("unique_visitors_by_url", "url", "visitors", r.UniqueVisitorsByURL),
+			r.countTable("unique_visitors_by_referrer", "referrer", "visitors", r.UniqueVisitorsByReferrer),
+			r.bucketTable("unique_visitors_by_date", "visitors", r.UniqueVisitorsByDate),
+		)
+	}
+
+	if r.Pivot != nil {
+		pivot := ResultTable{Name: "pivot"}
+		for _, dimension := range r.Pivot.Dimensions {
+			pivot.Header = append(pivot.Header, string(dimension))
+		}
+		pivot.Header = append(pivot.Header, "clicks")
+		for _, row := range r.Pivot.Rows(PivotOptions{Descending: r.SortDesc}) {
+			pivot.Rows = append(pivot.Rows, append(append([]string{}, row.Values...), strconv.Itoa(row.Clicks)))
+		}
+		tables = append(tables, pivot)
+	}
+	return tables
+}
+
+// countTable turns a count map into a two-column table
+func (r *AggregationResults) countTable(name, keyColumn, countColumn string, counts map[string]int) ResultTable {
+	table := ResultTable{Name: name, Header: []string{keyColumn, countColumn}}
+	for _, item := range r.sortedAllCounts(counts) {
+		table.Rows = append(table.Rows, []string{tableKey(item.Key), strconv.Itoa(item.Value)})
+	}
+	return table
+}
+
+// bucketTable turns counts keyed by time bucket into a date table, oldest bucket
+// first; labels that do not parse sort first, by label
+func (r *AggregationResults) bucketTable(name, countColumn string, counts map[string]int) ResultTable {
+	starts := make(map[string]time.Time, len(counts))
+	labels := make([]string, 0, len(counts))
+	for label := range counts {
+		starts[label], _ = r.Granularity.ParseLabel(label, r.timeLocation())
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if a, b := starts[labels[i]], starts[labels[j]]; !a.Equal(b) {
+			return a.Before(b)
+		}
+		return labels[i] < labels[j]
+	})
+
+	table := ResultTable{Name: name, Header: []string{"date", countColumn}}
+	for _, label := range labels {
+		table.Rows = append(table.Rows, []string{label, strconv.Itoa(counts[label])})
+	}
+	return table
+}
+
+// sortedAllCounts is SortedCounts keeping the empty key, so the counts add up to
+// the total; tables label it with tableKey
+func (r *AggregationResults) sortedAllCounts(counts map[string]int) []KeyValue {
+	items := make([]KeyValue, 0, len(counts))
+	for key, value := range counts {
+		items = append(items, KeyValue{Key: key, Value: value})
+	}
+	sortKeyValues(items, r.SortDesc)
+	return items
+}
+
+// tableKey labels the empty key in a table row
+func tableKey(key string) string {
+	if key == "" {
+		return EmptyKeyLabel
+	}
+	return key
+}
+
+// TableReporter is the csv and tsv Reporter. With Dir set it writes each of
+// ResultTables to its own file there; otherwise it writes them one after another,
+// each introduced by a "# name" line and separated by a blank line.
+type TableReporter struct {
+	Delimiter rune   // ',' for CSV or '\t' for TSV
+	Dir       string // Directory for one file per table, created if needed
+}
+
+// Report writes the tables of results
+func (t TableReporter) Report(w io.Writer, results AggregationResults) error {
+	tables := results.ResultTables()
+	if t.Dir != "" {
+		_, err := WriteTables(t.Dir, tables, t.Delimiter)
+		return err
+	}
+
+	for i, table := range tables {
+		separator := ""
+		if i > 0 {
+			separator = "\n"
+		}
+		if _, err := fmt.Fprintf(w, "%s# %s\n", separator, table.Name); err != nil {
+			return fmt.Errorf("error writing %s: %w", table.Name, err)
+		}
+		if err := WriteTable(w, table, t.Delimiter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTable writes a table with its header row, quoting fields as needed;
+// delimiter is ',' for CSV or '\t' for TSV
+func WriteTable(w io.Writer, table ResultTable, delimiter rune) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = delimiter
+	writer.Write(table.Header)
+	writer.WriteAll(table.Rows) // Flushes
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing %s: %w", table.Name, err)
+	}
+	return nil
+}
+
+// WriteTables writes each of ResultTables to its own file in dir (created if
+// needed) as OutputCSV or OutputTSV, and returns the paths written
+func (a *Aggregator) WriteTables(dir string, format OutputFormat) ([]string, error) {
+	switch format {
+	case OutputCSV:
+		return WriteTables(dir, a.ResultTables(), ',')
+	case OutputTSV:
+		return WriteTables(dir, a.ResultTables(), '\t')
+	}
+	return nil, fmt.Errorf("tables cannot be written as %s", format)
+}
+
+// WriteTables writes each table to its own file in dir (created if needed) as
+// CSV (delimiter ',') or TSV ('\t'), and returns the paths written
+func WriteTables(dir string, tables []ResultTable, delimiter rune) ([]string, error) {
+	extension := ".csv"
+	switch delimiter {
+	case ',':
+	case '\t':
+		extension = ".tsv"
+	default:
+		return nil, fmt.Errorf("tables cannot be written with delimiter %q", delimiter)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %w", err)
+	}
+	var paths []string
+	for _, table := range tables {
+		path := filepath.Join(dir, table.Name+extension)
+		file, err := os.Create(path)
+		if err != nil {
+			return paths, fmt.Errorf("error creating %s: %w", path, err)
+		}
+		if err := WriteTable(file, table, delimiter); err != nil {
+			file.Close()
+			return paths, err
+		}
+		if err := file.Close(); err != nil {
+			return paths, fmt.Errorf("error closing %s: %w", path, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
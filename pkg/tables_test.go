@@ -0,0 +1,184 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// tableTestAggregator has tied counts, a referrer that needs quoting and an unknown bitlink
+func tableTestAggregator(t *testing.T, config AggregationConfig) *Aggregator {
+	mapping := URLMapping{
+		"http://bit.ly/b": "https://b.com/",
+		"http://bit.ly/a": "https://a.com/",
+		"http://bit.ly/c": "https://c.com/",
+	}
+	return aggregateRecords(t, mapping, config, []DecodeRecord{
+		{Bitlink: "http://bit.ly/b", Timestamp: "2021-01-01T00:00:00Z", Referrer: "direct"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T00:00:00Z", Referrer: "direct"},
+		{Bitlink: "http://bit.ly/c", Timestamp: "2021-01-02T00:00:00Z", Referrer: "Newsletter, \"Weekly\"\tIssue 3"},
+		{Bitlink: "http://bit.ly/c", Timestamp: "2021-01-02T00:00:00Z", Referrer: "direct"},
+		{Bitlink: "http://es.pn/x", Timestamp: "2021-01-03T00:00:00Z", Referrer: "direct"},
+	}...)
+}
+
+func TestAggregator_ResultTables(t *testing.T) {
+	tables := tableTestAggregator(t, AggregationConfig{SortDesc: true}).ResultTables()
+
+	var names []string
+	byName := map[string]ResultTable{}
+	for _, table := range tables {
+		names = append(names, table.Name)
+		byName[table.Name] = table
+	}
+	expectedNames := []string{
+		"clicks_by_url", "clicks_by_referrer", "clicks_by_referrer_host", "clicks_by_channel", "clicks_by_date",
+		"clicks_by_browser", "clicks_by_os", "clicks_by_device", "unknown_bitlinks",
+	}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Expected tables %v, got %v", expectedNames, names)
+	}
+
+	// Ties keep key order whichever way counts are sorted
+	urls := byName["clicks_by_url"]
+	expectedURLs := [][]string{{"https://c.com/", "2"}, {"https://a.com/", "1"}, {"https://b.com/", "1"}}
+	if !reflect.DeepEqual(urls.Header, []string{"url", "clicks"}) || !reflect.DeepEqual(urls.Rows, expectedURLs) {
+		t.Errorf("Unexpected clicks_by_url: %v %v", urls.Header, urls.Rows)
+	}
+	ascending := tableTestAggregator(t, AggregationConfig{}).ResultTables()[0]
+	expectedAscending := [][]string{{"https://a.com/", "1"}, {"https://b.com/", "1"}, {"https://c.com/", "2"}}
+	if !reflect.DeepEqual(ascending.Rows, expectedAscending) {
+		t.Errorf("Unexpected ascending clicks_by_url: %v", ascending.Rows)
+	}
+
+	expectedUnknown := [][]string{{"http://es.pn/x", "es.pn", "1", "2021-01-03T00:00:00Z", "2021-01-03T00:00:00Z"}}
+	if rows := byName["unknown_bitlinks"].Rows; !reflect.DeepEqual(rows, expectedUnknown) {
+		t.Errorf("Unexpected unknown_bitlinks: %v", rows)
+	}
+
+	withPivot := tableTestAggregator(t, AggregationConfig{GroupBy: []Dimension{DimensionURL, DimensionDate}}).ResultTables()
+	pivot := withPivot[len(withPivot)-1]
+	if pivot.Name != "pivot" || !reflect.DeepEqual(pivot.Header, []string{"url", "date", "clicks"}) || len(pivot.Rows) != 4 {
+		t.Errorf("Unexpected pivot table: %+v", pivot)
+	}
+}
+
+// Empty keys get a row, so every click table adds up to the total; dates stay chronological
+func TestAggregator_ResultTables_EmptyKeysAndDates(t *testing.T) {
+	aggregator := aggregateRecords(t, URLMapping{"http://bit.ly/a": "https://a.com/"}, AggregationConfig{SortDesc: true}, []DecodeRecord{
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-03T00:00:00Z", Referrer: "t.co"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T00:00:00Z"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-01T00:00:00Z"},
+		{Bitlink: "http://bit.ly/a", Timestamp: "2021-01-10T00:00:00Z"},
+	}...)
+
+	byName := map[string]ResultTable{}
+	for _, table := range aggregator.ResultTables() {
+		byName[table.Name] = table
+	}
+	expectedReferrers := [][]string{{EmptyKeyLabel, "3"}, {"t.co", "1"}}
+	if rows := byName["clicks_by_referrer"].Rows; !reflect.DeepEqual(rows, expectedReferrers) {
+		t.Errorf("Expected %v, got %v", expectedReferrers, rows)
+	}
+	expectedDates := [][]string{{"2021-01-01", "2"}, {"2021-01-03", "1"}, {"2021-01-10", "1"}}
+	if rows := byName["clicks_by_date"].Rows; !reflect.DeepEqual(rows, expectedDates) {
+		t.Errorf("Expected chronological dates %v, got %v", expectedDates, rows)
+	}
+	for _, name := range []string{"clicks_by_url", "clicks_by_referrer", "clicks_by_referrer_host", "clicks_by_channel", "clicks_by_date"} {
+		total := 0
+		for _, row := range byName[name].Rows {
+			clicks, _ := strconv.Atoi(row[1])
+			total += clicks
+		}
+		if total != 4 {
+			t.Errorf("Expected %s to add up to 4 clicks, got %d", name, total)
+		}
+	}
+}
+
+func TestWriteTable_Quoting(t *testing.T) {
+	table := ResultTable{Name: "t", Header: []string{"referrer", "clicks"}, Rows: [][]string{{"a, \"b\"\tc", "1"}}}
+	cases := map[rune]string{
+		',':  "referrer,clicks\n\"a, \"\"b\"\"\tc\",1\n",
+		'\t': "referrer\tclicks\n\"a, \"\"b\"\"\tc\"\t1\n",
+	}
+	for delimiter, expected := range cases {
+		var buf bytes.Buffer
+		if err := WriteTable(&buf, table, delimiter); err != nil {
+			t.Fatalf("WriteTable failed: %v", err)
+		}
+		if buf.String() != expected {
+			t.Errorf("Delimiter %q: expected %q, got %q", delimiter, expected, buf.String())
+		}
+	}
+}
+
+func TestAggregator_WriteTables(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	aggregator := tableTestAggregator(t, AggregationConfig{SortDesc: true})
+
+	paths, err := WriteTables(dir, aggregator.ResultTables(), '\t')
+	if err != nil {
+		t.Fatalf("WriteTables failed: %v", err)
+	}
+	if len(paths) != 9 || paths[1] != filepath.Join(dir, "clicks_by_referrer.tsv") {
+		t.Fatalf("Unexpected paths: %v", paths)
+	}
+
+	file, err := os.Open(paths[1])
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("Reading the TSV back failed: %v", err)
+	}
+	expected := [][]string{{"referrer", "clicks"}, {"direct", "4"}, {"Newsletter, \"Weekly\"\tIssue 3", "1"}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Expected %q, got %q", expected, rows)
+	}
+
+	if _, err := WriteTables(dir, aggregator.ResultTables(), ';'); err == nil {
+		t.Error("Expected error writing tables with a semicolon delimiter, got nil")
+	}
+
+	// The OutputFormat method writes the same files
+	paths, err = aggregator.WriteTables(dir, OutputCSV)
+	if err != nil || len(paths) != 9 || paths[1] != filepath.Join(dir, "clicks_by_referrer.csv") {
+		t.Errorf("Unexpected CSV paths %v (err %v)", paths, err)
+	}
+	if _, err := aggregator.WriteTables(dir, OutputJSON); err == nil {
+		t.Error("Expected error writing tables as JSON, got nil")
+	}
+}
+
+func TestTableReporter_Stream(t *testing.T) {
+	var buf bytes.Buffer
+	results := tableTestAggregator(t, AggregationConfig{SortDesc: true}).GetResults()
+	if err := (TableReporter{Delimiter: ','}).Report(&buf, results); err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	output := buf.String()
+	if !strings.HasPrefix(output, "# clicks_by_url\nurl,clicks\nhttps://c.com/,2\n") {
+		t.Errorf("Unexpected start of stream:\n%s", output)
+	}
+	if !strings.Contains(output, "\n\n# clicks_by_referrer\nreferrer,clicks\n") || strings.Count(output, "\n# ") != 8 {
+		t.Errorf("Expected 9 tables separated by blank lines, got:\n%s", output)
+	}
+
+	dir := filepath.Join(t.TempDir(), "tables")
+	if err := (TableReporter{Delimiter: '\t', Dir: dir}).Report(&buf, results); err != nil {
+		t.Fatalf("Report to a directory failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unknown_bitlinks.tsv")); err != nil {
+		t.Errorf("Expected table files in %s: %v", dir, err)
+	}
+}
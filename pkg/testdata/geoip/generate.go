@@ -0,0 +1,91 @@
+//go:build ignore
+
+// generate writes geoip-test.mmdb, the GeoIP2-City-shaped fixture used by the geoip tests.
+// The networks and locations are made up for testing and are not real geolocation data.
+//
+// It needs github.com/maxmind/mmdbwriter, which the main module does not depend on,
+// so run it from a scratch module:
+//
+//	mkdir /tmp/gen && cp generate.go /tmp/gen && cd /tmp/gen
+//	go mod init gen && go get github.com/maxmind/mmdbwriter@v1.0.0
+//	go run generate.go -out $OLDPWD/../geoip-test.mmdb
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+type location struct {
+	network    string
+	country    string
+	region     string // ISO 3166-2 subdivision code without the country prefix
+	regionName string
+	city       string
+}
+
+var locations = []location{
+	{"4.14.0.0/16", "US", "CA", "California", "San Francisco"},
+	{"65.110.0.0/16", "US", "NY", "New York", "New York"},
+	{"2.203.0.0/16", "DE", "BE", "Berlin", "Berlin"},
+	{"81.2.69.0/24", "GB", "ENG", "England", "London"},
+	{"2001:db8::/32", "JP", "13", "Tokyo", "Tokyo"},
+	{"1.128.0.0/11", "AU", "", "", ""}, // Country-level match only
+}
+
+func main() {
+	out := flag.String("out", "geoip-test.mmdb", "Output path")
+	flag.Parse()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType:            "GeoIP2-City",
+		Description:             map[string]string{"en": "EncodeChallange test fixture"},
+		IncludeReservedNetworks: true,
+		BuildEpoch:              1609459200, // Fixed so regenerating gives identical bytes
+		RecordSize:              24,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, loc := range locations {
+		_, network, err := net.ParseCIDR(loc.network)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		record := mmdbtype.Map{
+			"country": mmdbtype.Map{
+				"iso_code": mmdbtype.String(loc.country),
+				"names":    mmdbtype.Map{"en": mmdbtype.String(loc.country)},
+			},
+		}
+		if loc.region != "" {
+			record["subdivisions"] = mmdbtype.Slice{mmdbtype.Map{
+				"iso_code": mmdbtype.String(loc.region),
+				"names":    mmdbtype.Map{"en": mmdbtype.String(loc.regionName)},
+			}}
+		}
+		if loc.city != "" {
+			record["city"] = mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(loc.city)}}
+		}
+
+		if err := tree.Insert(network, record); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := tree.WriteTo(file); err != nil {
+		log.Fatal(err)
+	}
+}
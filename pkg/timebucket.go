@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Granularity selects the time bucket size used for ClicksByDate
+// The zero value is daily bucketing, matching the original YYYY-MM-DD keys.
+type Granularity int
+
+const (
+	GranularityDay Granularity = iota
+	GranularityHour
+	GranularityWeek // ISO 8601 week, starting Monday
+	GranularityMonth
+	GranularityQuarter
+	GranularityYear
+)
+
+// Label layouts for the granularities that can be expressed as a time layout
+const (
+	hourLabelLayout  = "2006-01-02T15:00Z07:00" // Offset keeps repeated DST hours distinct
+	dayLabelLayout   = "2006-01-02"
+	monthLabelLayout = "2006-01"
+	yearLabelLayout  = "2006"
+)
+
+// String returns the flag-friendly name of the granularity
+func (g Granularity) String() string {
+	switch g {
+	case GranularityHour:
+		return "hour"
+	case GranularityWeek:
+		return "week"
+	case GranularityMonth:
+		return "month"
+	case GranularityQuarter:
+		return "quarter"
+	case GranularityYear:
+		return "year"
+	default:
+		return "day"
+	}
+}
+
+// ParseGranularity converts a flag value into a Granularity
+func ParseGranularity(value string) (Granularity, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "hour", "hourly":
+		return GranularityHour, nil
+	case "", "day", "daily", "date":
+		return GranularityDay, nil
+	case "week", "weekly", "isoweek":
+		return GranularityWeek, nil
+	case "month", "monthly":
+		return GranularityMonth, nil
+	case "quarter", "quarterly":
+		return GranularityQuarter, nil
+	case "year", "yearly":
+		return GranularityYear, nil
+	}
+	return GranularityDay, fmt.Errorf("unknown granularity %q (expected hour, day, week, month, quarter or year)", value)
+}
+
+// Truncate returns the start of the bucket containing t, in t's location
+func (g Granularity) Truncate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	loc := t.Location()
+
+	switch g {
+	case GranularityHour:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, loc)
+	case GranularityWeek:
+		// ISO weeks start on Monday; Go's Weekday has Sunday = 0
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, loc)
+	case GranularityMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	case GranularityQuarter:
+		firstMonth := time.Month((int(month)-1)/3*3 + 1)
+		return time.Date(year, firstMonth, 1, 0, 0, 0, 0, loc)
+	case GranularityYear:
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, loc)
+	}
+}
+
+// Next returns the start of the bucket following the one that starts at start
+func (g Granularity) Next(start time.Time) time.Time {
+	switch g {
+	case GranularityHour:
+		// Absolute hours, so repeated or skipped wall-clock hours around DST still advance
+		return start.Add(time.Hour)
+	case GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	case GranularityQuarter:
+		return start.AddDate(0, 3, 0)
+	case GranularityYear:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Label formats the bucket containing t:
+// hour 2021-03-01T14:00Z (or 2021-11-07T01:00-04:00 outside UTC), day 2021-03-01, week 2021-W09, month 2021-03, quarter 2021-Q1, year 2021
+func (g Granularity) Label(t time.Time) string {
+	switch g {
+	case GranularityHour:
+		return t.Format(hourLabelLayout)
+	case GranularityWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case GranularityMonth:
+		return t.Format(monthLabelLayout)
+	case GranularityQuarter:
+		return fmt.Sprintf("%04d-Q%d", t.Year(), (int(t.Month())-1)/3+1)
+	case GranularityYear:
+		return t.Format(yearLabelLayout)
+	default:
+		return t.Format(dayLabelLayout)
+	}
+}
+
+// ParseLabel returns the start of the bucket named by label, interpreted in loc
+func (g Granularity) ParseLabel(label string, loc *time.Location) (time.Time, error) {
+	switch g {
+	case GranularityHour:
+		// Hour labels carry their own offset; convert so Label round-trips in loc
+		start, err := time.Parse(hourLabelLayout, label)
+		return start.In(loc), err
+	case GranularityWeek:
+		var year, week int
+		if _, err := fmt.Sscanf(label, "%4d-W%2d", &year, &week); err != nil {
+			return time.Time{}, fmt.Errorf("invalid ISO week label %q: %w", label, err)
+		}
+		// January 4th is always in ISO week 1
+		weekOne := g.Truncate(time.Date(year, time.January, 4, 0, 0, 0, 0, loc))
+		return weekOne.AddDate(0, 0, (week-1)*7), nil
+	case GranularityMonth:
+		return time.ParseInLocation(monthLabelLayout, label, loc)
+	case GranularityQuarter:
+		var year, quarter int
+		if _, err := fmt.Sscanf(label, "%4d-Q%1d", &year, &quarter); err != nil {
+			return time.Time{}, fmt.Errorf("invalid quarter label %q: %w", label, err)
+		}
+		return time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, loc), nil
+	case GranularityYear:
+		return time.ParseInLocation(yearLabelLayout, label, loc)
+	default:
+		return time.ParseInLocation(dayLabelLayout, label, loc)
+	}
+}
+
+// TimeBucket is one point of a click time series
+type TimeBucket struct {
+	Start  time.Time `json:"start"` // Start of the bucket
+	Label  string    `json:"label"` // Bucket label, as used for ClicksByDate keys
+	Clicks int       `json:"clicks"`
+}
+
+// buildTimeSeries orders bucketed counts chronologically and zero-fills the gaps
+// between the earliest and latest bucket, so charts get continuous data
+func buildTimeSeries(counts map[string]int, g Granularity, loc *time.Location) ([]TimeBucket, error) {
+	if len(counts) == 0 {
+		return nil, nil
+	}
+
+	starts := make([]time.Time, 0, len(counts))
+	for label := range counts {
+		start, err := g.ParseLabel(label, loc)
+		if err != nil {
+			return nil, err
+		}
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	first, last := starts[0], starts[len(starts)-1]
+	var series []TimeBucket
+	for start := first; !start.After(last); start = g.Next(start) {
+		label := g.Label(start)
+		series = append(series, TimeBucket{Start: start, Label: label, Clicks: counts[label]})
+	}
+	return series, nil
+}
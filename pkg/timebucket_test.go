@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGranularity_Label(t *testing.T) {
+	// Sunday 2021-01-03 belongs to ISO week 53 of 2020
+	ts := time.Date(2021, 1, 3, 14, 35, 0, 0, time.UTC)
+
+	expected := map[Granularity]string{
+		GranularityHour:    "2021-01-03T14:00Z",
+		GranularityDay:     "2021-01-03",
+		GranularityWeek:    "2020-W53",
+		GranularityMonth:   "2021-01",
+		GranularityQuarter: "2021-Q1",
+		GranularityYear:    "2021",
+	}
+
+	for g, label := range expected {
+		if got := g.Label(ts); got != label {
+			t.Errorf("%s label: expected %s, got %s", g, label, got)
+		}
+	}
+}
+
+func TestGranularity_ParseLabelRoundTrip(t *testing.T) {
+	ts := time.Date(2021, 8, 18, 9, 10, 0, 0, time.UTC)
+
+	for _, g := range []Granularity{GranularityHour, GranularityDay, GranularityWeek, GranularityMonth, GranularityQuarter, GranularityYear} {
+		start, err := g.ParseLabel(g.Label(ts), time.UTC)
+		if err != nil {
+			t.Fatalf("%s: ParseLabel failed: %v", g, err)
+		}
+		if !start.Equal(g.Truncate(ts)) {
+			t.Errorf("%s: expected bucket start %v, got %v", g, g.Truncate(ts), start)
+		}
+	}
+}
+
+func TestGranularity_TruncateWeekStartsMonday(t *testing.T) {
+	sunday := time.Date(2021, 3, 7, 23, 0, 0, 0, time.UTC)
+	monday := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	if got := GranularityWeek.Truncate(sunday); !got.Equal(monday) {
+		t.Errorf("Expected week to start on %v, got %v", monday, got)
+	}
+}
+
+func TestParseGranularity(t *testing.T) {
+	for _, name := range []string{"hour", "day", "week", "month", "quarter", "year"} {
+		g, err := ParseGranularity(name)
+		if err != nil {
+			t.Errorf("ParseGranularity(%q) failed: %v", name, err)
+		}
+		if g.String() != name {
+			t.Errorf("Expected %s to round-trip, got %s", name, g)
+		}
+	}
+
+	if _, err := ParseGranularity("fortnight"); err == nil {
+		t.Error("Expected error for unknown granularity, got nil")
+	}
+}
+
+func TestBuildTimeSeries_ZeroFilled(t *testing.T) {
+	counts := map[string]int{
+		"2021-11": 4,
+		"2022-02": 1,
+		"2021-12": 2,
+	}
+
+	series, err := buildTimeSeries(counts, GranularityMonth, time.UTC)
+	if err != nil {
+		t.Fatalf("buildTimeSeries failed: %v", err)
+	}
+
+	var labels []string
+	var clicks []int
+	for _, bucket := range series {
+		labels = append(labels, bucket.Label)
+		clicks = append(clicks, bucket.Clicks)
+	}
+
+	if !reflect.DeepEqual(labels, []string{"2021-11", "2021-12", "2022-01", "2022-02"}) {
+		t.Errorf("Unexpected chronological labels: %v", labels)
+	}
+	if !reflect.DeepEqual(clicks, []int{4, 2, 0, 1}) {
+		t.Errorf("Expected zero-filled clicks [4 2 0 1], got %v", clicks)
+	}
+}
+
+func TestBuildTimeSeries_ISOWeekAcrossYear(t *testing.T) {
+	counts := map[string]int{"2020-W52": 1, "2021-W02": 3}
+
+	series, err := buildTimeSeries(counts, GranularityWeek, time.UTC)
+	if err != nil {
+		t.Fatalf("buildTimeSeries failed: %v", err)
+	}
+
+	var labels []string
+	for _, bucket := range series {
+		labels = append(labels, bucket.Label)
+	}
+	// 2020 has 53 ISO weeks
+	if !reflect.DeepEqual(labels, []string{"2020-W52", "2020-W53", "2021-W01", "2021-W02"}) {
+		t.Errorf("Unexpected ISO week series: %v", labels)
+	}
+}
@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateOnlyLayout is the layout accepted for whole-day range bounds
+const dateOnlyLayout = "2006-01-02"
+
+// TimeRange is a half-open interval [From, To) used to filter clicks
+// A zero From or To leaves that side of the range unbounded
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// IsZero reports whether the range is unbounded on both sides
+func (r TimeRange) IsZero() bool {
+	return r.From.IsZero() && r.To.IsZero()
+}
+
+// YearRange returns the range covering a whole calendar year in loc (nil means UTC)
+func YearRange(year int, loc *time.Location) TimeRange {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return TimeRange{
+		From: time.Date(year, time.January, 1, 0, 0, 0, 0, loc),
+		To:   time.Date(year+1, time.January, 1, 0, 0, 0, 0, loc),
+	}
+}
+
+// IsBefore reports whether t falls before the start of the range
+func (r TimeRange) IsBefore(t time.Time) bool {
+	return !r.From.IsZero() && t.Before(r.From)
+}
+
+// IsAfter reports whether t falls at or after the (exclusive) end of the range
+func (r TimeRange) IsAfter(t time.Time) bool {
+	return !r.To.IsZero() && !t.Before(r.To)
+}
+
+// Contains reports whether t is inside the half-open range
+func (r TimeRange) Contains(t time.Time) bool {
+	return !r.IsBefore(t) && !r.IsAfter(t)
+}
+
+// String renders the range in interval notation, using "-inf"/"+inf" for open sides
+func (r TimeRange) String() string {
+	from, to := "-inf", "+inf"
+	if !r.From.IsZero() {
+		from = r.From.Format(time.RFC3339)
+	}
+	if !r.To.IsZero() {
+		to = r.To.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("[%s, %s)", from, to)
+}
+
+// ParseTimeRange parses -from / -to flag values into a TimeRange
+// Date-only bounds are midnight in loc (nil means UTC).
+// Empty values leave that side unbounded; the range must not be empty.
+func ParseTimeRange(from, to string, now time.Time, loc *time.Location) (TimeRange, error) {
+	var r TimeRange
+	var err error
+
+	if r.From, err = ParseTimeBound(from, now, loc); err != nil {
+		return TimeRange{}, fmt.Errorf("invalid -from: %w", err)
+	}
+	if r.To, err = ParseTimeBound(to, now, loc); err != nil {
+		return TimeRange{}, fmt.Errorf("invalid -to: %w", err)
+	}
+	if !r.From.IsZero() && !r.To.IsZero() && !r.From.Before(r.To) {
+		return TimeRange{}, fmt.Errorf("empty time range: -from %s is not before -to %s", from, to)
+	}
+	return r, nil
+}
+
+// ParseTimeBound parses a single range bound. Accepted forms:
+//   - RFC3339 timestamps, e.g. 2021-03-01T12:00:00Z or 2021-03-01T12:00:00-05:00
+//   - date-only values, e.g. 2021-03-01 (midnight in loc, or UTC when loc is nil)
+//   - "now"
+//   - relative expressions "last N<unit>" with units h, d, w, mo, y (e.g. "last 30d")
+//
+// An empty value returns the zero time (unbounded).
+func ParseTimeBound(value string, now time.Time, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if strings.EqualFold(value, "now") {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(dateOnlyLayout, value, loc); err == nil {
+		return t, nil
+	}
+
+	lower := strings.ToLower(value)
+	if strings.HasPrefix(lower, "last ") {
+		return parseRelativeBound(strings.TrimSpace(lower[len("last "):]), now)
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q (expected RFC3339, YYYY-MM-DD, now or \"last N<unit>\")", value)
+}
+
+// parseRelativeBound resolves an "N<unit>" offset back from now
+func parseRelativeBound(offset string, now time.Time) (time.Time, error) {
+	digits := 0
+	for digits < len(offset) && offset[digits] >= '0' && offset[digits] <= '9' {
+		digits++
+	}
+	amount, err := strconv.Atoi(offset[:digits])
+	if err != nil || amount <= 0 {
+		return time.Time{}, fmt.Errorf("invalid relative time %q: expected a positive count such as 30d", offset)
+	}
+
+	switch unit := strings.TrimSpace(offset[digits:]); unit {
+	case "h":
+		return now.Add(-time.Duration(amount) * time.Hour), nil
+	case "d":
+		return now.AddDate(0, 0, -amount), nil
+	case "w":
+		return now.AddDate(0, 0, -7*amount), nil
+	case "mo":
+		return now.AddDate(0, -amount, 0), nil
+	case "y":
+		return now.AddDate(-amount, 0, 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid relative time unit %q (expected h, d, w, mo or y)", unit)
+	}
+}
@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"", time.Time{}},
+		{"now", now},
+		{"2021-03-01", time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2021-03-01T12:30:00Z", time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{"2021-03-01T12:30:00-05:00", time.Date(2021, 3, 1, 17, 30, 0, 0, time.UTC)},
+		{"last 30d", time.Date(2021, 5, 16, 12, 0, 0, 0, time.UTC)},
+		{"LAST 12h", time.Date(2021, 6, 15, 0, 0, 0, 0, time.UTC)},
+		{"last 2w", time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{"last 3mo", time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)},
+		{"last 1y", time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range cases {
+		got, err := ParseTimeBound(tc.input, now, nil)
+		if err != nil {
+			t.Errorf("ParseTimeBound(%q) failed: %v", tc.input, err)
+			continue
+		}
+		if !got.Equal(tc.expected) {
+			t.Errorf("ParseTimeBound(%q): expected %v, got %v", tc.input, tc.expected, got)
+		}
+	}
+
+	for _, invalid := range []string{"yesterday", "2021-13-01", "last d", "last 0d", "last 5x"} {
+		if _, err := ParseTimeBound(invalid, now, nil); err == nil {
+			t.Errorf("Expected error for %q, got nil", invalid)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Date(2021, 6, 15, 0, 0, 0, 0, time.UTC)
+
+	r, err := ParseTimeRange("2021-01-01", "", now, nil)
+	if err != nil {
+		t.Fatalf("ParseTimeRange failed: %v", err)
+	}
+	if r.From.IsZero() || !r.To.IsZero() {
+		t.Errorf("Expected open-ended range, got %s", r)
+	}
+
+	if _, err := ParseTimeRange("2021-02-01", "2021-01-01", now, nil); err == nil {
+		t.Error("Expected error for -from after -to, got nil")
+	}
+	if _, err := ParseTimeRange("2021-01-01", "2021-01-01", now, nil); err == nil {
+		t.Error("Expected error for empty range, got nil")
+	}
+}
+
+func TestTimeRange_HalfOpen(t *testing.T) {
+	r := TimeRange{
+		From: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	if !r.Contains(r.From) {
+		t.Error("Expected range to include its start")
+	}
+	if r.Contains(r.To) || !r.IsAfter(r.To) {
+		t.Error("Expected range to exclude its end")
+	}
+	if !r.IsBefore(r.From.Add(-time.Second)) {
+		t.Error("Expected one second before the start to be before the range")
+	}
+
+	// Unbounded sides never exclude
+	open := TimeRange{}
+	if !open.IsZero() || !open.Contains(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Expected zero range to contain everything")
+	}
+}
+
+func TestYearRange(t *testing.T) {
+	r := YearRange(2020, nil)
+	if !r.Contains(time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC)) {
+		t.Error("Expected last second of 2020 to be in range")
+	}
+	if r.Contains(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Expected 2021-01-01 to be outside the 2020 range")
+	}
+}
+
+func TestParseTimeRange_DateOnlyInLocation(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Time zone unavailable: %v", err)
+	}
+
+	r, err := ParseTimeRange("2021-01-01", "", time.Now(), berlin)
+	if err != nil {
+		t.Fatalf("ParseTimeRange failed: %v", err)
+	}
+
+	// Midnight in Berlin (CET, UTC+1) is 23:00 UTC the previous day
+	expected := time.Date(2020, 12, 31, 23, 0, 0, 0, time.UTC)
+	if !r.From.Equal(expected) {
+		t.Errorf("Expected range start %v, got %v", expected, r.From.UTC())
+	}
+}
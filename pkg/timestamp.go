@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the built-in timestamp formats, as reported in AggregationResults.TimestampFormats
+const (
+	TimestampRFC3339      = "rfc3339"       // 2021-03-01T12:00:00Z, offsets and fractional seconds included
+	TimestampEpochSeconds = "epoch-seconds" // 1614600000 or 1614600000.250
+	TimestampEpochMillis  = "epoch-millis"  // 1614600000250
+)
+
+// epochMillisThreshold separates epoch seconds from epoch milliseconds by magnitude
+// 1e11 seconds is in the year 5138, while 1e11 milliseconds is in 1973
+const epochMillisThreshold = 1e11
+
+// TimestampParser parses click timestamps in several formats
+// RFC3339 is tried first, then any user-supplied time layouts in order, then Unix epoch
+// seconds or milliseconds; user layouts go before the epoch heuristic so all-digit
+// layouts such as 20060102150405 win. Layouts without a zone are read in the parser's location.
+type TimestampParser struct {
+	layouts  []string
+	location *time.Location
+}
+
+// NewTimestampParser creates a parser that also accepts the given Go time layouts,
+// reading zone-less ones in location (nil means UTC)
+func NewTimestampParser(layouts []string, location *time.Location) *TimestampParser {
+	if location == nil {
+		location = time.UTC
+	}
+	return &TimestampParser{layouts: layouts, location: location}
+}
+
+// Parse parses a timestamp and returns the name of the format that matched
+// User layouts are reported as "layout:<layout>".
+func (p *TimestampParser) Parse(value string) (time.Time, string, error) {
+	value = strings.TrimSpace(value)
+
+	// RFC3339 parsing also accepts fractional seconds
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, TimestampRFC3339, nil
+	}
+
+	for _, layout := range p.layouts {
+		if t, err := time.ParseInLocation(layout, value, p.location); err == nil {
+			return t, "layout:" + layout, nil
+		}
+	}
+
+	if t, format, ok := parseEpoch(value); ok {
+		return t, format, nil
+	}
+
+	return time.Time{}, "", fmt.Errorf("no matching timestamp format for %q (tried %s)", value, strings.Join(p.FormatNames(), ", "))
+}
+
+// FormatNames lists the formats the parser tries, in order
+func (p *TimestampParser) FormatNames() []string {
+	names := []string{TimestampRFC3339}
+	for _, layout := range p.layouts {
+		names = append(names, "layout:"+layout)
+	}
+	return append(names, TimestampEpochSeconds, TimestampEpochMillis)
+}
+
+// parseEpoch parses Unix epoch seconds (optionally fractional) or milliseconds
+// JSON numbers reach here as their literal text, so 1614600000 and "1614600000" are equivalent
+func parseEpoch(value string) (time.Time, string, bool) {
+	if value == "" || !isEpochLiteral(value) {
+		return time.Time{}, "", false
+	}
+
+	if !strings.ContainsAny(value, ".eE") {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, "", false
+		}
+		if n >= epochMillisThreshold || n <= -epochMillisThreshold {
+			return time.UnixMilli(n).UTC(), TimestampEpochMillis, true
+		}
+		return time.Unix(n, 0).UTC(), TimestampEpochSeconds, true
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return time.Time{}, "", false
+	}
+	if math.Abs(f) >= epochMillisThreshold {
+		return time.UnixMilli(int64(math.Round(f))).UTC(), TimestampEpochMillis, true
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), TimestampEpochSeconds, true
+}
+
+// isEpochLiteral reports whether value looks like a JSON number
+func isEpochLiteral(value string) bool {
+	for i, c := range value {
+		switch {
+		case c >= '0' && c <= '9', c == '.', c == 'e', c == 'E':
+		case (c == '-' || c == '+') && (i == 0 || value[i-1] == 'e' || value[i-1] == 'E'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampParser_Parse(t *testing.T) {
+	parser := NewTimestampParser([]string{"2006-01-02 15:04:05", "02/01/2006 15:04 MST"}, nil)
+
+	cases := []struct {
+		value    string
+		expected time.Time
+		format   string
+	}{
+		{"2021-03-01T12:00:00Z", time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), TimestampRFC3339},
+		{"2021-03-01T07:00:00-05:00", time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), TimestampRFC3339},
+		{"2021-03-01T12:00:00.250Z", time.Date(2021, 3, 1, 12, 0, 0, 250e6, time.UTC), TimestampRFC3339},
+		{"1614600000", time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), TimestampEpochSeconds},
+		{"1614600000.5", time.Date(2021, 3, 1, 12, 0, 0, 500e6, time.UTC), TimestampEpochSeconds},
+		{"1614600000250", time.Date(2021, 3, 1, 12, 0, 0, 250e6, time.UTC), TimestampEpochMillis},
+		{"1.6146e12", time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), TimestampEpochMillis},
+		{"2021-03-01 12:00:00", time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), "layout:2006-01-02 15:04:05"},
+		{"01/03/2021 12:00 UTC", time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), "layout:02/01/2006 15:04 MST"},
+	}
+
+	for _, tc := range cases {
+		got, format, err := parser.Parse(tc.value)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tc.value, err)
+			continue
+		}
+		if format != tc.format {
+			t.Errorf("Parse(%q): expected format %s, got %s", tc.value, tc.format, format)
+		}
+		if !got.Equal(tc.expected) {
+			t.Errorf("Parse(%q): expected %s, got %s", tc.value, tc.expected, got)
+		}
+	}
+}
+
+func TestTimestampParser_ParseErrors(t *testing.T) {
+	parser := NewTimestampParser(nil, nil)
+
+	for _, value := range []string{"", "not-a-time", "2021-03-01 12:00:00", "12:00", "1e400", "--5"} {
+		_, _, err := parser.Parse(value)
+		if err == nil {
+			t.Errorf("Parse(%q): expected error, got nil", value)
+			continue
+		}
+		if !strings.Contains(err.Error(), TimestampEpochMillis) {
+			t.Errorf("Parse(%q): expected the tried formats in the error, got %v", value, err)
+		}
+	}
+}
+
+func TestTimestampParser_LayoutsBeforeEpoch(t *testing.T) {
+	parser := NewTimestampParser([]string{"20060102150405"}, nil)
+
+	got, format, err := parser.Parse("20210301150405")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if format != "layout:20060102150405" || !got.Equal(time.Date(2021, 3, 1, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("Expected the layout to win over epoch millis, got %s (%s)", got, format)
+	}
+	// Numbers the layout does not match still fall through to the epoch formats
+	if _, format, err := parser.Parse("1614600000"); err != nil || format != TimestampEpochSeconds {
+		t.Errorf("Expected epoch seconds, got %q, %v", format, err)
+	}
+}
+
+func TestTimestampParser_LayoutLocation(t *testing.T) {
+	parser := NewTimestampParser([]string{"2006-01-02 15:04:05", "2006-01-02 15:04:05 -0700"}, mustLoadLocation(t, "America/New_York"))
+
+	cases := []struct {
+		value    string
+		expected time.Time
+	}{
+		// Zone-less layouts are read in the parser's location
+		{"2021-03-01 12:00:00", time.Date(2021, 3, 1, 17, 0, 0, 0, time.UTC)},
+		// An explicit offset still wins
+		{"2021-03-01 12:00:00 +0000", time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		got, _, err := parser.Parse(tc.value)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tc.value, err)
+			continue
+		}
+		if !got.Equal(tc.expected) {
+			t.Errorf("Parse(%q): expected %s, got %s", tc.value, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SeenRange is when a bitlink was first and last clicked
+type SeenRange struct {
+	First time.Time
+	Last  time.Time
+}
+
+// add widens the range to include t
+func (r SeenRange) add(t time.Time) SeenRange {
+	if r.First.IsZero() || t.Before(r.First) {
+		r.First = t
+	}
+	if r.Last.IsZero() || t.After(r.Last) {
+		r.Last = t
+	}
+	return r
+}
+
+// merge widens the range to include other
+func (r SeenRange) merge(other SeenRange) SeenRange {
+	if other.First.IsZero() {
+		return r
+	}
+	return r.add(other.First).add(other.Last)
+}
+
+// Differences a near match can have from an unknown bitlink
+const (
+	DifferenceScheme        = "scheme"         // http vs https, or no scheme
+	DifferenceCase          = "case"           // Host or hash case
+	DifferenceTrailingSlash = "trailing-slash" // A trailing / on one side only
+	DifferenceWhitespace    = "whitespace"     // Leading or trailing spaces
+)
+
+// NearMatch is a mapped bitlink that likely refers to the same link as an unknown one
+type NearMatch struct {
+	Bitlink     string   `json:"bitlink"`
+	LongURL     string   `json:"long_url"`
+	Differences []string `json:"differences"` // What differs, e.g. scheme and case
+}
+
+// UnknownBitlink is one distinct bitlink missing from the encodes mapping
+type UnknownBitlink struct {
+	Bitlink     string      `json:"bitlink"`
+	Domain      string      `json:"domain"` // Short domain, e.g. es.pn
+	Hash        string      `json:"hash"`
+	Clicks      int         `json:"clicks"`
+	FirstSeen   time.Time   `json:"first_seen"`
+	LastSeen    time.Time   `json:"last_seen"`
+	NearMatches []NearMatch `json:"near_matches,omitempty"` // Mapped bitlinks differing only in scheme, case, trailing slash or whitespace
+}
+
+// UnknownDomain groups the unknown bitlinks of one short domain
+type UnknownDomain struct {
+	Domain   string           `json:"domain"`
+	Clicks   int              `json:"clicks"`
+	Bitlinks []UnknownBitlink `json:"bitlinks"` // Most clicked first
+}
+
+// UnknownBitlinkReport describes the clicked bitlinks missing from the encodes mapping
+type UnknownBitlinkReport struct {
+	Bitlinks int             `json:"bitlinks"`
+	Clicks   int             `json:"clicks"`
+	Domains  []UnknownDomain `json:"domains"` // Most clicked first
+}
+
+// NewUnknownBitlinkReport builds the report from aggregated results, looking for
+// near matches in mapping. Ties are ordered by name so the report is stable.
+func NewUnknownBitlinkReport(results *AggregationResults, mapping URLMapping) *UnknownBitlinkReport {
+	// Index mapped bitlinks by their normalized form once, rather than per unknown bitlink
+	candidates := make(map[string][]string)
+	if len(results.UnknownBitlinks) > 0 {
+		for bitlink := range mapping {
+			key := bitlinkMatchKey(bitlink)
+			candidates[key] = append(candidates[key], bitlink)
+		}
+	}
+
+	report := &UnknownBitlinkReport{Domains: []UnknownDomain{}}
+	domains := make(map[string]*UnknownDomain)
+	for bitlink, clicks := range results.UnknownBitlinks {
+		seen := results.UnknownBitlinkSeen[bitlink]
+		unknown := UnknownBitlink{
+			Bitlink:   bitlink,
+			Domain:    bitlinkDomain(strings.TrimSpace(bitlink)),
+			Hash:      bitlinkHash(bitlink),
+			Clicks:    clicks,
+			FirstSeen: seen.First,
+			LastSeen:  seen.Last,
+		}
+		matches := candidates[bitlinkMatchKey(bitlink)]
+		sort.Strings(matches)
+		for _, match := range matches {
+			unknown.NearMatches = append(unknown.NearMatches, NearMatch{
+				Bitlink:     match,
+				LongURL:     mapping[match],
+				Differences: bitlinkDifferences(bitlink, match),
+			})
+		}
+
+		domain, ok := domains[unknown.Domain]
+		if !ok {
+			domain = &UnknownDomain{Domain: unknown.Domain}
+			domains[unknown.Domain] = domain
+		}
+		domain.Clicks += clicks
+		domain.Bitlinks = append(domain.Bitlinks, unknown)
+		report.Bitlinks++
+		report.Clicks += clicks
+	}
+
+	for _, domain := range domains {
+		sort.Slice(domain.Bitlinks, func(i, j int) bool {
+			a, b := domain.Bitlinks[i], domain.Bitlinks[j]
+			if a.Clicks != b.Clicks {
+				return a.Clicks > b.Clicks
+			}
+			return a.Bitlink < b.Bitlink
+		})
+		report.Domains = append(report.Domains, *domain)
+	}
+	sort.Slice(report.Domains, func(i, j int) bool {
+		a, b := report.Domains[i], report.Domains[j]
+		if a.Clicks != b.Clicks {
+			return a.Clicks > b.Clicks
+		}
+		return a.Domain < b.Domain
+	})
+	return report
+}
+
+// bitlinkMatchKey normalizes a bitlink for near matching: no scheme, surrounding
+// spaces or trailing slash, and lower case
+func bitlinkMatchKey(bitlink string) string {
+	_, rest := splitScheme(strings.TrimSpace(bitlink))
+	return strings.ToLower(strings.TrimRight(rest, "/"))
+}
+
+// splitScheme splits "https://bit.ly/x" into "https" and "bit.ly/x"
+func splitScheme(bitlink string) (scheme, rest string) {
+	if scheme, rest, found := strings.Cut(bitlink, "://"); found {
+		return strings.ToLower(scheme), rest
+	}
+	return "", bitlink
+}
+
+// bitlinkDifferences lists how two bitlinks with the same match key differ
+func bitlinkDifferences(unknown, mapped string) []string {
+	var differences []string
+	if strings.TrimSpace(unknown) != unknown || strings.TrimSpace(mapped) != mapped {
+		differences = append(differences, DifferenceWhitespace)
+	}
+	schemeA, restA := splitScheme(strings.TrimSpace(unknown))
+	schemeB, restB := splitScheme(strings.TrimSpace(mapped))
+	if schemeA != schemeB {
+		differences = append(differences, DifferenceScheme)
+	}
+	if strings.HasSuffix(restA, "/") != strings.HasSuffix(restB, "/") {
+		differences = append(differences, DifferenceTrailingSlash)
+	}
+	if strings.TrimRight(restA, "/") != strings.TrimRight(restB, "/") {
+		differences = append(differences, DifferenceCase)
+	}
+	return differences
+}
+
+// bitlinkHash returns the path of a short link, e.g. 2kkAHNs for http://bit.ly/2kkAHNs
+func bitlinkHash(bitlink string) string {
+	_, rest := splitScheme(strings.TrimSpace(bitlink))
+	_, hash, _ := strings.Cut(rest, "/")
+	return strings.TrimRight(hash, "/")
+}
+
+// WriteUnknownBitlinksCSV exports the report as CSV for review, one row per unknown
+// bitlink, grouped by domain. The first three columns are named like encodes.csv's,
+// but the rows have ten fields, so use WriteUnknownBitlinksBackfill for rows to
+// append there:
+//
+//	long_url,domain,hash,bitlink,clicks,first_seen,last_seen,near_match,near_match_long_url,differences
+//	,es.pn,3MgVNnZ,http://es.pn/3MgVNnZ,521,2020-01-01T00:00:00Z,2021-12-31T23:59:59Z,,,
+func WriteUnknownBitlinksCSV(w io.Writer, report *UnknownBitlinkReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"long_url", "domain", "hash", "bitlink", "clicks", "first_seen", "last_seen", "near_match", "near_match_long_url", "differences"})
+	for _, domain := range report.Domains {
+		for _, unknown := range domain.Bitlinks {
+			var match, matchURL, differences string
+			if len(unknown.NearMatches) > 0 {
+				// The first near match is the suggestion; others are rare enough to leave to the summary
+				match, matchURL = unknown.NearMatches[0].Bitlink, unknown.NearMatches[0].LongURL
+				differences = strings.Join(unknown.NearMatches[0].Differences, "+")
+			}
+			writer.Write([]string{
+				"", unknown.Domain, unknown.Hash, unknown.Bitlink, strconv.Itoa(unknown.Clicks),
+				formatSeen(unknown.FirstSeen), formatSeen(unknown.LastSeen), match, matchURL, differences,
+			})
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing unknown bitlinks: %w", err)
+	}
+	return nil
+}
+
+// WriteUnknownBitlinksBackfill writes the unknown bitlinks that an encodes.csv row
+// can map as long_url,domain,hash rows with long_url left empty, ready to fill in
+// and append to encodes.csv (without the header). Bitlinks clicked with another
+// scheme, case or stray characters are left out: encodes.csv maps only
+// http://domain/hash, so those need fixing where the links were published.
+func WriteUnknownBitlinksBackfill(w io.Writer, report *UnknownBitlinkReport) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"long_url", "domain", "hash"})
+	for _, domain := range report.Domains {
+		for _, unknown := range domain.Bitlinks {
+			if fmt.Sprintf("http://%s/%s", unknown.Domain, unknown.Hash) != unknown.Bitlink {
+				continue
+			}
+			writer.Write([]string{"", unknown.Domain, unknown.Hash})
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing unknown bitlink backfill: %w", err)
+	}
+	return nil
+}
+
+// formatSeen formats a first or last seen time as RFC3339, or "" when unknown
+func formatSeen(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unknownTestReport aggregates clicks on near misses of two mapped bitlinks plus one
+// bitlink that matches nothing
+func unknownTestReport(t *testing.T) *UnknownBitlinkReport {
+	mapping := URLMapping{
+		"http://bit.ly/AbC": "https://a.com/",
+		"http://bit.ly/xyz": "https://x.com/",
+	}
+	return aggregateRecords(t, mapping, AggregationConfig{}, []DecodeRecord{
+		{Bitlink: "https://bit.ly/AbC", Timestamp: "2021-03-01T00:00:00Z"},
+		{Bitlink: "http://es.pn/zzz", Timestamp: "2021-02-01T00:00:00Z"},
+		{Bitlink: "https://bit.ly/AbC", Timestamp: "2021-01-01T00:00:00Z"},
+		{Bitlink: "http://BIT.ly/abc/", Timestamp: "2021-01-02T00:00:00Z"},
+		{Bitlink: " http://bit.ly/xyz", Timestamp: "2021-01-03T00:00:00Z"},
+		{Bitlink: "http://bit.ly/xyz", Timestamp: "2021-01-04T00:00:00Z"}, // Mapped
+	}...).UnknownBitlinkReport()
+}
+
+func TestNewUnknownBitlinkReport(t *testing.T) {
+	report := unknownTestReport(t)
+
+	if report.Bitlinks != 4 || report.Clicks != 5 {
+		t.Errorf("Expected 4 unknown bitlinks with 5 clicks, got %d with %d", report.Bitlinks, report.Clicks)
+	}
+	if len(report.Domains) != 2 || report.Domains[0].Domain != "bit.ly" || report.Domains[0].Clicks != 4 || report.Domains[1].Domain != "es.pn" {
+		t.Fatalf("Expected bit.ly (4 clicks) then es.pn, got %+v", report.Domains)
+	}
+
+	top := report.Domains[0].Bitlinks[0]
+	expected := UnknownBitlink{
+		Bitlink:   "https://bit.ly/AbC",
+		Domain:    "bit.ly",
+		Hash:      "AbC",
+		Clicks:    2,
+		FirstSeen: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		LastSeen:  time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		NearMatches: []NearMatch{
+			{Bitlink: "http://bit.ly/AbC", LongURL: "https://a.com/", Differences: []string{DifferenceScheme}},
+		},
+	}
+	if !reflect.DeepEqual(top, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, top)
+	}
+
+	differences := map[string][]string{}
+	for _, unknown := range report.Domains[0].Bitlinks[1:] {
+		if len(unknown.NearMatches) != 1 {
+			t.Fatalf("Expected one near match for %q, got %+v", unknown.Bitlink, unknown.NearMatches)
+		}
+		differences[unknown.Bitlink] = unknown.NearMatches[0].Differences
+	}
+	expectedDifferences := map[string][]string{
+		"http://BIT.ly/abc/": {DifferenceTrailingSlash, DifferenceCase},
+		" http://bit.ly/xyz": {DifferenceWhitespace},
+	}
+	if !reflect.DeepEqual(differences, expectedDifferences) {
+		t.Errorf("Expected differences %v, got %v", expectedDifferences, differences)
+	}
+	if matches := report.Domains[1].Bitlinks[0].NearMatches; matches != nil {
+		t.Errorf("Expected no near match for es.pn, got %+v", matches)
+	}
+}
+
+func TestWriteUnknownBitlinksCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteUnknownBitlinksCSV(&buf, unknownTestReport(t)); err != nil {
+		t.Fatalf("WriteUnknownBitlinksCSV failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected a header and 4 rows, got:\n%s", buf.String())
+	}
+	expected := ",bit.ly,AbC,https://bit.ly/AbC,2,2021-01-01T00:00:00Z,2021-03-01T00:00:00Z,http://bit.ly/AbC,https://a.com/,scheme"
+	if lines[1] != expected {
+		t.Errorf("Expected first row %q, got %q", expected, lines[1])
+	}
+	if lines[4] != ",es.pn,zzz,http://es.pn/zzz,1,2021-02-01T00:00:00Z,2021-02-01T00:00:00Z,,," {
+		t.Errorf("Unexpected es.pn row %q", lines[4])
+	}
+
+}
+
+func TestWriteUnknownBitlinksBackfill(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteUnknownBitlinksBackfill(&buf, unknownTestReport(t)); err != nil {
+		t.Fatalf("WriteUnknownBitlinksBackfill failed: %v", err)
+	}
+	// Only es.pn/zzz can be mapped; the bit.ly clicks are variants of mapped bitlinks
+	if buf.String() != "long_url,domain,hash\n,es.pn,zzz\n" {
+		t.Fatalf("Unexpected backfill:\n%s", buf.String())
+	}
+
+	// Fill in long_url and append the rows to an encodes.csv
+	_, rows, _ := strings.Cut(buf.String(), "\n")
+	encodes := "long_url,domain,hash\nhttps://a.com/,bit.ly,AbC\n" + strings.ReplaceAll(rows, ",es.pn,", "https://z.com/,es.pn,")
+	mapping, err := ReadEncodesMappingsFrom(strings.NewReader(encodes))
+	if err != nil {
+		t.Fatalf("ReadEncodesMappingsFrom failed: %v", err)
+	}
+	aggregator := NewAggregator(mapping, AggregationConfig{})
+	if err := aggregator.ProcessRecord(DecodeRecord{Bitlink: "http://es.pn/zzz", Timestamp: "2021-02-01T00:00:00Z"}); err != nil {
+		t.Fatalf("ProcessRecord failed: %v", err)
+	}
+	if results := aggregator.GetResults(); results.ClicksByURL["https://z.com/"] != 1 || len(results.UnknownBitlinks) != 0 {
+		t.Errorf("Expected the backfilled bitlink to be mapped, got %v (unknown %v)", results.ClicksByURL, results.UnknownBitlinks)
+	}
+}
@@ -0,0 +1,207 @@
+package pkg
+
+import "strings"
+
+// DeviceClass is the broad kind of device a click came from
+type DeviceClass int
+
+const (
+	DeviceUnknown DeviceClass = iota
+	DeviceDesktop
+	DeviceMobile
+	DeviceTablet
+	DeviceBot
+)
+
+// String returns the label used for ClicksByDevice keys
+func (d DeviceClass) String() string {
+	switch d {
+	case DeviceDesktop:
+		return "desktop"
+	case DeviceMobile:
+		return "mobile"
+	case DeviceTablet:
+		return "tablet"
+	case DeviceBot:
+		return "bot"
+	default:
+		return "unknown"
+	}
+}
+
+// Browser and OS family names for user agents that cannot be classified
+const (
+	UserAgentUnknown = "Unknown" // Empty user agent
+	UserAgentOther   = "Other"   // Present but not recognised
+)
+
+// UserAgent is the classification of a User-Agent header
+type UserAgent struct {
+	Browser        string // Browser family, e.g. "Chrome", or the app for in-app browsers, e.g. "Facebook"
+	BrowserVersion string // Full version as reported, e.g. "75.0.3770.142" ("" when absent)
+	OS             string // OS family, e.g. "Windows", "iOS", "Android"
+	Device         DeviceClass
+	InApp          bool // Browser is an app's embedded web view
+}
+
+// uaToken maps a product token in the user agent to a browser family
+// The version is read from the text following the token.
+type uaToken struct {
+	token  string
+	family string
+}
+
+// inAppTokens identify apps that open links in an embedded browser; they are
+// checked first because those user agents also name the underlying engine
+var inAppTokens = []uaToken{
+	{"FBAN/MessengerForiOS", "Messenger"},
+	{"FBAV/", "Facebook"}, // [FBAN/FB4A;FBAV/23.0.0.22.14;]
+	{"FBAN/", "Facebook"},
+	{"FB_IAB/", "Facebook"},
+	{"Instagram ", "Instagram"},
+	{"LinkedInApp", "LinkedIn"},
+	{"Twitter for", "Twitter"},
+	{"Snapchat/", "Snapchat"},
+	{"GSA/", "Google Search App"},
+}
+
+// browserTokens are checked in order; browsers that also send "Chrome/" or
+// "Safari/" for compatibility must come before Chrome and Safari
+var browserTokens = []uaToken{
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Opera/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"CriOS/", "Chrome"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"MSIE ", "Internet Explorer"},
+	{"Chromium/", "Chromium"},
+	{"Chrome/", "Chrome"},
+}
+
+// botMarkers identify automated clients by user agent alone (case-insensitive)
+var botMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "headlesschrome", "facebookexternalhit",
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client", "java/", "okhttp", "httpclient",
+}
+
+// ParseUserAgent classifies a User-Agent header into browser, OS and device class
+// Matching is by well-known product tokens, so it works offline and is cheap enough
+// to run per record; unrecognised agents are reported as "Other".
+func ParseUserAgent(header string) UserAgent {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return UserAgent{Browser: UserAgentUnknown, OS: UserAgentUnknown, Device: DeviceUnknown}
+	}
+
+	ua := UserAgent{OS: parseOSFamily(header)}
+	if isBotUserAgent(header) {
+		ua.Browser, ua.Device = "Bot", DeviceBot
+		return ua
+	}
+
+	ua.Browser, ua.BrowserVersion, ua.InApp = parseBrowser(header)
+	ua.Device = parseDeviceClass(header, ua.OS)
+	return ua
+}
+
+// isBotUserAgent reports whether the user agent names a crawler or scripted client
+func isBotUserAgent(header string) bool {
+	lower := strings.ToLower(header)
+	for _, marker := range botMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBrowser returns the browser family, its version and whether it is an in-app browser
+func parseBrowser(header string) (string, string, bool) {
+	for _, t := range inAppTokens {
+		if i := strings.Index(header, t.token); i >= 0 {
+			return t.family, tokenVersion(header[i+len(t.token):]), true
+		}
+	}
+
+	for _, t := range browserTokens {
+		if i := strings.Index(header, t.token); i >= 0 {
+			return t.family, tokenVersion(header[i+len(t.token):]), false
+		}
+	}
+
+	// IE 11 dropped the MSIE token
+	if strings.Contains(header, "Trident/") {
+		if i := strings.Index(header, "rv:"); i >= 0 {
+			return "Internet Explorer", tokenVersion(header[i+len("rv:"):]), false
+		}
+		return "Internet Explorer", "", false
+	}
+
+	// Safari and the pre-Chrome Android browser both report their version in Version/
+	if strings.Contains(header, "Safari/") {
+		version := ""
+		if i := strings.Index(header, "Version/"); i >= 0 {
+			version = tokenVersion(header[i+len("Version/"):])
+		}
+		if strings.Contains(header, "Android") {
+			return "Android Browser", version, false
+		}
+		return "Safari", version, false
+	}
+
+	return UserAgentOther, "", false
+}
+
+// tokenVersion reads the dotted version number at the start of s
+func tokenVersion(s string) string {
+	end := 0
+	for end < len(s) && (s[end] == '.' || (s[end] >= '0' && s[end] <= '9')) {
+		end++
+	}
+	return strings.TrimRight(s[:end], ".")
+}
+
+// parseOSFamily returns the operating system family
+// Apple mobile devices are checked first because they also claim "like Mac OS X",
+// Windows Phone before Android because it claims both, and Android before Linux
+func parseOSFamily(header string) string {
+	switch {
+	case strings.Contains(header, "iPhone"), strings.Contains(header, "iPad"), strings.Contains(header, "iPod"):
+		return "iOS"
+	case strings.Contains(header, "Windows Phone"):
+		return "Windows Phone"
+	case strings.Contains(header, "Android"):
+		return "Android"
+	case strings.Contains(header, "Windows"):
+		return "Windows"
+	case strings.Contains(header, "CrOS"):
+		return "Chrome OS"
+	case strings.Contains(header, "Mac OS X"), strings.Contains(header, "Macintosh"):
+		return "macOS"
+	case strings.Contains(header, "Linux"), strings.Contains(header, "X11"):
+		return "Linux"
+	}
+	return UserAgentOther
+}
+
+// parseDeviceClass infers the device class
+// Android phones send "Mobile"; Android agents without it are tablets.
+func parseDeviceClass(header, os string) DeviceClass {
+	switch {
+	case strings.Contains(header, "iPad"), strings.Contains(header, "Tablet"):
+		return DeviceTablet
+	case strings.Contains(header, "Mobi"), strings.Contains(header, "iPhone"), strings.Contains(header, "iPod"),
+		os == "Windows Phone":
+		return DeviceMobile
+	case os == "Android":
+		return DeviceTablet
+	case os == UserAgentOther:
+		return DeviceUnknown
+	}
+	return DeviceDesktop
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		header   string
+		expected UserAgent
+	}{
+		// User agents from data/decodes.json
+		{
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_6_8) AppleWebKit/537.13+ (KHTML, like Gecko) Version/5.1.7 Safari/534.57.2",
+			UserAgent{Browser: "Safari", BrowserVersion: "5.1.7", OS: "macOS", Device: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (iPhone; U; CPU iPhone OS 5_1_1 like Mac OS X; en) AppleWebKit/534.46.0 (KHTML, like Gecko) CriOS/19.0.1084.60 Mobile/9B206 Safari/7534.48.3",
+			UserAgent{Browser: "Chrome", BrowserVersion: "19.0.1084.60", OS: "iOS", Device: DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (Android; Tablet; rv:30.0) Gecko/30.0 Firefox/30.0",
+			UserAgent{Browser: "Firefox", BrowserVersion: "30.0", OS: "Android", Device: DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (Linux; U; Android 4.3; en-us; HUAWEI Y530-U00 Build/HuaweiY530-U00) AppleWebKit/534.30 (KHTML, like Gecko) Version/4.0 Mobile Safari/534.30 [FBAN/FB4A;FBAV/23.0.0.22.14;]",
+			UserAgent{Browser: "Facebook", BrowserVersion: "23.0.0.22.14", OS: "Android", Device: DeviceMobile, InApp: true},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3770.142 Safari/537.36",
+			UserAgent{Browser: "Chrome", BrowserVersion: "75.0.3770.142", OS: "Windows", Device: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (iPad; U; CPU OS 3_2 like Mac OS X; en-us) AppleWebKit/531.21.10 (KHTML, like Gecko) Version/4.0.4 Mobile/7B334b Safari/531.21.10",
+			UserAgent{Browser: "Safari", BrowserVersion: "4.0.4", OS: "iOS", Device: DeviceTablet},
+		},
+		{
+			"Mozilla/5.0 (compatible; MSIE 9.0; Windows NT 6.1; WOW64; Trident/5.0; SLCC2; Media Center PC 6.0; InfoPath.3; MS-RTC LM 8; Zune 4.7",
+			UserAgent{Browser: "Internet Explorer", BrowserVersion: "9.0", OS: "Windows", Device: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Linux; Android 10; Pixel 2 Build/QP1A.190711.020; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/76.0.3809.132 Mobile Safari/537.36 GSA/10.49.11.21.arm64",
+			UserAgent{Browser: "Google Search App", BrowserVersion: "10.49.11.21", OS: "Android", Device: DeviceMobile, InApp: true},
+		},
+		{
+			"Mozilla/5.0 (X11; Linux i686; rv:10.0) Gecko/20100101 Firefox/10.0",
+			UserAgent{Browser: "Firefox", BrowserVersion: "10.0", OS: "Linux", Device: DeviceDesktop},
+		},
+		// Other common agents
+		{
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			UserAgent{Browser: "Edge", BrowserVersion: "120.0.2210.91", OS: "Windows", Device: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Windows NT 10.0; WOW64; Trident/7.0; rv:11.0) like Gecko",
+			UserAgent{Browser: "Internet Explorer", BrowserVersion: "11.0", OS: "Windows", Device: DeviceDesktop},
+		},
+		{
+			"Mozilla/5.0 (Linux; U; Android 4.0.3; ko-kr; LG-L160L Build/IML74K) AppleWebkit/534.30 (KHTML, like Gecko) Version/4.0 Mobile Safari/534.30",
+			UserAgent{Browser: "Android Browser", BrowserVersion: "4.0", OS: "Android", Device: DeviceMobile},
+		},
+		{
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 16_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 Instagram 250.0.0.21.109",
+			UserAgent{Browser: "Instagram", BrowserVersion: "250.0.0.21.109", OS: "iOS", Device: DeviceMobile, InApp: true},
+		},
+		{
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{Browser: "Bot", OS: UserAgentOther, Device: DeviceBot},
+		},
+		{
+			"curl/8.4.0",
+			UserAgent{Browser: "Bot", OS: UserAgentOther, Device: DeviceBot},
+		},
+		{
+			"SomeClient",
+			UserAgent{Browser: UserAgentOther, OS: UserAgentOther, Device: DeviceUnknown},
+		},
+		{
+			"",
+			UserAgent{Browser: UserAgentUnknown, OS: UserAgentUnknown, Device: DeviceUnknown},
+		},
+	}
+
+	for _, tc := range cases {
+		if got := ParseUserAgent(tc.header); got != tc.expected {
+			t.Errorf("ParseUserAgent(%q):\nexpected %+v\ngot      %+v", tc.header, tc.expected, got)
+		}
+	}
+}
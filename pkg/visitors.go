@@ -0,0 +1,209 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VisitorKey selects what identifies a unique visitor
+type VisitorKey int
+
+const (
+	VisitorKeyIP          VisitorKey = iota // Remote IP address
+	VisitorKeyIPUserAgent                   // Remote IP and user agent, separating devices behind one NAT
+)
+
+// String returns the flag-friendly name of the key
+func (k VisitorKey) String() string {
+	if k == VisitorKeyIPUserAgent {
+		return "ip+ua"
+	}
+	return "ip"
+}
+
+// ParseVisitorKey converts a flag value into a VisitorKey
+func ParseVisitorKey(value string) (VisitorKey, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "ip":
+		return VisitorKeyIP, nil
+	case "ip+ua", "ip+user-agent":
+		return VisitorKeyIPUserAgent, nil
+	}
+	return VisitorKeyIP, fmt.Errorf("unknown visitor key %q (expected ip or ip+ua)", value)
+}
+
+// VisitorConfig enables unique visitor counting
+// Clicks without a remote IP cannot be attributed to a visitor and are not counted.
+type VisitorConfig struct {
+	Key       VisitorKey
+	Precision uint8 // HyperLogLog precision, MinHLLPrecision to MaxHLLPrecision (0 means DefaultHLLPrecision)
+	Exact     bool  // Count exactly instead of estimating; memory grows with the number of visitors
+}
+
+// visitorCounter counts distinct visitor hashes
+// It counts exactly while small and switches to a HyperLogLog sketch once the set
+// would outgrow it, so the many rarely clicked URLs and referrers stay cheap.
+type visitorCounter struct {
+	exact     bool
+	precision uint8
+	set       map[uint64]struct{} // Until the sketch is created
+	sketch    *HyperLogLog
+}
+
+func newVisitorCounter(exact bool, precision uint8) *visitorCounter {
+	return &visitorCounter{exact: exact, precision: precision, set: make(map[uint64]struct{})}
+}
+
+// add records one visitor hash
+func (c *visitorCounter) add(hash uint64) {
+	if c.sketch != nil {
+		c.sketch.Add(hash)
+		return
+	}
+	c.set[hash] = struct{}{}
+	c.promoteIfLarge()
+}
+
+// merge folds in another counter with the same configuration
+func (c *visitorCounter) merge(other *visitorCounter) {
+	if other.sketch != nil {
+		if c.sketch == nil {
+			c.promote()
+		}
+		c.sketch.mergeRegisters(other.sketch)
+	}
+	for hash := range other.set {
+		c.add(hash)
+	}
+}
+
+// count returns the (estimated) number of distinct visitors
+func (c *visitorCounter) count() int {
+	if c.sketch != nil {
+		return int(c.sketch.Estimate())
+	}
+	return len(c.set)
+}
+
+// standardError returns the relative standard error of the counter's estimates
+func (c *visitorCounter) standardError() float64 {
+	if c.exact {
+		return 0
+	}
+	return hllStandardError(c.precision)
+}
+
+// promoteIfLarge switches to a sketch once the set passes 1/16 of the register count,
+// roughly where the hashes take as much memory as the registers
+func (c *visitorCounter) promoteIfLarge() {
+	if !c.exact && len(c.set) > 1<<c.precision/16 {
+		c.promote()
+	}
+}
+
+// promote moves the exact set into a new sketch
+func (c *visitorCounter) promote() {
+	c.sketch = newSketch(c.precision)
+	for hash := range c.set {
+		c.sketch.Add(hash)
+	}
+	c.set = nil
+}
+
+// visitorTracker keeps the visitor counters for the whole run and per dimension
+type visitorTracker struct {
+	config     VisitorConfig
+	total      *visitorCounter
+	byURL      map[string]*visitorCounter
+	byReferrer map[string]*visitorCounter
+	byDate     map[string]*visitorCounter
+}
+
+// newVisitorTracker creates an empty tracker; out-of-range precisions are clamped
+func newVisitorTracker(config VisitorConfig) *visitorTracker {
+	switch {
+	case config.Precision == 0:
+		config.Precision = DefaultHLLPrecision
+	case config.Precision < MinHLLPrecision:
+		config.Precision = MinHLLPrecision
+	case config.Precision > MaxHLLPrecision:
+		config.Precision = MaxHLLPrecision
+	}
+	return &visitorTracker{
+		config:     config,
+		total:      newVisitorCounter(config.Exact, config.Precision),
+		byURL:      make(map[string]*visitorCounter),
+		byReferrer: make(map[string]*visitorCounter),
+		byDate:     make(map[string]*visitorCounter),
+	}
+}
+
+// visitorHash identifies the visitor of a click; ok is false when the click has no IP
+func (t *visitorTracker) visitorHash(record DecodeRecord) (hash uint64, ok bool) {
+	ip := strings.TrimSpace(record.RemoteIP)
+	if ip == "" {
+		return 0, false
+	}
+	if t.config.Key == VisitorKeyIPUserAgent {
+		return hashString(ip + "\x00" + record.UserAgent), true
+	}
+	return hashString(ip), true
+}
+
+// add counts a visitor against the run and the click's URL, referrer and date bucket
+func (t *visitorTracker) add(hash uint64, url, referrer, bucket string) {
+	t.total.add(hash)
+	t.counter(t.byURL, url).add(hash)
+	t.counter(t.byReferrer, referrer).add(hash)
+	t.counter(t.byDate, bucket).add(hash)
+}
+
+// counter returns the counter for key, creating it on first use
+func (t *visitorTracker) counter(counters map[string]*visitorCounter, key string) *visitorCounter {
+	counter, ok := counters[key]
+	if !ok {
+		counter = newVisitorCounter(t.config.Exact, t.config.Precision)
+		counters[key] = counter
+	}
+	return counter
+}
+
+// merge folds in another tracker with the same configuration
+func (t *visitorTracker) merge(other *visitorTracker) {
+	t.total.merge(other.total)
+	for _, dimension := range []struct{ dst, src map[string]*visitorCounter }{
+		{t.byURL, other.byURL},
+		{t.byReferrer, other.byReferrer},
+		{t.byDate, other.byDate},
+	} {
+		for key, counter := range dimension.src {
+			t.counter(dimension.dst, key).merge(counter)
+		}
+	}
+}
+
+// fill stores the current counts in results
+func (t *visitorTracker) fill(results *AggregationResults) {
+	results.UniqueVisitors = t.total.count()
+	results.UniqueVisitorsByURL = visitorCounts(t.byURL)
+	results.UniqueVisitorsByReferrer = visitorCounts(t.byReferrer)
+	results.UniqueVisitorsByDate = visitorCounts(t.byDate)
+}
+
+// visitorCounts estimates every counter in a dimension
+func visitorCounts(counters map[string]*visitorCounter) map[string]int {
+	counts := make(map[string]int, len(counters))
+	for key, counter := range counters {
+		counts[key] = counter.count()
+	}
+	return counts
+}
+
+// standardError returns the relative standard error of visitor counts under this
+// configuration; 0 when counting exactly
+func (c VisitorConfig) standardError() float64 {
+	if c.Exact {
+		return 0
+	}
+	return hllStandardError(c.Precision)
+}